@@ -0,0 +1,273 @@
+package gochronos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The version of the binary layout written by MarshalBinary. Layouts are only ever extended, so a
+// newer version can still read older data.
+const binaryVersion = 4
+
+// Flags of the binary layout.
+const (
+	binaryRecurring = 1 << iota
+	binaryAlignToDay
+	binaryFixedDelay
+	binaryBackoff
+	binaryBusiness
+	binaryWeekly
+	binaryISOWeeks // since version 2
+	binaryISOOdd
+	binarySeasons    // since version 3
+	binaryByMonthDay // since version 4
+)
+
+// Returned when unmarshalling binary data that isn't a valid time specification.
+var errBadBinary = errors.New("gochronos: malformed binary time spec")
+
+// Marshal the time specification to a compact binary layout, e.g. for storing in a database
+// column. The first byte is the version of the layout. Times keep their instant and offset from
+// UTC, but not the name of their location. This is also used by encoding/gob.
+func (t *TimeSpec) MarshalBinary() ([]byte, error) {
+	// in the order of the flags
+	flags := 0
+	for i, set := range []bool{t.recurring, t.alignToDay, t.fixedDelay, t.backoff, t.business != nil, t.weekly != nil, t.isoWeeks, t.isoOdd, len(t.seasons) > 0, len(t.byMonthDay) > 0} {
+		if set {
+			flags |= 1 << i
+		}
+	}
+
+	b := []byte{binaryVersion}
+	b = binary.AppendUvarint(b, uint64(flags))
+	b = appendTime(b, t.when)
+	b = binary.AppendUvarint(b, uint64(len(t.times)))
+	for _, at := range t.times {
+		b = appendTime(b, at)
+	}
+	b = appendTime(b, t.startTime)
+	b = appendTime(b, t.endTime)
+	for _, n := range []int{t.frequency, t.interval, t.maxNum} {
+		b = binary.AppendVarint(b, int64(n))
+	}
+
+	days := make([]int, len(t.byDay))
+	for i, d := range t.byDay {
+		days[i] = int(d)
+	}
+	for _, list := range [][]int{days, t.byHour, t.byMinute} {
+		b = appendInts(b, list)
+	}
+	for _, d := range []time.Duration{t.delay, t.backoffBase, t.backoffMax, t.every} {
+		b = binary.AppendVarint(b, int64(d))
+	}
+
+	if h := t.business; h != nil {
+		b = binary.AppendUvarint(b, uint64(len(h.loc.String())))
+		b = append(b, h.loc.String()...)
+		for _, d := range []time.Duration{h.open, h.close, h.every} {
+			b = binary.AppendVarint(b, int64(d))
+		}
+	}
+	if w := t.weekly; w != nil {
+		for _, offsets := range w.days {
+			b = binary.AppendUvarint(b, uint64(len(offsets)))
+			for _, d := range offsets {
+				b = binary.AppendVarint(b, int64(d))
+			}
+		}
+	}
+	if len(t.seasons) > 0 {
+		days := make([]int, 0, 2*len(t.seasons))
+		for _, s := range t.seasons {
+			days = append(days, s.from, s.to)
+		}
+		b = appendInts(b, days)
+	}
+	if len(t.byMonthDay) > 0 {
+		b = appendInts(b, t.byMonthDay)
+	}
+	return b, nil
+}
+
+// Unmarshal a time specification from the binary layout written by MarshalBinary.
+func (t *TimeSpec) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errBadBinary
+	}
+	if data[0] > binaryVersion {
+		return fmt.Errorf("gochronos: binary time spec version %d is not supported", data[0])
+	}
+	r := &binaryReader{r: bytes.NewReader(data[1:])}
+
+	flags := r.uvarint()
+	ts := TimeSpec{
+		recurring:  flags&binaryRecurring != 0,
+		alignToDay: flags&binaryAlignToDay != 0,
+		fixedDelay: flags&binaryFixedDelay != 0,
+		backoff:    flags&binaryBackoff != 0,
+		isoWeeks:   flags&binaryISOWeeks != 0,
+		isoOdd:     flags&binaryISOOdd != 0,
+	}
+	ts.when = r.time()
+	if n := r.count(); n > 0 {
+		ts.times = make([]time.Time, n)
+		for i := range ts.times {
+			ts.times[i] = r.time()
+		}
+	}
+	ts.startTime = r.time()
+	ts.endTime = r.time()
+	ts.frequency = int(r.varint())
+	ts.interval = int(r.varint())
+	ts.maxNum = int(r.varint())
+
+	for _, d := range r.ints() {
+		ts.byDay = append(ts.byDay, time.Weekday(d))
+	}
+	ts.byHour = r.ints()
+	ts.byMinute = r.ints()
+	ts.delay = time.Duration(r.varint())
+	ts.backoffBase = time.Duration(r.varint())
+	ts.backoffMax = time.Duration(r.varint())
+	ts.every = time.Duration(r.varint())
+
+	if flags&binaryBusiness != 0 {
+		name := make([]byte, r.count())
+		r.read(name)
+		h := &businessHours{}
+		h.open = time.Duration(r.varint())
+		h.close = time.Duration(r.varint())
+		h.every = time.Duration(r.varint())
+		if r.err == nil {
+			if h.loc, r.err = time.LoadLocation(string(name)); r.err == nil {
+				ts.business = h
+			}
+		}
+	}
+	if flags&binaryWeekly != 0 {
+		w := &weeklySchedule{}
+		for i := range w.days {
+			for n := r.count(); n > 0; n-- {
+				w.days[i] = append(w.days[i], time.Duration(r.varint()))
+			}
+		}
+		ts.weekly = w
+	}
+	if flags&binarySeasons != 0 {
+		days := r.ints()
+		if len(days)%2 != 0 {
+			r.err = errBadBinary
+		}
+		for i := 0; i+1 < len(days); i += 2 {
+			ts.seasons = append(ts.seasons, season{days[i], days[i+1]})
+		}
+	}
+	if flags&binaryByMonthDay != 0 {
+		ts.byMonthDay = r.ints()
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	*t = ts
+	return nil
+}
+
+// Append a time as seconds and nanoseconds since the epoch, and its offset from UTC in seconds.
+// The zero time is a single zero byte.
+func appendTime(b []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendUvarint(b, 0)
+	}
+	_, offset := t.Zone()
+	b = binary.AppendUvarint(b, 1)
+	b = binary.AppendVarint(b, t.Unix())
+	b = binary.AppendUvarint(b, uint64(t.Nanosecond()))
+	return binary.AppendVarint(b, int64(offset))
+}
+
+// Append a length-prefixed list of ints.
+func appendInts(b []byte, list []int) []byte {
+	b = binary.AppendUvarint(b, uint64(len(list)))
+	for _, n := range list {
+		b = binary.AppendVarint(b, int64(n))
+	}
+	return b
+}
+
+// Reads the binary layout, remembering the first error, after which reads return zero values.
+type binaryReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		r.err = errBadBinary
+	}
+	return n
+}
+
+func (r *binaryReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	n, err := binary.ReadVarint(r.r)
+	if err != nil {
+		r.err = errBadBinary
+	}
+	return n
+}
+
+// Read a length, which can't be longer than the data that remains.
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(r.r.Len()) {
+		r.err = errBadBinary
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) read(b []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, err := r.r.Read(b); err != nil && len(b) > 0 {
+		r.err = errBadBinary
+	}
+}
+
+func (r *binaryReader) ints() []int {
+	n := r.count()
+	if n == 0 {
+		return nil
+	}
+	list := make([]int, n)
+	for i := range list {
+		list[i] = int(r.varint())
+	}
+	return list
+}
+
+func (r *binaryReader) time() time.Time {
+	if r.uvarint() == 0 {
+		return time.Time{}
+	}
+	sec := r.varint()
+	nsec := r.uvarint()
+	offset := r.varint()
+	t := time.Unix(sec, int64(nsec))
+	if offset == 0 {
+		return t.UTC()
+	}
+	return t.In(time.FixedZone("", int(offset)))
+}
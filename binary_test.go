@@ -0,0 +1,76 @@
+package gochronos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.FixedZone("NZDT", 13*3600))
+	specs := map[string]*TimeSpec{
+		"one-off": NewOneOff(start),
+		"recurring": NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"endtime":   start.AddDate(0, 6, 0),
+			"frequency": FREQ_WEEK,
+			"interval":  2,
+			"byday":     []string{"mo", "fr"},
+			"byhour":    []int{9, 17},
+			"byminute":  []int{0, 30},
+			"maxnum":    10,
+		}),
+		"times":    NewTimes(start, start.Add(time.Hour)),
+		"backoff":  NewBackoff(start, time.Second, time.Minute),
+		"weekly":   NewWeeklySchedule(map[string][]string{"mo": {"09:00"}, "we": {"14:00", "08:15"}}),
+		"business": NewBusinessHours(time.UTC, "09:00", "17:00", time.Hour),
+		"iso weeks": NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_WEEK,
+		}).WithISOWeekParity(false),
+		"seasons": NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_DAY,
+		}).WithActiveMonths(time.December).WithActiveRange("03-15", "04-01"),
+		"bymonthday": NewRecurring(map[string]interface{}{
+			"starttime":  start,
+			"frequency":  FREQ_MONTH,
+			"bymonthday": []int{1, -1},
+		}),
+	}
+
+	for name, ts := range specs {
+		data, err := ts.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: unexpected error marshalling: %s", name, err)
+		}
+		if data[0] != binaryVersion {
+			t.Errorf("%s: expected version byte %d, got %d", name, binaryVersion, data[0])
+		}
+		var restored TimeSpec
+		if err := restored.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%s: unexpected error unmarshalling: %s", name, err)
+		}
+		if !restored.Equal(ts) {
+			t.Errorf("%s: expected restored spec %s, got %s", name, ts, &restored)
+		}
+
+		// truncated data is rejected
+		if err := restored.UnmarshalBinary(data[:len(data)/2]); err == nil {
+			t.Errorf("%s: expected error unmarshalling truncated data", name)
+		}
+	}
+
+	// the binary form is more compact than JSON
+	ts := specs["recurring"]
+	data, _ := ts.MarshalBinary()
+	if js, _ := ts.MarshalJSON(); len(data) >= len(js) {
+		t.Errorf("Expected binary form to be smaller than JSON, got %d and %d bytes", len(data), len(js))
+	}
+
+	// data from a newer version is refused rather than misread
+	data[0] = binaryVersion + 1
+	var restored TimeSpec
+	if err := restored.UnmarshalBinary(data); err == nil {
+		t.Errorf("Expected error unmarshalling an unknown version")
+	}
+}
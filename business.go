@@ -0,0 +1,115 @@
+package gochronos
+
+import (
+	"fmt"
+	"time"
+)
+
+// Opening hours on weekdays in a time zone, during which a business hours time spec executes at a
+// fixed interval.
+type businessHours struct {
+	loc   *time.Location
+	open  time.Duration // offset from midnight
+	close time.Duration // offset from midnight
+	every time.Duration
+}
+
+// Create a new time specification that executes every everyN within business hours, i.e. from
+// open until close, given as "HH:MM", on weekdays in the time zone tz. The first execution each
+// day is at opening time. Other exclusions, such as a lunch break, can be added with WithBlackout.
+// Panics if open or close is malformed.
+func NewBusinessHours(tz *time.Location, openHHMM, closeHHMM string, everyN time.Duration) *TimeSpec {
+	open, err := parseClock(openHHMM)
+	if err != nil {
+		panic(err.Error())
+	}
+	close, err := parseClock(closeHHMM)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &TimeSpec{
+		recurring: true,
+		business:  &businessHours{loc: tz, open: open, close: close, every: everyN},
+		interval:  1,
+		maxNum:    -1,
+	}
+}
+
+// Parse a time of day given as "HH:MM" to an offset from midnight.
+func parseClock(hhmm string) (time.Duration, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, fmt.Errorf("gochronos: time of day %q must be HH:MM", hhmm)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Format an offset from midnight as "HH:MM".
+func clock(offset time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
+
+// Check that the hours are consistent.
+func (b *businessHours) validate() error {
+	if b.loc == nil {
+		return fmt.Errorf("gochronos: business hours must have a time zone")
+	}
+	if b.every <= 0 {
+		return fmt.Errorf("gochronos: business hours interval must be positive, got %s", b.every)
+	}
+	if b.close <= b.open {
+		return fmt.Errorf("gochronos: business hours must close after they open")
+	}
+	return nil
+}
+
+// The next execution after now.
+func (b *businessHours) nextAfter(now time.Time) time.Time {
+	local := now.In(b.loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, b.loc)
+
+	// a week always contains a business day
+	for i := 0; i < 8; i++ {
+		if wd := day.Weekday(); wd != time.Saturday && wd != time.Sunday {
+			open := b.at(day, b.open)
+			close := b.at(day, b.close)
+			next := open
+			if !now.Before(open) {
+				n := now.Sub(open)/b.every + 1
+				next = open.Add(n * b.every)
+			}
+			if next.Before(close) {
+				return next
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// The latest execution at or before now.
+func (b *businessHours) previousBefore(now time.Time) time.Time {
+	local := now.In(b.loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, b.loc)
+
+	for i := 0; i < 8; i++ {
+		if wd := day.Weekday(); wd != time.Saturday && wd != time.Sunday {
+			open := b.at(day, b.open)
+			if !now.Before(open) {
+				last := b.at(day, b.close).Add(-time.Nanosecond)
+				if now.Before(last) {
+					last = now
+				}
+				return open.Add(last.Sub(open) / b.every * b.every)
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return time.Time{}
+}
+
+// The time on day at the given offset from midnight, by the clock, so that it is unaffected by
+// daylight saving changes.
+func (b *businessHours) at(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, b.loc)
+}
@@ -0,0 +1,62 @@
+package gochronos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBusinessHours(t *testing.T) {
+	tz, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("Time zone database is not available: %s", err)
+	}
+
+	// Friday
+	start := time.Date(2024, 1, 5, 0, 0, 0, 0, tz)
+	v := NewVirtualScheduler(start)
+	ts := NewBusinessHours(tz, "09:00", "17:00", 2*time.Hour)
+	if err := ts.Validate(); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	var fired, firedWithLunch []time.Time
+	v.Add(ts, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+	v.Add(ts, func(args ...interface{}) {
+		firedWithLunch = append(firedWithLunch, v.Now())
+	}, WithBlackout(TimeWindow{Start: 12 * time.Hour, End: 14 * time.Hour}))
+
+	// through the weekend to the end of Monday
+	v.AdvanceTo(start.AddDate(0, 0, 4))
+
+	var expected, expectedWithLunch []time.Time
+	for _, day := range []int{5, 8} {
+		for _, hour := range []int{9, 11, 13, 15} {
+			at := time.Date(2024, 1, day, hour, 0, 0, 0, tz)
+			expected = append(expected, at)
+			if hour != 13 {
+				expectedWithLunch = append(expectedWithLunch, at)
+			}
+		}
+	}
+
+	check := func(name string, got, expected []time.Time) {
+		if len(got) != len(expected) {
+			t.Errorf("%s: expected %v, got %v", name, expected, got)
+			return
+		}
+		for i := range expected {
+			if !got[i].Equal(expected[i]) {
+				t.Errorf("%s: expected %v, got %v", name, expected, got)
+				return
+			}
+		}
+	}
+	check("business hours", fired, expected)
+	check("business hours with lunch", firedWithLunch, expectedWithLunch)
+
+	if err := NewBusinessHours(tz, "17:00", "09:00", time.Hour).Validate(); err == nil {
+		t.Errorf("Expected closing before opening to be invalid")
+	}
+}
@@ -0,0 +1,53 @@
+package gochronos
+
+import (
+	"time"
+)
+
+// A Clock provides the current time to a scheduler. Execution times are evaluated against
+// the scheduler's clock, which makes it possible to substitute a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// The clock used by default, which is the system clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Use the given clock to determine the current time, instead of the system clock.
+func WithClock(c Clock) SchedulerOption {
+	return func(s *Scheduler) {
+		s.clock = c
+	}
+}
+
+// Evaluate the default schedule in loc.
+func SetLocation(loc *time.Location) {
+	defaultScheduler.SetLocation(loc)
+}
+
+// Re-evaluate when every action in the default schedule next executes.
+func RecomputeAll() {
+	defaultScheduler.RecomputeAll()
+}
+
+// Evaluate the schedule in loc, rather than the location of the clock's times, so that specs
+// aligned to the wall clock, such as by-* rules, follow loc. nil, the default, uses the clock's
+// location. Actions already in the schedule keep their next execution until RecomputeAll is called.
+func (s *Scheduler) SetLocation(loc *time.Location) {
+	s.locationLock.Lock()
+	s.location = loc
+	s.locationLock.Unlock()
+}
+
+// Re-evaluate when every action in the schedule next executes, under the current time zone state,
+// e.g. after the scheduler's location is changed with SetLocation, or the process's time zone
+// database is reloaded, so that the process doesn't need to be restarted.
+func (s *Scheduler) RecomputeAll() {
+	for _, sa := range s.ordered() {
+		sa.reevaluate()
+	}
+}
@@ -0,0 +1,75 @@
+package gochronos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A job in a schedule config, as loaded by LoadConfig.
+type configJob struct {
+	Name   string   `json:"name"`
+	Tags   []string `json:"tags,omitempty"`
+	Rule   string   `json:"rule"`
+	Action string   `json:"action"`
+}
+
+// Load a declarative schedule config into the default schedule.
+func LoadConfig(r io.Reader) ([]*ScheduledAction, error) {
+	return defaultScheduler.LoadConfig(r)
+}
+
+// Load a declarative schedule config, adding its jobs to the schedule. The config is a JSON array
+// of jobs, each with a name, optional tags, a rule and the name of an action registered with
+// RegisterAction, e.g.
+//
+//	[{"name": "report", "tags": ["daily"], "rule": "0 9 * * 1-5", "action": "send-report"}]
+//
+// The rule is a cron expression as accepted by NewCron, or an iCalendar recurrence rule such as
+// "FREQ=DAILY;BYHOUR=9;BYMINUTE=0". Rules that don't give a start start from now. If any job
+// is invalid or its action isn't registered, an error is returned and nothing is added. The
+// scheduled actions are returned in the order of their jobs.
+func (s *Scheduler) LoadConfig(r io.Reader) ([]*ScheduledAction, error) {
+	var jobs []configJob
+	if err := json.NewDecoder(r).Decode(&jobs); err != nil {
+		return nil, err
+	}
+
+	actions := make([]*ScheduledAction, 0, len(jobs))
+	for _, job := range jobs {
+		if _, ok := registeredAction(job.Action); !ok {
+			return nil, fmt.Errorf("gochronos: action %q of job %q is not registered", job.Action, job.Name)
+		}
+		ts, err := parseRuleSafely(job.Rule, s)
+		if err == nil {
+			err = ts.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gochronos: job %q: %s", job.Name, strings.TrimPrefix(err.Error(), "gochronos: "))
+		}
+		sa := NewScheduledAction(ts, nil, nil)
+		WithRegisteredAction(job.Action)(sa)
+		WithName(job.Name)(sa)
+		WithTags(job.Tags...)(sa)
+		actions = append(actions, sa)
+	}
+
+	for _, sa := range actions {
+		if err := s.addToSchedule(sa); err != nil {
+			return nil, err
+		}
+	}
+	return actions, nil
+}
+
+// Parse a job's rule, starting from the scheduler's current time, turning the panics of an
+// invalid recurrence into errors.
+func parseRuleSafely(rule string, s *Scheduler) (ts *TimeSpec, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return parseRule(rule, s.now())
+}
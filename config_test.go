@@ -0,0 +1,86 @@
+package gochronos
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) // a Monday
+	v := NewVirtualScheduler(start)
+
+	var fired []string
+	RegisterAction("config-report", func(args ...interface{}) {
+		fired = append(fired, "report "+v.Now().Format("Mon 15:04"))
+	})
+	RegisterAction("config-cleanup", func(args ...interface{}) {
+		fired = append(fired, "cleanup "+v.Now().Format("Mon 15:04"))
+	})
+
+	config := `[
+		{"name": "report", "tags": ["daily", "sales"], "rule": "0 9 * * 1-5", "action": "config-report"},
+		{"name": "cleanup", "rule": "FREQ=WEEKLY;BYDAY=SU;BYHOUR=3;BYMINUTE=30", "action": "config-cleanup"}
+	]`
+	actions, err := v.LoadConfig(strings.NewReader(config))
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %s", err)
+	}
+	if len(actions) != 2 || v.Count() != 2 {
+		t.Fatalf("Expected 2 actions to be scheduled, got %d", v.Count())
+	}
+
+	report := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"byminute":  0,
+		"byhour":    9,
+		"byday":     []string{"mo", "tu", "we", "th", "fr"},
+	})
+	if !actions[0].When.Equal(report) {
+		t.Errorf("Expected report to be scheduled %s, got %s", report, actions[0].When)
+	}
+	if actions[0].Name() != "report" || !reflect.DeepEqual(actions[0].Tags(), []string{"daily", "sales"}) {
+		t.Errorf("Expected report's name and tags, got %q and %v", actions[0].Name(), actions[0].Tags())
+	}
+	cleanup := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"byday":     "su",
+		"byhour":    3,
+		"byminute":  30,
+	})
+	if !actions[1].When.Equal(cleanup) {
+		t.Errorf("Expected cleanup to be scheduled %s, got %s", cleanup, actions[1].When)
+	}
+
+	v.Advance(7 * 24 * time.Hour)
+	expected := []string{
+		"report Mon 09:00", "report Tue 09:00", "report Wed 09:00", "report Thu 09:00",
+		"report Fri 09:00", "cleanup Sun 03:30",
+	}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected executions %v, got %v", expected, fired)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	RegisterAction("config-noop", func(args ...interface{}) {})
+	configs := []string{
+		`[{"name": "a", "rule": "0 9 * * 1-5", "action": "config-missing"}]`,
+		`[{"name": "b", "rule": "0 25 * * *", "action": "config-noop"}]`,
+		`[{"name": "c", "rule": "FREQ=FORTNIGHTLY", "action": "config-noop"}]`,
+		`[{"name": "d", "rule": "INTERVAL=2", "action": "config-noop"}]`,
+		`[{"name": "ok", "rule": "@daily", "action": "config-noop"}, {"name": "e", "rule": "0 9 1 * *", "action": "config-noop"}]`,
+	}
+	for _, config := range configs {
+		v := NewVirtualScheduler(time.Now())
+		if _, err := v.LoadConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %s", config)
+		}
+		if v.Count() != 0 {
+			t.Errorf("Expected nothing to be added loading %s, got %d actions", config, v.Count())
+		}
+	}
+}
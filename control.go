@@ -0,0 +1,138 @@
+package gochronos
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// An operation that controls a scheduled action, for use with ScheduledAction.Control.
+type ControlOp int
+
+const (
+	// Cancel the action, as Remove does
+	CONTROL_CANCEL ControlOp = 1 + iota
+	// Pause the action, as Pause does
+	CONTROL_PAUSE
+	// Resume a paused action, as Resume does
+	CONTROL_RESUME
+	// Re-evaluate when the action next executes, e.g. after its time spec has been modified
+	CONTROL_REEVALUATE
+	// Snooze the action until the time.Time, or for the time.Duration, given as the payload
+	CONTROL_SNOOZE
+	// Execute the action now, as Fire does
+	CONTROL_FIRE
+
+	// the first op allocated by RegisterControl
+	firstCustomControl
+)
+
+var controlNames = map[ControlOp]string{
+	CONTROL_CANCEL:     "cancel",
+	CONTROL_PAUSE:      "pause",
+	CONTROL_RESUME:     "resume",
+	CONTROL_REEVALUATE: "reevaluate",
+	CONTROL_SNOOZE:     "snooze",
+	CONTROL_FIRE:       "fire",
+}
+
+func (op ControlOp) String() string {
+	controlLock.Lock()
+	defer controlLock.Unlock()
+	if name, ok := controlNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("op %d", int(op))
+}
+
+// Returned by Control for an op that is neither built in nor registered.
+var ErrUnknownControl = errors.New("gochronos: unknown control op")
+
+// Handlers of ops registered with RegisterControl, and the op that will be allocated next.
+var (
+	controlHandlers = make(map[ControlOp]func(*ScheduledAction, interface{}) error)
+	nextControl     = firstCustomControl
+	controlLock     sync.Mutex
+)
+
+// Register a custom control op under name, returning the op to pass to Control. When the op is
+// sent to an action, handler is called with the action and the payload, and its error is returned
+// by Control.
+func RegisterControl(name string, handler func(sa *ScheduledAction, payload interface{}) error) ControlOp {
+	controlLock.Lock()
+	defer controlLock.Unlock()
+	op := nextControl
+	nextControl++
+	controlNames[op] = name
+	controlHandlers[op] = handler
+	return op
+}
+
+// Control the action with one of the CONTROL_* ops, or an op registered with RegisterControl,
+// passing payload to ops that take one. This gives integrations a single, uniform way to
+// signal actions. Returns ErrUnknownControl for an op that doesn't exist, or an error if the
+// payload isn't what the op expects.
+func (sa *ScheduledAction) Control(op ControlOp, payload interface{}) error {
+	switch op {
+	case CONTROL_CANCEL:
+		Remove(sa)
+	case CONTROL_PAUSE:
+		sa.Pause()
+	case CONTROL_RESUME:
+		sa.Resume()
+	case CONTROL_REEVALUATE:
+		sa.reevaluate()
+	case CONTROL_SNOOZE:
+		switch p := payload.(type) {
+		case time.Time:
+			sa.Snooze(p)
+		case time.Duration:
+			if sa.scheduler == nil {
+				return nil
+			}
+			sa.Snooze(sa.scheduler.now().Add(p))
+		default:
+			return fmt.Errorf("gochronos: snooze needs a time.Time or time.Duration, got %T", payload)
+		}
+	case CONTROL_FIRE:
+		sa.Fire()
+	default:
+		controlLock.Lock()
+		handler, ok := controlHandlers[op]
+		controlLock.Unlock()
+		if !ok {
+			return ErrUnknownControl
+		}
+		return handler(sa, payload)
+	}
+	return nil
+}
+
+// Snooze the action until the given time. Occurrences before then don't happen, and the action
+// carries on with its schedule from then.
+func (sa *ScheduledAction) Snooze(until time.Time) {
+	sa.lock.Lock()
+	sa.snoozedUntil = until
+	sa.lock.Unlock()
+	sa.reevaluate()
+}
+
+// The time the action is snoozed until, or the zero time.
+func (sa *ScheduledAction) snoozed() time.Time {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.snoozedUntil
+}
+
+// Re-evaluate when the action next executes.
+func (sa *ScheduledAction) reevaluate() {
+	if sa.scheduler != nil {
+		sa.scheduler.invalidateUpcoming(sa)
+	}
+	if sa.driven() {
+		sa.reschedule(sa.nextAfter(sa.scheduler.now()))
+	} else if sa.timed() {
+		sa.send(CMD_UPDATE_TIME)
+	}
+}
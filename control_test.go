@@ -0,0 +1,106 @@
+package gochronos
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+
+	var fired []time.Time
+	sa := v.Add(hourly, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+	v.Advance(30 * time.Minute)
+
+	if err := sa.Control(CONTROL_PAUSE, nil); err != nil {
+		t.Fatalf("Unexpected error pausing: %s", err)
+	}
+	if sa.State() != STATE_PAUSED {
+		t.Errorf("Expected action to be paused, state is %s", sa.State())
+	}
+	v.Advance(2 * time.Hour)
+	resumedAt := v.Now()
+
+	if err := sa.Control(CONTROL_RESUME, nil); err != nil {
+		t.Fatalf("Unexpected error resuming: %s", err)
+	}
+	v.Advance(time.Hour)
+
+	if err := sa.Control(CONTROL_CANCEL, nil); err != nil {
+		t.Fatalf("Unexpected error cancelling: %s", err)
+	}
+	<-sa.Done()
+	v.Advance(2 * time.Hour)
+
+	expected := []time.Time{resumedAt, start.Add(3 * time.Hour)}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected executions at %v, got %v", expected, fired)
+	}
+	if sa.State() != STATE_DONE {
+		t.Errorf("Expected action to be done, state is %s", sa.State())
+	}
+}
+
+func TestControlSnooze(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+
+	var fired []time.Time
+	sa := v.Add(hourly, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+	v.Advance(30 * time.Minute)
+
+	if err := sa.Control(CONTROL_SNOOZE, 2*time.Hour); err != nil {
+		t.Fatalf("Unexpected error snoozing: %s", err)
+	}
+	v.Advance(3 * time.Hour)
+
+	// the occurrences at 1:00 and 2:00 are snoozed
+	expected := []time.Time{start.Add(3 * time.Hour)}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected executions at %v, got %v", expected, fired)
+	}
+
+	if err := sa.Control(CONTROL_SNOOZE, "tomorrow"); err == nil {
+		t.Errorf("Expected an error snoozing with an invalid payload")
+	}
+}
+
+func TestControlCustom(t *testing.T) {
+	v := NewVirtualScheduler(time.Now())
+	sa := v.Add(NewOneOff(v.Now().Add(time.Hour)), func(args ...interface{}) {})
+
+	if err := sa.Control(ControlOp(-1), nil); err != ErrUnknownControl {
+		t.Errorf("Expected ErrUnknownControl for an unknown op, got %v", err)
+	}
+
+	failed := errors.New("rejected")
+	var received interface{}
+	op := RegisterControl("annotate", func(sa *ScheduledAction, payload interface{}) error {
+		received = payload
+		return failed
+	})
+	if op.String() != "annotate" {
+		t.Errorf("Expected op to be named annotate, got %s", op)
+	}
+	if err := sa.Control(op, "note"); err != failed {
+		t.Errorf("Expected the handler's error, got %v", err)
+	}
+	if received != "note" {
+		t.Errorf("Expected the handler to receive the payload, got %v", received)
+	}
+}
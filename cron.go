@@ -0,0 +1,558 @@
+package gochronos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shorthands for common five-field cron expressions.
+var cronMacros = map[string]string{
+	"@hourly": "0 * * * *",
+	"@daily":  "0 0 * * *",
+	"@weekly": "0 0 * * 0",
+}
+
+// Create a new recurring time specification from a five-field cron expression,
+// "minute hour day-of-month month day-of-week", e.g. "0 9 * * 1-5" for 9am on weekdays. Fields
+// can be *, a number, a range such as 1-5, a step such as */15, or a comma-separated list of
+// these. The day of month and month must be *. The @hourly, @daily and @weekly shorthands are
+// also accepted. Panics if the expression is malformed.
+func NewCron(expr string) *TimeSpec {
+	ts, err := parseCron(expr, time.Now())
+	if err != nil {
+		panic(err.Error())
+	}
+	return ts
+}
+
+// Create a new recurring time specification from an RFC 5545 iCalendar recurrence rule, as
+// ParseRRule does. Panics if the rule is malformed or unsupported.
+func NewRecurringFromRRule(rrule string) *TimeSpec {
+	ts, err := ParseRRule(rrule)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ts
+}
+
+// Parse an RFC 5545 iCalendar recurrence rule to a recurring time specification, e.g.
+// "FREQ=MONTHLY;BYMONTHDAY=1,15;BYHOUR=9;BYMINUTE=0" for 9am on the 1st and 15th of each month,
+// so rules from calendar systems can be used directly. The FREQ, INTERVAL, BYDAY, BYMONTHDAY,
+// BYHOUR, BYMINUTE, COUNT, UNTIL and WKST parts are supported, in any case, and the rule may have
+// an RRULE: prefix and be preceded by a DTSTART line, which may have a TZID. As in iCalendar,
+// units the rule doesn't give are taken from its start, which is now if there is no DTSTART, and
+// COUNT counts occurrences from the start. Returns an error if the rule is malformed, or uses
+// something that isn't supported: ordinal days such as BYDAY=1MO, an INTERVAL with BY parts of a
+// coarser unit than FREQ, e.g. FREQ=DAILY;INTERVAL=2;BYDAY=MO, or a WKST other than MO where it
+// matters.
+func ParseRRule(rrule string) (*TimeSpec, error) {
+	return parseRRule(rrule, time.Now().Truncate(time.Second))
+}
+
+// Parse a schedule rule, which is either a cron expression as accepted by NewCron, or an
+// iCalendar recurrence rule such as "FREQ=WEEKLY;BYDAY=MO;BYHOUR=9;BYMINUTE=0". Rules without a
+// start of their own start from start.
+func parseRule(rule string, start time.Time) (*TimeSpec, error) {
+	rule = strings.TrimSpace(rule)
+	if upper := strings.ToUpper(rule); strings.HasPrefix(upper, "DTSTART") || strings.HasPrefix(upper, "RRULE:") || strings.Contains(upper, "FREQ=") {
+		return parseRRule(rule, start)
+	}
+	return parseCron(rule, start)
+}
+
+// Parse a cron expression, starting from the minute containing start.
+func parseCron(expr string, start time.Time) (*TimeSpec, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("gochronos: cron expression %q must have 5 fields", expr)
+	}
+	if fields[2] != "*" || fields[3] != "*" {
+		return nil, fmt.Errorf("gochronos: cron expression %q must have * for day of month and month", expr)
+	}
+
+	ts := &TimeSpec{
+		recurring: true,
+		startTime: start.Truncate(time.Minute),
+		frequency: FREQ_MINUTE,
+		interval:  1,
+		maxNum:    -1,
+	}
+	var err error
+	if ts.byMinute, err = cronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if ts.byHour, err = cronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	days, err := cronField(fields[4], 0, 7)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range days {
+		// both 0 and 7 are Sunday
+		if day := time.Weekday(d % 7); !containsDay(ts.byDay, day) {
+			ts.byDay = append(ts.byDay, day)
+		}
+	}
+	if len(ts.byDay) == 7 {
+		ts.byDay = nil
+	}
+
+	// the frequency is that of the coarsest restricted field, and finer fields that are * must
+	// then allow every value, as they would otherwise be taken from the start time
+	switch {
+	case len(ts.byDay) > 0:
+		ts.frequency = FREQ_WEEK
+	case len(ts.byHour) > 0:
+		ts.frequency = FREQ_DAY
+	case len(ts.byMinute) > 0:
+		ts.frequency = FREQ_HOUR
+	}
+	if ts.byHour == nil && ts.frequency >= FREQ_DAY {
+		ts.byHour = allInts(0, 23)
+	}
+	if ts.byMinute == nil && ts.frequency >= FREQ_HOUR {
+		ts.byMinute = allInts(0, 59)
+	}
+	return ts, nil
+}
+
+// The ints from min to max.
+func allInts(min, max int) []int {
+	ints := make([]int, 0, max-min+1)
+	for i := min; i <= max; i++ {
+		ints = append(ints, i)
+	}
+	return ints
+}
+
+// Parse a cron field to the values it allows, between min and max. nil is returned for *.
+func cronField(field string, min, max int) ([]int, error) {
+	if field == "*" {
+		return nil, nil
+	}
+	var values []int
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("gochronos: invalid cron step in %q", field)
+			}
+			step = n
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("gochronos: invalid cron field %q", field)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("gochronos: invalid cron field %q", field)
+				}
+			}
+		}
+		if from < min || to > max || from > to {
+			return nil, fmt.Errorf("gochronos: cron field %q must be between %d and %d", field, min, max)
+		}
+		for v := from; v <= to; v += step {
+			if !containsInt(values, v) {
+				values = append(values, v)
+			}
+		}
+	}
+	return values, nil
+}
+
+// RRULE frequency names, as FREQ_* constants.
+var rruleFreqs = map[string]int{
+	"SECONDLY": FREQ_SECOND,
+	"MINUTELY": FREQ_MINUTE,
+	"HOURLY":   FREQ_HOUR,
+	"DAILY":    FREQ_DAY,
+	"WEEKLY":   FREQ_WEEK,
+	"MONTHLY":  FREQ_MONTH,
+	"YEARLY":   FREQ_YEAR,
+}
+
+// The layouts of RRULE date-times: UTC, floating local time, and dates.
+var rruleTimes = []string{"20060102T150405Z", "20060102T150405", "20060102"}
+
+// Parse an RRULE date-time in any of its layouts. UTC times end with Z; floating times and dates
+// are in loc.
+func parseRRuleTime(value string, loc *time.Location) (time.Time, error) {
+	value = strings.ToUpper(value)
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+	}
+	var err error
+	for _, layout := range rruleTimes {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Parse a DTSTART line, e.g. "DTSTART:20240101T090000Z", or with a time zone,
+// "DTSTART;TZID=Europe/London:20240101T090000". Floating times without a TZID are local.
+func parseDTStart(line string) (time.Time, error) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return time.Time{}, fmt.Errorf("gochronos: invalid DTSTART %q", line)
+	}
+	loc := time.Local
+	for _, param := range strings.Split(line[:i], ";")[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		switch {
+		case len(kv) == 2 && strings.ToUpper(kv[0]) == "TZID":
+			var err error
+			if loc, err = time.LoadLocation(kv[1]); err != nil {
+				return time.Time{}, fmt.Errorf("gochronos: unknown DTSTART time zone %q", kv[1])
+			}
+		case len(kv) == 2 && strings.ToUpper(kv[0]) == "VALUE":
+			// dates and date-times are told apart by their layouts
+		default:
+			return time.Time{}, fmt.Errorf("gochronos: unsupported DTSTART parameter %q", param)
+		}
+	}
+	t, err := parseRRuleTime(line[i+1:], loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("gochronos: invalid DTSTART %q", line)
+	}
+	return t, nil
+}
+
+// Parse an iCalendar recurrence rule, as described by ParseRRule. The rule may be preceded by a
+// DTSTART line giving its start, and otherwise starts from start.
+func parseRRule(rule string, start time.Time) (*TimeSpec, error) {
+	var parts []string
+	for _, line := range strings.Fields(rule) {
+		if strings.HasPrefix(strings.ToUpper(line), "DTSTART") {
+			var err error
+			if start, err = parseDTStart(line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if strings.HasPrefix(strings.ToUpper(line), "RRULE:") {
+			line = line[len("RRULE:"):]
+		}
+		parts = append(parts, strings.Split(line, ";")...)
+	}
+
+	config := map[string]interface{}{"starttime": start}
+	count := 0
+	wkst := "MO"
+	for _, part := range parts {
+		kv := strings.SplitN(strings.ToUpper(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("gochronos: invalid rule part %q", part)
+		}
+		switch kv[0] {
+		case "COUNT":
+			var err error
+			if count, err = strconv.Atoi(kv[1]); err != nil || count < 1 {
+				return nil, fmt.Errorf("gochronos: invalid rule part %s", part)
+			}
+		case "WKST":
+			if _, err := dayList([]string{kv[1]}); err != nil {
+				return nil, err
+			}
+			wkst = kv[1]
+		default:
+			if err := rrulePart(config, kv[0], kv[1], start.Location()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, ok := config["frequency"]; !ok {
+		return nil, fmt.Errorf("gochronos: rule %q must have a FREQ", rule)
+	}
+	if _, ok := config["endtime"]; ok && count > 0 {
+		return nil, fmt.Errorf("gochronos: rule %q can't have both COUNT and UNTIL", rule)
+	}
+
+	ts := NewRecurring(config)
+	if err := limitRRule(ts); err != nil {
+		return nil, err
+	}
+	if wkst != "MO" && ts.frequency == FREQ_WEEK && ts.interval > 1 && len(ts.byDay) > 0 {
+		// weeks are counted from Monday
+		return nil, fmt.Errorf("gochronos: rule %q needs WKST=MO", rule)
+	}
+	if err := ts.Validate(); err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		endAfter(ts, count)
+	}
+	return ts, nil
+}
+
+// Convert a part of a recurrence rule to its NewRecurring config. Floating times are in loc.
+func rrulePart(config map[string]interface{}, key, value string, loc *time.Location) error {
+	var err error
+	switch key {
+	case "FREQ":
+		freq, ok := rruleFreqs[value]
+		if !ok {
+			return fmt.Errorf("gochronos: unknown rule frequency %q", value)
+		}
+		config["frequency"] = freq
+	case "INTERVAL":
+		config["interval"], err = strconv.Atoi(value)
+	case "UNTIL":
+		config["endtime"], err = parseRRuleTime(value, loc)
+	case "BYDAY":
+		codes := strings.Split(value, ",")
+		for _, code := range codes {
+			if len(code) > 2 {
+				return fmt.Errorf("gochronos: ordinal rule day %q is not supported", code)
+			}
+		}
+		if _, err := dayList(codes); err != nil {
+			return err
+		}
+		config["byday"] = codes
+	case "BYHOUR":
+		config["byhour"], err = ruleInts(value)
+	case "BYMINUTE":
+		config["byminute"], err = ruleInts(value)
+	case "BYMONTHDAY":
+		config["bymonthday"], err = ruleInts(value)
+	default:
+		return fmt.Errorf("gochronos: unsupported rule part %q", key)
+	}
+	if err != nil {
+		return fmt.Errorf("gochronos: invalid rule part %s=%s", key, value)
+	}
+	return nil
+}
+
+// Map the BY parts of a recurrence rule that limit its frequency, e.g. BYDAY with FREQ=DAILY for
+// every weekday, onto by-* rules, which select times within each period of the frequency. Such a
+// rule occurs at each time of its frequency that its parts allow, so the frequency becomes that of
+// the coarsest limiting part, and the units from the rule's frequency up to it can take any value.
+// This can't be done for an interval, which counts periods of the rule's frequency.
+func limitRRule(ts *TimeSpec) error {
+	// the frequency each by-* rule requires, as checked by Validate
+	coarsest := ts.frequency
+	for _, rule := range []struct {
+		set       bool
+		frequency int
+	}{
+		{len(ts.byMinute) > 0, FREQ_HOUR},
+		{len(ts.byHour) > 0, FREQ_DAY},
+		{len(ts.byDay) > 0, FREQ_WEEK},
+		{len(ts.byMonthDay) > 0, FREQ_MONTH},
+	} {
+		if rule.set && rule.frequency > coarsest {
+			coarsest = rule.frequency
+		}
+	}
+	switch {
+	case coarsest == ts.frequency:
+		return nil
+	case ts.interval > 1:
+		return errors.New("gochronos: rule INTERVAL is not supported with BY parts coarser than FREQ")
+	case ts.frequency == FREQ_SECOND:
+		return errors.New("gochronos: rule FREQ=SECONDLY is not supported with BY parts")
+	case ts.frequency == FREQ_WEEK:
+		return errors.New("gochronos: rule BYMONTHDAY is not allowed with FREQ=WEEKLY")
+	}
+
+	if ts.byHour == nil && ts.frequency <= FREQ_HOUR && coarsest >= FREQ_DAY {
+		ts.byHour = allInts(0, 23)
+	}
+	if ts.byMinute == nil && ts.frequency <= FREQ_MINUTE {
+		ts.byMinute = allInts(0, 59)
+	}
+	ts.frequency = coarsest
+	return nil
+}
+
+// End the time spec at its count-th occurrence, counted from its start, so it is used up after
+// count occurrences wherever it is evaluated from. A spec with fewer occurrences is unchanged.
+func endAfter(ts *TimeSpec, count int) {
+	next := ts.NextAfter(ts.startTime.Add(-time.Nanosecond))
+	for i := 1; i < count && !next.IsZero(); i++ {
+		next = ts.NextAfter(next)
+	}
+	if !next.IsZero() {
+		ts.endTime = next
+	}
+}
+
+// Parse a comma-separated list of ints.
+func ruleInts(value string) ([]int, error) {
+	var ints []int
+	for _, s := range strings.Split(value, ",") {
+		i, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+	return ints, nil
+}
+
+// Render the schedule as a crontab-style listing, e.g. for operators used to cron. Each recurring
+// action is a line with a trailing comment of its name and tags, e.g. "0 9 * * 1-5 # report
+// [daily]", in the order the actions were added. Lines are marked approximate if cron can't express
+// all of the spec, e.g. its end time, and specs cron can't express at all are listed as comments.
+// One-offs are listed separately at the end, with their times. This is the inverse of NewCron.
+func (s *Scheduler) Crontab() string {
+	var b, oneOffs strings.Builder
+	for _, sa := range s.ordered() {
+		ts := sa.spec()
+		label := cronLabel(sa)
+		if !ts.recurring {
+			times := ts.times
+			if times == nil {
+				times = []time.Time{ts.when}
+			}
+			for _, t := range times {
+				fmt.Fprintf(&oneOffs, "# %s %s\n", t.Format(time.RFC3339), label)
+			}
+			continue
+		}
+
+		expr, exact := ts.cron()
+		switch {
+		case expr == "":
+			fmt.Fprintf(&b, "# %s: %s (not expressible in cron)\n", label, ts)
+		case exact:
+			fmt.Fprintf(&b, "%s # %s\n", expr, label)
+		default:
+			fmt.Fprintf(&b, "%s # %s (approximate: %s)\n", expr, label, ts)
+		}
+	}
+	if oneOffs.Len() > 0 {
+		b.WriteString("# one-offs:\n")
+		b.WriteString(oneOffs.String())
+	}
+	return b.String()
+}
+
+// The name and tags of an action, for a crontab comment.
+func cronLabel(sa *ScheduledAction) string {
+	label := sa.Name()
+	if label == "" {
+		label = "unnamed"
+	}
+	if len(sa.tags) > 0 {
+		label += " [" + strings.Join(sa.tags, ", ") + "]"
+	}
+	return label
+}
+
+// The five-field cron expression of a recurring time spec, in the location of its start time, and
+// whether it is exact. The expression is empty if cron can't express the spec.
+func (t *TimeSpec) cron() (string, bool) {
+	if t.fixedDelay || t.backoff || t.every > 0 || t.business != nil || t.weekly != nil ||
+		t.frequency < FREQ_MINUTE || t.frequency > FREQ_YEAR {
+		return "", false
+	}
+	start := t.startTime
+	exact := t.endTime.IsZero() && t.maxNum <= 0 && !t.isoWeeks && !t.alignToDay && start.Second() == 0
+
+	minute, hour, dayOfMonth, month, day := "*", "*", "*", "*", "*"
+	switch {
+	case len(t.byMinute) > 0:
+		minute = cronList(t.byMinute, 0, 59)
+	case t.frequency >= FREQ_HOUR:
+		minute = strconv.Itoa(start.Minute())
+	}
+	switch {
+	case len(t.byHour) > 0:
+		hour = cronList(t.byHour, 0, 23)
+	case t.frequency >= FREQ_DAY:
+		hour = strconv.Itoa(start.Hour())
+	}
+	switch {
+	case len(t.byDay) > 0:
+		day = cronList(weekdayInts(t.byDay), 0, 6)
+	case t.frequency == FREQ_WEEK:
+		day = strconv.Itoa(int(start.Weekday()))
+	}
+	switch {
+	case len(t.byMonthDay) > 0:
+		if sortedInts(t.byMonthDay)[0] < 0 {
+			// cron can't count back from the end of the month
+			return "", false
+		}
+		dayOfMonth = cronList(t.byMonthDay, 1, 31)
+		// cron executes when either day field matches, rather than both
+		exact = exact && len(t.byDay) == 0
+	case t.frequency >= FREQ_MONTH && len(t.byDay) == 0:
+		dayOfMonth = strconv.Itoa(start.Day())
+	}
+	if t.frequency == FREQ_YEAR && len(t.byDay) == 0 && len(t.byMonthDay) == 0 {
+		month = strconv.Itoa(int(start.Month()))
+	}
+
+	// an interval can only be expressed as a step of the unit of the frequency, and only if it
+	// divides that unit evenly from the start
+	if t.interval > 1 {
+		switch {
+		case t.hasRules():
+			return "", false
+		case t.frequency == FREQ_MINUTE && 60%t.interval == 0 && start.Minute()%t.interval == 0:
+			minute = "*/" + strconv.Itoa(t.interval)
+		case t.frequency == FREQ_HOUR && 24%t.interval == 0 && start.Hour()%t.interval == 0:
+			hour = "*/" + strconv.Itoa(t.interval)
+		case t.frequency == FREQ_MONTH && 12%t.interval == 0 && int(start.Month()-1)%t.interval == 0:
+			month = "1-12/" + strconv.Itoa(t.interval)
+		default:
+			return "", false
+		}
+	}
+	if len(t.seasons) > 0 {
+		// seasons of whole months map to the month field
+		months, ok := t.seasonMonths()
+		if ok && month == "*" {
+			month = cronList(months, 1, 12)
+		} else {
+			ok = false
+		}
+		exact = exact && ok
+	}
+	return strings.Join([]string{minute, hour, dayOfMonth, month, day}, " "), exact
+}
+
+// Render values between min and max as a cron field, with runs of three or more as ranges, and
+// * for every value.
+func cronList(values []int, min, max int) string {
+	sorted := sortedInts(values)
+	if len(sorted) == max-min+1 {
+		return "*"
+	}
+	var parts []string
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if j-i >= 2 {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		} else {
+			for k := i; k <= j; k++ {
+				parts = append(parts, strconv.Itoa(sorted[k]))
+			}
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
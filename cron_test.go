@@ -0,0 +1,170 @@
+package gochronos
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCronNextAfter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 7, 0, 0, time.UTC) // a Monday
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", start.Add(time.Minute)},
+		{"*/15 * * * *", time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{"30 8,20 * * *", time.Date(2024, 1, 1, 20, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"* 11 * * *", time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"* * * * 2", time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		ts, err := parseCron(test.expr, start)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.expr, err)
+			continue
+		}
+		if next := ts.NextAfter(start); !next.Equal(test.expected) {
+			t.Errorf("Expected %q to execute next at %s, got %s", test.expr, test.expected, next)
+		}
+	}
+
+	for _, expr := range []string{"0 9 * *", "60 * * * *", "0 9 * * 1-8", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expr, start); err == nil {
+			t.Errorf("Expected an error parsing %q", expr)
+		}
+	}
+}
+
+func TestCrontab(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	f := func(args ...interface{}) {}
+
+	v.Add(NewCron("0 9 * * 1-5"), f, WithName("report"), WithTags("daily"))
+	v.Add(NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_MINUTE, "interval": 15}), f, WithName("poll"))
+	v.Add(NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_DAY, "maxnum": 3}), f, WithName("trial"))
+	v.Add(NewFixedDelay(start, time.Minute), f, WithName("worker"))
+	v.Add(NewOneOff(start.Add(time.Hour)), f, WithName("launch"))
+
+	lines := strings.Split(strings.TrimSuffix(v.Crontab(), "\n"), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("Expected 6 lines, got %q", lines)
+	}
+	for i, line := range []string{"0 9 * * 1-5 # report [daily]", "*/15 * * * * # poll"} {
+		if lines[i] != line {
+			t.Errorf("Expected line %d to be %q, got %q", i, line, lines[i])
+		}
+	}
+	if !strings.HasPrefix(lines[2], "0 8 * * * # trial (approximate: ") {
+		t.Errorf("Expected an approximate daily line for trial, got %q", lines[2])
+	}
+	if !strings.HasPrefix(lines[3], "# worker: ") || !strings.HasSuffix(lines[3], "(not expressible in cron)") {
+		t.Errorf("Expected worker to be annotated as not expressible, got %q", lines[3])
+	}
+	if lines[4] != "# one-offs:" || lines[5] != "# 2024-01-01T09:00:00Z launch" {
+		t.Errorf("Expected the one-off launch listed separately, got %q", lines[4:])
+	}
+}
+
+func TestNewRecurringFromRRule(t *testing.T) {
+	at := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	from, far := at(2024, 1, 1, 0, 0), at(2030, 1, 1, 0, 0)
+	tests := []struct {
+		rule     string
+		end      time.Time
+		expected []time.Time
+	}{
+		{"DTSTART:20240115T090000Z RRULE:FREQ=MONTHLY;BYMONTHDAY=1,15", at(2024, 3, 2, 0, 0),
+			[]time.Time{at(2024, 1, 15, 9, 0), at(2024, 2, 1, 9, 0), at(2024, 2, 15, 9, 0), at(2024, 3, 1, 9, 0)}},
+		{"DTSTART:20240131T090000Z RRULE:FREQ=MONTHLY;COUNT=3", far,
+			[]time.Time{at(2024, 1, 31, 9, 0), at(2024, 3, 31, 9, 0), at(2024, 5, 31, 9, 0)}},
+		{"DTSTART:20240101T170000Z RRULE:FREQ=MONTHLY;BYMONTHDAY=-1;UNTIL=20240401T000000Z", far,
+			[]time.Time{at(2024, 1, 31, 17, 0), at(2024, 2, 29, 17, 0), at(2024, 3, 31, 17, 0)}},
+		{"DTSTART:20240101T080000Z RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=MO;BYMONTHDAY=1,2,3,4,5,6,7;COUNT=3", far,
+			[]time.Time{at(2024, 1, 1, 8, 0), at(2024, 3, 4, 8, 0), at(2024, 5, 6, 8, 0)}},
+		{"DTSTART:20240229T120000Z RRULE:FREQ=YEARLY;COUNT=2", far,
+			[]time.Time{at(2024, 2, 29, 12, 0), at(2028, 2, 29, 12, 0)}},
+
+		// COUNT is counted from the start, not from when the rule is evaluated
+		{"DTSTART:20200101T090000Z RRULE:FREQ=DAILY;COUNT=3", far, nil},
+
+		// BY parts of a coarser unit than the frequency limit it
+		{"DTSTART:20240101T090000Z RRULE:FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR;COUNT=6", far,
+			[]time.Time{at(2024, 1, 1, 9, 0), at(2024, 1, 2, 9, 0), at(2024, 1, 3, 9, 0), at(2024, 1, 4, 9, 0), at(2024, 1, 5, 9, 0), at(2024, 1, 8, 9, 0)}},
+		{"DTSTART:20240101T083000Z RRULE:FREQ=HOURLY;BYHOUR=9,17;COUNT=4", far,
+			[]time.Time{at(2024, 1, 1, 9, 30), at(2024, 1, 1, 17, 30), at(2024, 1, 2, 9, 30), at(2024, 1, 2, 17, 30)}},
+		{"DTSTART:20240106T000000Z RRULE:FREQ=HOURLY;BYDAY=SU;BYHOUR=9,10", at(2024, 1, 15, 0, 0),
+			[]time.Time{at(2024, 1, 7, 9, 0), at(2024, 1, 7, 10, 0), at(2024, 1, 14, 9, 0), at(2024, 1, 14, 10, 0)}},
+		{"DTSTART:20240101T090000Z RRULE:FREQ=MINUTELY;BYHOUR=9;BYMINUTE=0,30;BYDAY=MO;UNTIL=20240109T000000Z", far,
+			[]time.Time{at(2024, 1, 1, 9, 0), at(2024, 1, 1, 9, 30), at(2024, 1, 8, 9, 0), at(2024, 1, 8, 9, 30)}},
+		{"DTSTART:20240115T090000Z RRULE:FREQ=DAILY;BYMONTHDAY=1;COUNT=2", far,
+			[]time.Time{at(2024, 2, 1, 9, 0), at(2024, 3, 1, 9, 0)}},
+
+		// parts in any case, WKST, and a start in a time zone
+		{"dtstart:20240101t090000z rrule:freq=daily;byday=sa,su;count=2;wkst=su", far,
+			[]time.Time{at(2024, 1, 6, 9, 0), at(2024, 1, 7, 9, 0)}},
+		{"DTSTART;TZID=America/New_York:20240101T090000 RRULE:FREQ=DAILY;COUNT=2", far,
+			[]time.Time{at(2024, 1, 1, 14, 0), at(2024, 1, 2, 14, 0)}},
+	}
+	for _, test := range tests {
+		ts, err := ParseRRule(test.rule)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.rule, err)
+			continue
+		}
+		// every occurrence up to the end, so that the series is seen to stop
+		times := ts.Between(from, test.end)
+		equal := len(times) == len(test.expected)
+		for i := 0; equal && i < len(times); i++ {
+			equal = times[i].Equal(test.expected[i])
+		}
+		if !equal {
+			t.Errorf("Expected %q to occur at %v, got %v", test.rule, test.expected, times)
+		}
+	}
+
+	ts := NewRecurringFromRRule("DTSTART:20240115T090000Z RRULE:FREQ=MONTHLY;BYMONTHDAY=1,15")
+	if prev, ok := ts.PreviousBefore(at(2024, 2, 10, 0, 0)); !ok || !prev.Equal(at(2024, 2, 1, 9, 0)) {
+		t.Errorf("Expected the previous occurrence at %s, got %s", at(2024, 2, 1, 9, 0), prev)
+	}
+	if expr, exact := ts.cron(); expr != "0 9 1,15 * *" || !exact {
+		t.Errorf("Expected cron expression %q, got %q", "0 9 1,15 * *", expr)
+	}
+	data, err := ts.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling: %s", err)
+	}
+	var restored TimeSpec
+	if err := restored.UnmarshalJSON(data); err != nil || !restored.Equal(ts) {
+		t.Errorf("Expected %s after restoring, got %s (%v)", ts, &restored, err)
+	}
+
+	for _, rule := range []string{
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=FORTNIGHTLY",
+		"BYMONTHDAY=1",
+		"FREQ=MONTHLY;BYDAY=1MO",
+		"FREQ=DAILY;INTERVAL=2;BYDAY=MO",
+		"FREQ=SECONDLY;BYMINUTE=0",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240101T000000Z",
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;WKST=SU",
+		"DTSTART;TZID=Nowhere/Special:20240101T090000 RRULE:FREQ=DAILY",
+	} {
+		if _, err := ParseRRule(rule); err == nil {
+			t.Errorf("Expected an error for %q", rule)
+		}
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for a malformed rule")
+		}
+	}()
+	NewRecurringFromRRule("FREQ=FORTNIGHTLY")
+}
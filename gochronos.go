@@ -1,21 +1,17 @@
 package gochronos
 
 import (
-	// "fmt"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-const (
-	FREQ_SECOND int = 1 + iota
-	FREQ_MINUTE
-	FREQ_HOUR
-	FREQ_DAY
-	FREQ_WEEK
-	FREQ_MONTH
-	FREQ_YEAR
-)
-
 // A command that can be sent to a goroutine.
 type command int
 
@@ -23,26 +19,82 @@ const (
 	// Cancel the goroutine for a scheduled action
 	CMD_CANCEL command = 1 + iota
 	CMD_UPDATE_TIME
+	// Replace the action function with the one given to SwapAction
+	CMD_SWAP_ACTION
+)
+
+// The state of a scheduled action.
+type State int
+
+const (
+	// Not yet added to a schedule
+	STATE_PENDING State = 1 + iota
+	// In the schedule, and executing in accordance with its time specification
+	STATE_ACTIVE
+	// Cancelled, or has no more executions
+	STATE_DONE
+	// In the schedule and following its time specification, but not executing
+	STATE_DISABLED
+	// In the schedule, but stopped until it is resumed
+	STATE_PAUSED
 )
 
+var stateNames = map[State]string{
+	STATE_PENDING:  "pending",
+	STATE_ACTIVE:   "active",
+	STATE_DONE:     "done",
+	STATE_DISABLED: "disabled",
+	STATE_PAUSED:   "paused",
+}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
 // ActionFunc is basically a function to call when time is up, with optional parameters supplied when
 // scheduled action was added.
 type ActionFunc func(args ...interface{})
 
-// A specification of when to execute an action. This can either be one-off, created by NewOneOff(), or
-// recurring, created by NewRecurring().
-type TimeSpec struct {
-	recurring bool
-	when      time.Time
+// ActionFuncCtx is an action function that is passed a context for each execution, which carries
+// any deadline the execution has, e.g. from WithRunTimeout or WithDeadlineUntilNext, and is
+// cancelled when the action is removed from the schedule or the scheduler shuts down.
+type ActionFuncCtx func(ctx context.Context, args ...interface{})
+
+// ActionFuncErr is an action function that reports whether it failed. Errors are passed to the
+// handler given by WithErrorHandler, and trigger retries given by WithRetry.
+type ActionFuncErr func(args ...interface{}) error
+
+// ActionFuncAdaptive is an action function that can change its own cadence. If it returns a
+// non-zero duration, the action executes every that often from the current run onwards.
+type ActionFuncAdaptive func(args ...interface{}) (time.Duration, error)
+
+// The error passed to the error handler when an action disables itself because too many of its
+// runs failed, as set by WithMaxErrorRate.
+var ErrErrorRateExceeded = errors.New("gochronos: error rate exceeded")
 
-	startTime time.Time
-	endTime   time.Time
-	frequency int // one of FREQ_ constants
-	interval  int
-	// byday
-	// byhours
-	// byminute
-	maxNum int
+// The error reported when an action doesn't complete within its run timeout.
+var ErrRunTimeout = errors.New("gochronos: action run timed out")
+
+// Matched by the errors reported for actions that panic, when panics are recovered with
+// WithPanicRecovery. Use errors.As with a *PanicError for the panic value and stack.
+var ErrPanic = errors.New("gochronos: action panicked")
+
+// PanicError is the error reported when an action panics and the panic is recovered.
+type PanicError struct {
+	// The value passed to panic.
+	Value interface{}
+
+	// The stack of the goroutine that panicked, as given by debug.Stack.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("gochronos: action panicked: %v", e.Value)
+}
+
+// Returns true for ErrPanic, so that errors.Is(err, ErrPanic) matches.
+func (e *PanicError) Is(target error) bool {
+	return target == ErrPanic
 }
 
 // ScheduledAction represents an action that is scheduled in time. When added to the schedule,
@@ -58,74 +110,842 @@ type ScheduledAction struct {
 	// Parameters passed to the action.
 	Parameters []interface{}
 
-	cmdChan chan command
-}
+	// guards the state below, which is shared with the timer goroutine.
+	lock sync.Mutex
+
+	// the command channel of the current timer goroutine, and a channel closed when it exits, so
+	// commands aren't sent to a goroutine that has gone. They are replaced when the action is added
+	// again, along with timerGen, so a goroutine left over from before can tell it is stale.
+	cmdChan  chan command
+	done     chan struct{}
+	timerGen uint64
+
+	// set by CancelSelf; the timer goroutine stops after the current run.
+	cancelRequested bool
+
+	// maximum random delay applied to the first execution.
+	startupSpread time.Duration
+
+	// daily windows in which the action doesn't execute.
+	blackout []TimeWindow
+
+	// when the most recent execution was scheduled for, and when it actually happened.
+	lastScheduled time.Time
+	lastRun       time.Time
+
+	// the error of the most recent run, or nil if it succeeded.
+	lastErr error
+
+	// if set, called if the action hasn't started executing an occurrence within latenessGrace
+	// of it, as checked by lateTimer. started is the scheduled time of the execution that most
+	// recently started.
+	latenessAlert func(sa *ScheduledAction, scheduled time.Time)
+	latenessGrace time.Duration
+	lateTimer     *time.Timer
+	started       time.Time
+
+	// the scheduler the action has been added to.
+	scheduler *Scheduler
+
+	// when the action is next due to execute.
+	next time.Time
+
+	// the number of times the action has executed.
+	runCount int
+
+	// the name of the registered action, if the action was created with WithRegisteredAction.
+	actionName string
+
+	// the order in which the action was added to its scheduler.
+	seq uint64
+
+	// if an occurrence fell within this long before being added, the action executes on add.
+	ensureRecentWindow time.Duration
+
+	// descriptive name and tags, set by WithName and WithTags.
+	name string
+	tags []string
+
+	// the current state; the zero value means pending.
+	state State
+
+	// if set, an occurrence only executes if gate returns true.
+	gate func() bool
+
+	// if set, an occurrence only executes if changed returns true, set by WithChangeTrigger.
+	changed func() bool
+
+	// if set, an occurrence waits up to readyTimeout for ready to return true before executing.
+	ready        func() bool
+	readyTimeout time.Duration
+
+	// called for each occurrence that is skipped, set by WithOnSkip.
+	onSkip func(*ScheduledAction, SkipReason)
+
+	// if set, the action executes once as soon as it is added, before following its time spec.
+	kickoff bool
+
+	// if set, a one-off that is already overdue when added executes immediately instead of never.
+	runIfOverdue bool
+
+	// the action function, if it was added with AddErr.
+	actionErr ActionFuncErr
+
+	// the action function, if it was added with AddCtx.
+	actionCtx ActionFuncCtx
+
+	// the parent of the context of each execution, cancelled when the action is removed or done.
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	// if set, the context of each execution has a deadline of the action's next occurrence.
+	deadlineUntilNext bool
+
+	// called with the error of each failed run, set by WithErrorHandler.
+	onError func(*ScheduledAction, error)
+
+	// how long a run may take before it is considered failed, or 0 for no limit.
+	runTimeout time.Duration
+
+	// the number of times a failed run is retried, and the delay before each retry. If
+	// retryBackoffMax is set, the delay doubles after each retry up to that, and if retryJitter is
+	// set, each delay is randomly varied by up to that fraction either way.
+	retries         int
+	retryDelay      time.Duration
+	retryBackoffMax time.Duration
+	retryJitter     float64
+
+	// the number of retries made since the last successful run, and when the next is due.
+	attempts int
+	retryAt  time.Time
+
+	// if idempotency is set, each run has a token derived from tokenScheduled, the scheduled time
+	// of the run, or of the run being retried.
+	idempotency    bool
+	tokenScheduled time.Time
+
+	// closed and replaced after each run, to wake anything waiting for the next run.
+	fired chan struct{}
+
+	// closed when the action is done.
+	finished chan struct{}
+
+	// the number of runs in flight, and a channel closed when there are none, to wake Drain. Once
+	// draining is set, no more runs start.
+	inFlight int
+	idle     chan struct{}
+	draining bool
 
-// A list of scheduled actions. This is the schedule that is executed.
-var schedule map[*ScheduledAction]bool
+	// if set, a successful run restarts a backoff time spec from its base interval, anchored at
+	// backoffAnchor.
+	resetBackoff  bool
+	backoffAnchor time.Time
 
-// This is used to synchronise updates to the schedule across goroutines.
-var scheduleLock sync.Mutex
+	// how an occurrence is handled when the action wakes more than misfireGrace late for it.
+	misfirePolicy MisfirePolicy
+	misfireGrace  time.Duration
 
-func init() {
-	ClearAll()
+	// if set, the action executes at most once per calendar day.
+	oncePerDay bool
+
+	// if set, provides the parameters for each execution in place of Parameters.
+	paramProvider func() []interface{}
+
+	// added to every occurrence of the time spec, set by WithPhaseOffset.
+	phaseOffset time.Duration
+
+	// if set, a recurring action only executes strictly after its start time.
+	exclusiveStart bool
+
+	// the action function given to SwapAction, until the timer goroutine picks it up.
+	swapTo ActionFunc
+
+	// set if an occurrence was missed while paused, and then the number of executions the action
+	// is to make up, once resumed or when added with a startup catch-up.
+	missed   bool
+	catchUps int
+
+	// the most executions to make up for occurrences missed before the action was added.
+	startupCatchUp int
+
+	// set if the action is executed by the scheduler's driver goroutine rather than its own.
+	multiplexed bool
+
+	// if set, panics in the action function are recovered and reported as errors.
+	recoverPanics bool
+
+	// occurrences before this time don't happen, set by Snooze.
+	snoozedUntil time.Time
+
+	// if adaptiveMax is set, the period is adjusted within these bounds according to how long
+	// executions take. durations holds the most recent execution times since the last change.
+	adaptiveMin time.Duration
+	adaptiveMax time.Duration
+	durations   []time.Duration
+
+	// executions of actions in the same serial group don't overlap, set by WithSerialGroup.
+	serialGroup string
+
+	// if set, the action executes at most once ever, as recorded in its scheduler's OnceStore.
+	runOnceKey string
+
+	// after fallbackAfter consecutive failed runs, fallback executes once and the action stops.
+	fallbackAfter int
+	fallback      ActionFunc
+
+	// the number of runs that have failed since the last successful run.
+	failures int
+
+	// if maxErrors is set, the action disables itself when more runs than that fail within
+	// errorWindow. errorTimes holds the times of the failures within the current window.
+	maxErrors   int
+	errorWindow time.Duration
+	errorTimes  []time.Time
 }
 
+// The maximum number of occurrences skipped when looking for the next execution time.
+const maxSkips = 10000
+
+// The scheduler used by the package-level functions.
+var defaultScheduler = New()
+
 // create a new scheduled action. To add to the schedule, call AddToScheduled, or just Add which creates
-// and adds to schedule.
+// and adds to schedule. Any Option values in args are applied to the scheduled action rather than
+// passed to the action.
 func NewScheduledAction(ts *TimeSpec, f ActionFunc, args []interface{}) *ScheduledAction {
-	return &ScheduledAction{When: ts, Action: f, Parameters: args}
+	params, opts := splitOptions(args)
+	sa := &ScheduledAction{When: ts, Action: f, Parameters: params}
+	for _, opt := range opts {
+		opt(sa)
+	}
+	return sa
+}
+
+// Add a scheduled action to the default schedule, returning an error if it could not be added.
+func AddToSchedule(sa *ScheduledAction) error {
+	return defaultScheduler.AddToSchedule(sa)
 }
 
-// Add a scheduled action to the schedule
-func AddToSchedule(sa *ScheduledAction) {
-	scheduleLock.Lock()
+// Add a scheduled action to the default schedule. This is the same as AddE, except that the error
+// is discarded; nil is returned if the action could not be added.
+func Add(ts *TimeSpec, f ActionFunc, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.Add(ts, f, args...)
+}
 
-	// add a scheduled action to the list
-	schedule[sa] = true
+// Add a scheduled action to the default schedule, returning an error if the time specification is
+// invalid.
+func AddE(ts *TimeSpec, f ActionFunc, args ...interface{}) (*ScheduledAction, error) {
+	return defaultScheduler.AddE(ts, f, args...)
+}
 
-	scheduleLock.Unlock()
+// Add a scheduled action with an error-returning action function to the default schedule. nil is
+// returned if the action could not be added.
+func AddErr(ts *TimeSpec, f ActionFuncErr, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddErr(ts, f, args...)
+}
 
-	sa.startTimer()
+// Add a scheduled action with a context-aware action function to the default schedule. nil is
+// returned if the action could not be added.
+func AddCtx(ts *TimeSpec, f ActionFuncCtx, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddCtx(ts, f, args...)
 }
 
-// Add a scheduled action to the schedule.
-func Add(ts *TimeSpec, f ActionFunc, args ...interface{}) *ScheduledAction {
-	sa := NewScheduledAction(ts, f, args)
-	AddToSchedule(sa)
-	return sa
+// Add a scheduled action with an adaptive action function to the default schedule. nil is returned
+// if the action could not be added.
+func AddAdaptive(ts *TimeSpec, f ActionFuncAdaptive, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddAdaptive(ts, f, args...)
+}
+
+// Add a scheduled action to the default schedule that executes once immediately, and then follows
+// its time specification.
+func AddWithKickoff(ts *TimeSpec, f ActionFunc, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddWithKickoff(ts, f, args...)
+}
+
+// Add a scheduled action to the default schedule, returning a closer that cancels it as well as the
+// action. Both are nil if the action could not be added.
+func AddCloser(ts *TimeSpec, f ActionFunc, args ...interface{}) (io.Closer, *ScheduledAction) {
+	return defaultScheduler.AddCloser(ts, f, args...)
+}
+
+// AddRequest bundles the arguments to AddE, for adding many scheduled actions at once.
+type AddRequest struct {
+	When    *TimeSpec
+	Action  ActionFunc
+	Params  []interface{}
+	Options []Option
+}
+
+// Add a batch of scheduled actions to the default schedule.
+func AddMany(specs []AddRequest) ([]*ScheduledAction, []error) {
+	return defaultScheduler.AddMany(specs)
 }
 
 // Remove a scheduled action from the schedule.
-func Remove(sa *ScheduledAction) {
-	// Tell the timer goroutine to stop. This in turn will trigger the goroutine to remove itself.
-	sa.stopTimer()
+// Returns true if the action was in its schedule and is now being cancelled, or false if it was
+// already gone. Removing an action more than once is safe.
+func Remove(sa *ScheduledAction) bool {
+	if sa.scheduler == nil {
+		return false
+	}
+	return sa.scheduler.Remove(sa)
+}
+
+// Cancel the action by removing it from its schedule, so a scheduled action can be used as an
+// io.Closer. Closing an action more than once is safe. It always returns nil.
+func (sa *ScheduledAction) Close() error {
+	Remove(sa)
+	return nil
 }
 
-// Remove scheduled action from list. This assumes the timer goroutine
-// is not going to trigger more events. This can be called by the timer
-// goroutines when they reach termination, so locking is required on the structure.
-func remove(sa *ScheduledAction) {
-	scheduleLock.Lock()
+// Remove all actions in the default schedule that have the tag, returning the number removed.
+func RemoveByTag(tag string) int {
+	return defaultScheduler.RemoveByTag(tag)
+}
 
-	delete(schedule, sa)
+// Pause all actions in the default schedule that have the tag, returning the number paused.
+func PauseByTag(tag string) int {
+	return defaultScheduler.PauseByTag(tag)
+}
+
+// Resume all paused actions in the default schedule that have the tag, returning the number
+// resumed.
+func ResumeByTag(tag string) int {
+	return defaultScheduler.ResumeByTag(tag)
+}
 
-	scheduleLock.Unlock()
+// The number of actions in the default schedule.
+func Count() int {
+	return defaultScheduler.Count()
 }
 
 // Change the time specification on a scheduled action. If the timer goroutine
 // has been started, send it a command to tell it to update when it next executes.
 // The change takes effect immediately.
 func (sa *ScheduledAction) SetTimeSpec(ts *TimeSpec) {
+	sa.setSpec(ts)
+	sa.reevaluate()
+}
+
+// The time specification of the action. This is read under the lock, as it may be replaced while
+// the action is scheduled, e.g. by Patch.
+func (sa *ScheduledAction) spec() *TimeSpec {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.When
+}
+
+// Replace the time specification of the action, under the lock.
+func (sa *ScheduledAction) setSpec(ts *TimeSpec) {
+	sa.lock.Lock()
 	sa.When = ts
-	if sa.cmdChan != nil {
-		sa.cmdChan <- CMD_UPDATE_TIME
+	sa.lock.Unlock()
+}
+
+// Change the time specification of a scheduled action by applying only the given options, i.e.
+// WithInterval, WithEndTime or WithMaxNum, leaving the rest of it as it is, then re-evaluate when
+// the action next executes. The options are applied to a copy of the spec, so other actions
+// sharing it aren't affected, and the copy only replaces the spec if it is valid; otherwise the
+// action is left unchanged and the validation error is returned. Options that don't change the
+// time specification have no effect, as the action may be executing concurrently.
+func (sa *ScheduledAction) Patch(opts ...Option) error {
+	sa.lock.Lock()
+	patched := &ScheduledAction{When: sa.When}
+	for _, opt := range opts {
+		opt(patched)
+	}
+	err := patched.When.Validate()
+	if err == nil {
+		sa.When = patched.When
 	}
+	sa.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	sa.reevaluate()
+	return nil
+}
+
+// Restart the cadence of a recurring action from now, keeping its frequency and interval. The
+// action's time specification is replaced by a copy with its start time set to the current time
+// of the action's scheduler, so other actions sharing the spec aren't affected. This has no effect
+// on a one-off action, or one that hasn't been added to a schedule.
+func (sa *ScheduledAction) Reanchor() {
+	if ts := sa.spec(); sa.scheduler == nil || ts == nil || !ts.recurring {
+		return
+	}
+	now := sa.scheduler.now()
+	sa.patchSpec(func(ts *TimeSpec) { ts.startTime = now })
+	sa.reevaluate()
+}
+
+// Change the cadence of the action to every d, from its current run onwards. This is called from
+// the action's timer goroutine, which picks up the new time spec when it determines the next
+// execution. The spec is swapped under the lock, as it may be read from other goroutines.
+func (sa *ScheduledAction) adapt(d time.Duration) {
+	sa.lock.Lock()
+	sa.When = sa.When.withEvery(sa.lastScheduled, d)
+	sa.lock.Unlock()
+}
+
+// The number of executions considered when adapting the interval of an action added with
+// WithAdaptiveInterval.
+const adaptiveSamples = 3
+
+// Adjust the period of an action added with WithAdaptiveInterval, given how long its latest
+// execution took. When nearly all recent executions took longer than the period, it doubles, and
+// when nearly all took less than half of it, it halves, within the bounds.
+func (sa *ScheduledAction) adaptInterval(elapsed time.Duration) {
+	current, ok := sa.spec().Period()
+	if sa.adaptiveMax <= 0 || !ok {
+		return
+	}
+	sa.lock.Lock()
+	sa.durations = append(sa.durations, elapsed)
+	if len(sa.durations) < adaptiveSamples {
+		sa.lock.Unlock()
+		return
+	}
+	sorted := append([]time.Duration(nil), sa.durations...)
+	sa.durations = sa.durations[1:]
+	sa.lock.Unlock()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	next := current
+	if percentile(sorted, 10) > current {
+		next = current * 2
+	} else if percentile(sorted, 90) < current/2 {
+		next = current / 2
+	}
+	if next > sa.adaptiveMax {
+		next = sa.adaptiveMax
+	}
+	if next < sa.adaptiveMin {
+		next = sa.adaptiveMin
+	}
+	if next != current {
+		sa.lock.Lock()
+		sa.durations = nil
+		sa.lock.Unlock()
+		sa.adapt(next)
+	}
+}
+
+// The p-th percentile of sorted durations, by the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (p*len(sorted)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// The current period of the action, i.e. the time between its executions, and whether it has a
+// fixed period. For an action added with WithAdaptiveInterval, this changes as it adapts.
+func (sa *ScheduledAction) CurrentInterval() (time.Duration, bool) {
+	return sa.spec().Period()
+}
+
+// Cancel the scheduled action from within its own action function. Unlike Remove, this doesn't
+// send on the command channel, so it can't deadlock when called from the timer goroutine. The
+// action is removed from the schedule once the current run completes. If called from outside the
+// action, it takes effect after the next run.
+func (sa *ScheduledAction) CancelSelf() {
+	sa.lock.Lock()
+	sa.cancelRequested = true
+	sa.lock.Unlock()
+}
+
+// Returns true if CancelSelf has been called.
+func (sa *ScheduledAction) selfCancelled() bool {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.cancelRequested
+}
+
+// The time the most recent execution was scheduled for, or the zero time if the action hasn't
+// executed. The difference between this and LastRun is the drift of the most recent execution.
+func (sa *ScheduledAction) LastScheduled() time.Time {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.lastScheduled
+}
+
+// The time the most recent execution actually started, or the zero time if the action hasn't
+// executed.
+func (sa *ScheduledAction) LastRun() time.Time {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.lastRun
+}
+
+// The error of the most recent run, including a recovered panic or a run timeout, or nil if the
+// action hasn't run or its most recent run succeeded.
+func (sa *ScheduledAction) LastError() error {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.lastErr
+}
+
+// Record the scheduled and actual times of an execution that is starting.
+func (sa *ScheduledAction) recordRun(scheduled, actual time.Time) {
+	sa.lock.Lock()
+	sa.lastScheduled = scheduled
+	sa.lastRun = actual
+	sa.runCount++
+	sa.lock.Unlock()
+}
+
+// The number of times the action has executed.
+func (sa *ScheduledAction) RunCount() int {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.runCount
+}
+
+// Returns true if a recurring action has executed the maximum number of times.
+func (sa *ScheduledAction) exhausted() bool {
+	ts := sa.spec()
+	if !ts.recurring || ts.maxNum < 1 {
+		return false
+	}
+	return sa.RunCount() >= ts.maxNum
+}
+
+// The name of the action, set by WithName.
+func (sa *ScheduledAction) Name() string {
+	return sa.name
+}
+
+// Evaluate when the action next executes after now, with an explanation if it never will, as
+// TimeSpec.Explain does. This also accounts for the action's run history, e.g. "maxnum reached".
+func (sa *ScheduledAction) Explain(now time.Time) (time.Time, string) {
+	if sa.exhausted() {
+		return time.Time{}, "maxnum reached"
+	}
+	if sa.selfCancelled() {
+		return time.Time{}, "cancelled by the action"
+	}
+	return sa.spec().Explain(now)
+}
+
+// The kind of a schedule, as described by ScheduleInfo.
+type ScheduleKind int
+
+const (
+	// Executes once, at a single time
+	KIND_ONE_OFF ScheduleKind = 1 + iota
+	// Executes at each of a list of times
+	KIND_TIMES
+	// Recurs
+	KIND_RECURRING
+)
+
+var kindNames = map[ScheduleKind]string{
+	KIND_ONE_OFF:   "one-off",
+	KIND_TIMES:     "times",
+	KIND_RECURRING: "recurring",
+}
+
+func (k ScheduleKind) String() string {
+	return kindNames[k]
+}
+
+// ScheduleInfo describes the effective schedule of an action in the same shape, whatever the kind
+// of its time spec, e.g. for display. Fields that don't apply to the kind are zero.
+type ScheduleInfo struct {
+	Kind ScheduleKind
+
+	// When the action next executes, or the zero time if it won't.
+	Next time.Time
+
+	// The time of a one-off, and the times of a multi-shot spec.
+	At    time.Time
+	Times []time.Time
+
+	// The constraints of a recurring spec. Period is its fixed period, or 0 for periods that
+	// don't have a fixed length, and MaxNum is 0 if executions are unlimited.
+	Start     time.Time
+	End       time.Time
+	Period    time.Duration
+	Frequency int
+	Interval  int
+	ByDay     []time.Weekday
+	ByHour    []int
+	ByMinute  []int
+	MaxNum    int
+
+	// The days of the month of a recurring spec, where negative days count back from the end.
+	ByMonthDay []int
+
+	// A one-line description, as given by TimeSpec.String.
+	Description string
+}
+
+// Describe the action's schedule uniformly across kinds of time spec.
+func (sa *ScheduledAction) Describe() ScheduleInfo {
+	ts := sa.spec()
+	info := ScheduleInfo{Description: ts.String()}
+	info.Next, _ = sa.NextExecution()
+	switch {
+	case ts.recurring:
+		info.Kind = KIND_RECURRING
+		info.Start = ts.startTime
+		info.End = ts.endTime
+		info.Period = ts.period()
+		info.Frequency = ts.frequency
+		info.Interval = ts.interval
+		info.ByDay = append([]time.Weekday(nil), ts.byDay...)
+		info.ByHour = append([]int(nil), ts.byHour...)
+		info.ByMinute = append([]int(nil), ts.byMinute...)
+		info.ByMonthDay = append([]int(nil), ts.byMonthDay...)
+		info.MaxNum = sa.MaxNum()
+	case ts.times != nil:
+		info.Kind = KIND_TIMES
+		info.Times = append([]time.Time(nil), ts.times...)
+	default:
+		info.Kind = KIND_ONE_OFF
+		info.At = ts.when
+	}
+	return info
+}
+
+// The tags of the action, set by WithTags.
+func (sa *ScheduledAction) Tags() []string {
+	return append([]string(nil), sa.tags...)
+}
+
+// The interval of a recurring action, i.e. the multiplier on its frequency, or 0 for an action
+// that doesn't recur.
+func (sa *ScheduledAction) Interval() int {
+	ts := sa.spec()
+	if ts == nil || !ts.recurring {
+		return 0
+	}
+	return ts.interval
+}
+
+// The maximum number of times a recurring action executes, or 0 if it is unlimited.
+func (sa *ScheduledAction) MaxNum() int {
+	ts := sa.spec()
+	if ts == nil || !ts.recurring || ts.maxNum < 1 {
+		return 0
+	}
+	return ts.maxNum
+}
+
+// The maximum random delay of the first execution, set by WithStartupSpread.
+func (sa *ScheduledAction) StartupSpread() time.Duration {
+	return sa.startupSpread
+}
+
+// The daily blackout windows of the action, set by WithBlackout.
+func (sa *ScheduledAction) Blackout() []TimeWindow {
+	return append([]TimeWindow(nil), sa.blackout...)
+}
+
+// How long a run may take before it fails, set by WithRunTimeout, or 0 for no limit.
+func (sa *ScheduledAction) RunTimeout() time.Duration {
+	return sa.runTimeout
+}
+
+// The number of times a failed run is retried and the delay before each retry, set by WithRetry.
+func (sa *ScheduledAction) Retry() (int, time.Duration) {
+	return sa.retries, sa.retryDelay
+}
+
+// The misfire policy of the action and its grace period, set by WithMisfirePolicy. Without a
+// policy, late occurrences execute as soon as the action wakes, as with MISFIRE_FIRE_NOW.
+func (sa *ScheduledAction) MisfirePolicy() (MisfirePolicy, time.Duration) {
+	if sa.misfirePolicy == 0 {
+		return MISFIRE_FIRE_NOW, 0
+	}
+	return sa.misfirePolicy, sa.misfireGrace
+}
+
+// Returns true if the action has the tag.
+func (sa *ScheduledAction) HasTag(tag string) bool {
+	for _, t := range sa.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// The current state of the action.
+func (sa *ScheduledAction) State() State {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.state == 0 {
+		return STATE_PENDING
+	}
+	return sa.state
+}
+
+// Disable the action. A disabled action stays on its schedule, but its occurrences are skipped
+// with SKIP_DISABLED, and don't count towards maxnum. Unlike pausing, time doesn't stop for it,
+// so when enabled again it executes at the next occurrence with no backlog. Only an active
+// action can be disabled.
+func (sa *ScheduledAction) Disable() {
+	sa.transition(STATE_ACTIVE, STATE_DISABLED)
+}
+
+// Enable an action that was disabled by Disable.
+func (sa *ScheduledAction) Enable() {
+	sa.transition(STATE_DISABLED, STATE_ACTIVE)
+}
+
+// Pause the action, e.g. to quieten it while something else happens. Time stops for a paused
+// action: its occurrences are skipped with SKIP_PAUSED, and when resumed, it executes once
+// straight away if it missed any, before carrying on with its schedule. Only an active action can
+// be paused.
+func (sa *ScheduledAction) Pause() {
+	sa.pause()
+}
+
+// Resume an action that was paused by Pause.
+func (sa *ScheduledAction) Resume() {
+	sa.resume()
+}
+
+// Pause the action, returning true if it was active.
+func (sa *ScheduledAction) pause() bool {
+	return sa.transition(STATE_ACTIVE, STATE_PAUSED)
+}
+
+// Resume the action, returning true if it was paused. If it missed an occurrence, it catches up
+// by executing as soon as its next execution is re-evaluated.
+func (sa *ScheduledAction) resume() bool {
+	sa.lock.Lock()
+	if sa.state != STATE_PAUSED {
+		sa.lock.Unlock()
+		return false
+	}
+	sa.state = STATE_ACTIVE
+	sa.draining = false
+	catchUp := sa.missed
+	if catchUp {
+		sa.catchUps = 1
+	}
+	sa.missed = false
+	sa.lock.Unlock()
+
+	if catchUp {
+		sa.reevaluate()
+	}
+	return true
+}
+
+// Returns true, once for each execution to make up, if the action is to catch up on occurrences
+// it missed.
+func (sa *ScheduledAction) takeCatchUp() bool {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.catchUps == 0 {
+		return false
+	}
+	sa.catchUps--
+	return true
+}
+
+// Count the occurrences the action missed before it was added at now, i.e. since its last run or
+// the start of its time spec, up to its startup catch-up limit, so they are made up straight
+// away. This is only done once, when the action is added.
+func (sa *ScheduledAction) catchUpOnStartup(now time.Time) {
+	if sa.startupCatchUp <= 0 {
+		return
+	}
+	ts := sa.spec()
+	from := sa.LastRun()
+	if from.IsZero() && ts.recurring {
+		from = ts.startTime.Add(-time.Nanosecond)
+	}
+	limit := sa.startupCatchUp
+	if ts.maxNum > 0 && ts.maxNum-sa.RunCount() < limit {
+		limit = ts.maxNum - sa.RunCount()
+	}
+
+	n := 0
+	for t := sa.findNext(from.In(now.Location()), false); !t.IsZero() && !t.After(now) && n < limit; t = sa.findNext(t, false) {
+		n++
+	}
+	sa.lock.Lock()
+	sa.catchUps = n
+	sa.lock.Unlock()
+}
+
+// Change the state of the action from one state to another, returning false if it wasn't in the
+// first state.
+func (sa *ScheduledAction) transition(from, to State) bool {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.state != from {
+		return false
+	}
+	sa.state = to
+	return true
+}
+
+func (sa *ScheduledAction) setState(state State) {
+	sa.lock.Lock()
+	sa.state = state
+	sa.lock.Unlock()
+}
+
+// Describe the action in one line, for logging.
+func (sa *ScheduledAction) String() string {
+	kind := "one-off"
+	if ts := sa.spec(); ts != nil && ts.recurring {
+		kind = "recurring"
+	} else if ts != nil && ts.times != nil {
+		kind = "multi-shot"
+	}
+	next := "none"
+	if t, ok := sa.NextExecution(); ok {
+		next = t.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("name=%q tags=[%s] %s next=%s runs=%d state=%s",
+		sa.name, strings.Join(sa.tags, ","), kind, next, sa.RunCount(), sa.State())
 }
 
 // Change the action.
 func (sa *ScheduledAction) SetAction(f ActionFunc) {
 	sa.Action = f
+	sa.actionErr = nil
+	sa.actionCtx = nil
+}
+
+// Replace the action function of an action that may be executing. An execution in progress
+// finishes with the old function, and the next execution uses f, without missing an occurrence.
+// The timer goroutine makes the change between executions, so it doesn't race with them.
+func (sa *ScheduledAction) SwapAction(f ActionFunc) {
+	if sa.multiplexed {
+		// the driver goroutine picks it up before the next execution
+		sa.lock.Lock()
+		sa.swapTo = f
+		sa.lock.Unlock()
+		return
+	}
+	if sa.driven() || !sa.timed() {
+		sa.SetAction(f)
+		return
+	}
+	sa.lock.Lock()
+	sa.swapTo = f
+	sa.lock.Unlock()
+	sa.send(CMD_SWAP_ACTION)
+}
+
+// Pick up an action function given to SwapAction. This is called by the timer goroutine.
+func (sa *ScheduledAction) applySwap() {
+	sa.lock.Lock()
+	f := sa.swapTo
+	sa.swapTo = nil
+	sa.lock.Unlock()
+	if f != nil {
+		sa.SetAction(f)
+	}
 }
 
 // Change the parameters.
@@ -135,19 +955,618 @@ func (sa *ScheduledAction) SetParams(args ...interface{}) {
 	sa.Parameters = args
 }
 
-// Given a scheduled action, start a goroutine for executing.
-func (sc *ScheduledAction) startTimer() {
-	sc.cmdChan = make(chan command)
+// Determine the next time after now that the action should execute, taking into account any
+// options that exclude occurrences of its time specification. Returns the zero time if there
+// are no more executions.
+func (sa *ScheduledAction) nextAfter(now time.Time) time.Time {
+	return sa.findNext(now, true)
+}
+
+// Determine the next execution time after now, as for nextAfter. Skipped occurrences are only
+// reported if report is set, so that previews don't look like skips.
+func (sa *ScheduledAction) findNext(now time.Time, report bool) time.Time {
+	if sa.exhausted() || sa.selfCancelled() {
+		return time.Time{}
+	}
+	if report && sa.takeCatchUp() {
+		return now
+	}
+	// a pending retry takes precedence over the time spec
+	if retry := sa.pendingRetry(); retry.After(now) {
+		return retry
+	}
+	if until := sa.snoozed(); until.After(now) {
+		now = until.Add(-time.Nanosecond)
+	}
+	t := sa.specNextAfter(now)
+	for i := 0; !t.IsZero() && i < maxSkips; i++ {
+		resume, excluded := sa.excluded(t.In(now.Location()))
+		if !excluded {
+			return t
+		}
+		if report {
+			sa.skipped(SKIP_BLACKOUT)
+		}
+		t = sa.specNextAfter(resume.Add(-time.Nanosecond))
+	}
+	return time.Time{}
+}
+
+// The times the action will execute from start up to end, computed without executing anything.
+// The action's options and remaining number of executions are taken into account, but gates and
+// quotas can't be predicted. For a fixed-delay spec, executions are assumed to take no time.
+func (sa *ScheduledAction) between(start, end time.Time) []time.Time {
+	if state := sa.State(); state == STATE_DISABLED || state == STATE_PAUSED {
+		return nil
+	}
+	remaining := -1
+	if ts := sa.spec(); ts.maxNum > 0 {
+		remaining = ts.maxNum - sa.RunCount()
+	}
+
+	var times []time.Time
+	for t := sa.findNext(start.Add(-time.Nanosecond), false); !t.IsZero() && t.Before(end) && remaining != 0; t = sa.findNext(t, false) {
+		times = append(times, t)
+		remaining--
+	}
+	return times
+}
+
+// The next time after now according to the time spec. A backoff spec that has been reset by a
+// successful run is followed from the time of that run.
+func (sa *ScheduledAction) specNextAfter(now time.Time) time.Time {
+	sa.lock.Lock()
+	anchor := sa.backoffAnchor
+	ts := sa.When
+	sa.lock.Unlock()
+	if ts.backoff && !anchor.IsZero() {
+		return ts.nextBackoff(anchor, now)
+	}
+	if sa.exclusiveStart && ts.recurring && now.Before(ts.startTime) {
+		// the start time itself isn't eligible
+		now = ts.startTime
+	}
+	if sa.phaseOffset != 0 {
+		next := ts.NextAfter(now.Add(-sa.phaseOffset))
+		if next.IsZero() {
+			return next
+		}
+		return next.Add(sa.phaseOffset)
+	}
+	return ts.NextAfter(now)
+}
+
+// Determine if an occurrence is excluded by the action's options. If so, also returns the time at
+// which occurrences may resume.
+func (sa *ScheduledAction) excluded(t time.Time) (time.Time, bool) {
+	for _, w := range sa.blackout {
+		if w.contains(t) {
+			return w.end(t), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Determine the first execution time of the action after now. This is the same as nextAfter,
+// except that the start-up spread is applied, and an action with a kick-off executes right away.
+// If known is set, next is the time nextAfter has already given, so that skipped occurrences
+// aren't reported again.
+func (sa *ScheduledAction) firstAfter(now, next time.Time, known bool) time.Time {
+	if sa.startsNow(now) {
+		return now
+	}
+	t := next
+	if !known {
+		t = sa.nextAfter(now)
+	} else if sa.takeCatchUp() {
+		t = now
+	}
+	if !t.IsZero() && sa.startupSpread > 0 {
+		t = t.Add(time.Duration(sa.scheduler.random() * float64(sa.startupSpread)))
+	}
+	return t
+}
+
+// Returns true if the action executes as soon as it is added at now, because of a kickoff, or
+// because it is an overdue one-off that runs if overdue.
+func (sa *ScheduledAction) startsNow(now time.Time) bool {
+	if sa.kickoff && !sa.exhausted() {
+		return true
+	}
+	ts := sa.spec()
+	return sa.runIfOverdue && !ts.recurring && ts.times == nil && !ts.when.After(now) && sa.RunCount() == 0
+}
+
+// Record when the action is next due to execute.
+func (sa *ScheduledAction) setNext(t time.Time) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.latenessAlert != nil && !t.Equal(sa.next) {
+		sa.watchLateness(t)
+	}
+	sa.next = t
+}
+
+// Check that the action starts executing the occurrence at t within its lateness grace, alerting
+// if it doesn't. This replaces the check of any earlier occurrence. sa.lock must be held.
+func (sa *ScheduledAction) watchLateness(t time.Time) {
+	if sa.lateTimer != nil {
+		sa.lateTimer.Stop()
+		sa.lateTimer = nil
+	}
+	if t.IsZero() || sa.scheduler == nil || sa.scheduler.driven {
+		return
+	}
+	sa.lateTimer = time.AfterFunc(t.Sub(sa.scheduler.now())+sa.latenessGrace, func() {
+		sa.lock.Lock()
+		late := sa.state == STATE_ACTIVE && sa.started.Before(t)
+		sa.lock.Unlock()
+		if late {
+			sa.latenessAlert(sa, t)
+		}
+	})
+}
+
+// Record that the execution scheduled for the most recent run has started.
+func (sa *ScheduledAction) markStarted() {
+	sa.lock.Lock()
+	sa.started = sa.lastScheduled
+	sa.lock.Unlock()
+}
+
+// The time the action is next due to execute, and whether an execution is scheduled.
+func (sa *ScheduledAction) NextExecution() (time.Time, bool) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.next, !sa.next.IsZero()
+}
+
+// How long until the action is next due to execute, and whether an execution is scheduled. This
+// is measured against the clock of the action's scheduler.
+func (sa *ScheduledAction) TimeUntilNext() (time.Duration, bool) {
+	next, ok := sa.NextExecution()
+	if !ok {
+		return 0, false
+	}
+	d := next.Sub(sa.scheduler.now())
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// Execute the action now, outside of its schedule, e.g. as a manual trigger. The execution is
+// subject to the same options as scheduled ones, and counts as a run. Returns once the execution
+// has completed. This has no effect on an action that hasn't been added to a schedule. On a
+// scheduler with serial execution, an action fired while another is executing runs straight away,
+// as it is taken to be fired by that one.
+func (sa *ScheduledAction) Fire() {
+	if sa.scheduler == nil {
+		return
+	}
+	sa.runInline(sa.scheduler.now(), sa.scheduler.serialExecuting())
+}
+
+// Returns true if the action last ran on the same calendar day as now, in now's location.
+func (sa *ScheduledAction) ranToday(now time.Time) bool {
+	last := sa.LastRun()
+	if last.IsZero() {
+		return false
+	}
+	return civilDay(last.In(now.Location())) == civilDay(now)
+}
+
+// Execute the occurrence scheduled at t, applying the misfire policy if the action has woken up
+// too late for it.
+func (sa *ScheduledAction) fire(t time.Time) {
+	now := sa.scheduler.now()
+	if sa.misfirePolicy != 0 && now.Sub(t) > sa.misfireGrace {
+		switch sa.misfirePolicy {
+		case MISFIRE_SKIP:
+			sa.skipped(SKIP_MISFIRE)
+			return
+		case MISFIRE_RESCHEDULE:
+			// the occurrence is moved on rather than skipped, so it isn't reported
+			return
+		}
+	}
+	sa.run(t)
+}
+
+// Execute the action for an occurrence scheduled at the given time.
+func (sa *ScheduledAction) run(scheduled time.Time) {
+	sa.runInline(scheduled, false)
+}
+
+// Execute the occurrence scheduled at t, on the calling goroutine if inline is set, rather than
+// the scheduler's serial execution goroutine.
+func (sa *ScheduledAction) runInline(scheduled time.Time, inline bool) {
+	if !sa.beginRun() {
+		return
+	}
+	defer sa.endRun()
+	switch sa.State() {
+	case STATE_DISABLED:
+		sa.skipped(SKIP_DISABLED)
+		return
+	case STATE_PAUSED:
+		sa.lock.Lock()
+		sa.missed = true
+		sa.lock.Unlock()
+		sa.skipped(SKIP_PAUSED)
+		return
+	}
+	if sa.gate != nil && !sa.gate() {
+		sa.skipped(SKIP_GATE)
+		return
+	}
+	if sa.changed != nil && !sa.changed() {
+		sa.skipped(SKIP_UNCHANGED)
+		return
+	}
+	if sa.ready != nil && !sa.waitUntilReady() {
+		sa.skipped(SKIP_NOT_READY)
+		return
+	}
+	if sa.oncePerDay && sa.ranToday(sa.scheduler.now()) {
+		sa.skipped(SKIP_ONCE_PER_DAY)
+		return
+	}
+	if !sa.scheduler.takeQuota(sa, sa.scheduler.now()) {
+		sa.skipped(SKIP_QUOTA)
+		return
+	}
+	if sa.runOnceKey != "" {
+		// whether or not it runs now, it never runs again
+		sa.CancelSelf()
+		if !sa.scheduler.claimOnce(sa.runOnceKey) {
+			sa.skipped(SKIP_RUN_ONCE)
+			return
+		}
+	}
+	began := sa.scheduler.now()
+	sa.recordRun(scheduled, began)
+	metrics := sa.scheduler.metricsSink()
+	if metrics != nil {
+		metrics.IncFires()
+		metrics.ObserveDrift(began.Sub(scheduled))
+	}
+	start := time.Now()
+	err := sa.invoke(sa.idempotencyToken(scheduled), inline)
+	elapsed := time.Since(start)
+	sa.scheduler.reportDuration(ActionDuration{Action: sa, Start: start, Elapsed: elapsed})
+	if metrics != nil {
+		metrics.ObserveDuration(elapsed)
+		if err != nil {
+			metrics.IncErrors()
+		}
+	}
+	sa.adaptInterval(sa.scheduler.now().Sub(began))
+	sa.completed(err, inline)
+	sa.scheduler.record(sa, scheduled, began, err)
+	sa.broadcastFired()
+}
+
+// The first and longest delays between readiness checks.
+const (
+	readyPollMin = 10 * time.Millisecond
+	readyPollMax = time.Second
+)
+
+// Wait until the action's readiness gate returns true, checking with backoff. Returns false if it
+// isn't ready within the timeout.
+func (sa *ScheduledAction) waitUntilReady() bool {
+	deadline := time.Now().Add(sa.readyTimeout)
+	delay := readyPollMin
+	for !sa.ready() {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > readyPollMax {
+			delay = readyPollMax
+		}
+	}
+	return true
+}
+
+// The key of the idempotency token in the context of an execution.
+type tokenKey struct{}
+
+// The parent of the context of each execution, which is cancelled once the action is removed.
+func (sa *ScheduledAction) baseContext() context.Context {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.ctx == nil {
+		sa.ctx, sa.cancelCtx = context.WithCancel(context.Background())
+	}
+	return sa.ctx
+}
+
+// Give an action that is being added again after being removed a fresh parent context for its
+// executions, as the old one has been cancelled, and a fresh channel to close when it is done. An
+// action that was drained can run again.
+func (sa *ScheduledAction) renew() {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.timerGen++
+	sa.draining = false
+	if sa.ctx != nil && sa.ctx.Err() != nil {
+		sa.ctx, sa.cancelCtx = nil, nil
+	}
+	if sa.state == STATE_DONE {
+		sa.finished = nil
+	}
+}
+
+// Cancel the contexts of the action's executions, including any in flight.
+func (sa *ScheduledAction) cancelContext() {
+	sa.baseContext()
+	sa.lock.Lock()
+	cancel := sa.cancelCtx
+	sa.lock.Unlock()
+	cancel()
+}
+
+// The idempotency token of an execution, given its context, set by WithIdempotencyTokens. Returns
+// false if the execution doesn't have one.
+func IdempotencyToken(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey{}).(string)
+	return token, ok
+}
+
+// The idempotency token of the run scheduled at scheduled, "name@time", or "" if the action doesn't
+// have tokens. A retry has the token of the run it retries, so downstream systems can dedupe it.
+func (sa *ScheduledAction) idempotencyToken(scheduled time.Time) string {
+	if !sa.idempotency {
+		return ""
+	}
+	sa.lock.Lock()
+	if sa.attempts == 0 {
+		sa.tokenScheduled = scheduled
+	}
+	scheduled = sa.tokenScheduled
+	sa.lock.Unlock()
+
+	name := sa.Name()
+	if name == "" {
+		name = sa.actionName
+	}
+	return name + "@" + scheduled.UTC().Format(time.RFC3339Nano)
+}
+
+// The context of an execution that is starting, with a deadline of the run timeout, or with
+// WithDeadlineUntilNext of the next occurrence, whichever is sooner, carrying the idempotency token
+// if there is one.
+func (sa *ScheduledAction) runContext(token string) (context.Context, context.CancelFunc) {
+	ctx := sa.baseContext()
+	if token != "" {
+		ctx = context.WithValue(ctx, tokenKey{}, token)
+	}
+	limit := sa.runTimeout
+	if sa.deadlineUntilNext {
+		now := sa.scheduler.now()
+		if next := sa.findNext(now, false); !next.IsZero() && (limit <= 0 || next.Sub(now) < limit) {
+			limit = next.Sub(now)
+		}
+	}
+	if limit <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, limit)
+}
+
+// Execute the action function, waiting at most the run timeout for it to complete. An action that
+// times out is left to finish in the background. Any idempotency token is passed in the context
+// of a context-aware action, and otherwise as the last parameter. inline is passed to execute.
+func (sa *ScheduledAction) invoke(token string, inline bool) error {
+	call := func() error {
+		var err error
+		params := sa.Parameters
+		if sa.paramProvider != nil {
+			params = sa.paramProvider()
+		}
+		if token != "" && sa.actionCtx == nil {
+			params = append(params[:len(params):len(params)], token)
+		}
+		if sa.serialGroup != "" {
+			group := sa.scheduler.serialGroup(sa.serialGroup)
+			group.Lock()
+			defer group.Unlock()
+		}
+		sa.scheduler.execute(inline, func() {
+			sa.markStarted()
+			if sa.recoverPanics {
+				defer func() {
+					if r := recover(); r != nil {
+						err = &PanicError{Value: r, Stack: debug.Stack()}
+					}
+				}()
+			}
+			if sa.actionErr != nil {
+				err = sa.actionErr(params...)
+			} else if sa.actionCtx != nil {
+				ctx, cancel := sa.runContext(token)
+				defer cancel()
+				sa.actionCtx(ctx, params...)
+			} else {
+				sa.Action(params...)
+			}
+		})
+		return err
+	}
+	if sa.runTimeout <= 0 {
+		return call()
+	}
+
+	// the run stays in flight until the action function returns, even if it times out, so Drain
+	// waits for it
+	sa.lock.Lock()
+	sa.inFlight++
+	sa.lock.Unlock()
+	result := make(chan error, 1)
 	go func() {
+		defer sa.endRun()
+		result <- call()
+	}()
+	timer := time.NewTimer(sa.runTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return ErrRunTimeout
+	}
+}
+
+// Handle the outcome of a run, reporting any error and arranging a retry if one is due.
+func (sa *ScheduledAction) completed(err error, inline bool) {
+	sa.lock.Lock()
+	if err != nil && sa.attempts < sa.retries {
+		sa.attempts++
+		sa.retryAt = sa.scheduler.now().Add(sa.nextRetryDelay())
+	} else {
+		sa.attempts = 0
+		sa.retryAt = time.Time{}
+	}
+	if err == nil && sa.resetBackoff {
+		sa.backoffAnchor = sa.lastScheduled
+	}
+	if err != nil {
+		sa.failures++
+	} else {
+		sa.failures = 0
+	}
+	sa.lastErr = err
+	fallback := sa.fallback != nil && err != nil && sa.failures == sa.fallbackAfter
+	exceeded := err != nil && sa.errorRateExceeded(sa.scheduler.now())
+	sa.lock.Unlock()
+
+	if err != nil && sa.onError != nil {
+		sa.onError(sa, err)
+	}
+	if exceeded {
+		sa.Disable()
+		if sa.onError != nil {
+			sa.onError(sa, ErrErrorRateExceeded)
+		}
+	}
+	if fallback {
+		sa.CancelSelf()
+		sa.scheduler.execute(inline, func() {
+			sa.fallback(sa.Parameters...)
+		})
+	}
+}
+
+// The delay before the retry numbered by sa.attempts, with any backoff and jitter applied.
+// sa.lock must be held.
+func (sa *ScheduledAction) nextRetryDelay() time.Duration {
+	delay := sa.retryDelay
+	if sa.retryBackoffMax > 0 {
+		for i := 1; i < sa.attempts && delay < sa.retryBackoffMax; i++ {
+			delay *= 2
+		}
+		if delay > sa.retryBackoffMax {
+			delay = sa.retryBackoffMax
+		}
+	}
+	if sa.retryJitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + sa.retryJitter*(2*sa.scheduler.random()-1)))
+	}
+	return delay
+}
+
+// Record a failure at now, returning true if it takes the action over its maximum error rate.
+// sa.lock must be held.
+func (sa *ScheduledAction) errorRateExceeded(now time.Time) bool {
+	if sa.maxErrors <= 0 {
+		return false
+	}
+	recent := sa.errorTimes[:0]
+	for _, t := range sa.errorTimes {
+		if now.Sub(t) < sa.errorWindow {
+			recent = append(recent, t)
+		}
+	}
+	sa.errorTimes = append(recent, now)
+	if len(sa.errorTimes) <= sa.maxErrors {
+		return false
+	}
+	// start afresh if the action is enabled again
+	sa.errorTimes = nil
+	return true
+}
+
+// When a retry of a failed run is due, or the zero time if there isn't one.
+func (sa *ScheduledAction) pendingRetry() time.Time {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.retryAt
+}
+
+// Report that an occurrence was skipped.
+func (sa *ScheduledAction) skipped(reason SkipReason) {
+	if sa.onSkip != nil {
+		sa.onSkip(sa, reason)
+	}
+}
+
+// If the action should have executed within the ensure-recent window before now, execute it now.
+// If the occurrence it finds is after now instead, it is returned with true, as the next execution.
+func (sa *ScheduledAction) ensureRecent(now time.Time) (time.Time, bool) {
+	if sa.ensureRecentWindow <= 0 {
+		return time.Time{}, false
+	}
+	t := sa.nextAfter(now.Add(-sa.ensureRecentWindow - time.Nanosecond))
+	if t.IsZero() || t.After(now) {
+		return t, true
+	}
+	sa.run(t)
+	return time.Time{}, false
+}
+
+// The number of commands that can be sent to a timer goroutine without blocking, e.g. while it
+// is busy executing the action.
+const cmdBuffer = 4
+
+// Given a scheduled action, start a goroutine for executing, first at the given time. The
+// goroutine has its own command channel, so one left over from before the action was removed and
+// added again doesn't pick up the new goroutine's commands.
+func (sc *ScheduledAction) startTimer(first time.Time) {
+	cmds := make(chan command, cmdBuffer)
+	done := make(chan struct{})
+	sc.lock.Lock()
+	sc.cmdChan, sc.done = cmds, done
+	gen := sc.timerGen
+	sc.lock.Unlock()
+
+	// the first execution time is determined up front, so it's known as soon as the action is added.
+	sc.setNext(first)
+
+	sc.scheduler.track(sc)
+	go func() {
+		defer close(done)
 		var timer *time.Timer
 
 	loop:
-		for t := sc.When.GetNextExec(); !t.IsZero(); {
-			d := t.Sub(time.Now())
+		for t := first; !t.IsZero(); {
+			sc.setNext(t)
+			d := t.Sub(sc.scheduler.now())
 			if d < 0 {
 				d = 0
 			}
 
+			// far-future times are approached in steps, re-evaluating after each one
+			capped := d > sc.scheduler.maxTimerWait
+			if capped {
+				d = sc.scheduler.maxTimerWait
+			}
+
 			// create the time first time around, or reset it if we're re-using it.
 			if timer == nil {
 				timer = time.NewTimer(d)
@@ -158,9 +1577,16 @@ func (sc *ScheduledAction) startTimer() {
 			// wait for either the time, or a command from the command channel
 			select {
 			case _ = <-timer.C:
+				if capped && sc.scheduler.now().Before(t) {
+					// not due yet, so wait for the next step
+					continue loop
+				}
 				// when timer goes off, we execute the action and repeat the loop
-				sc.Action(sc.Parameters...)
-			case cmd := <-sc.cmdChan:
+				sc.fire(t)
+				if sc.selfCancelled() || sc.drainCommands(cmds) {
+					break loop
+				}
+			case cmd := <-cmds:
 				if cmd == CMD_CANCEL {
 					// the scheduled action is being cancelled
 					timer.Stop()
@@ -168,139 +1594,131 @@ func (sc *ScheduledAction) startTimer() {
 				} else if cmd == CMD_UPDATE_TIME {
 					// the scheduled action has been updated, and we need to
 					// re-evaluate
-					t = sc.When.GetNextExec()
+					t = sc.nextAfter(sc.scheduler.now())
+					continue loop
+				} else if cmd == CMD_SWAP_ACTION {
+					// keep waiting for the same time, so the occurrence isn't lost
+					sc.applySwap()
 					continue loop
 				}
 			}
-			t = sc.When.GetNextExec()
+			t = sc.nextAfter(sc.scheduler.now())
 		}
-		remove(sc)
+		sc.finishTimer(gen)
 	}()
 }
 
-// Stop a scheduled action.
-func (sc *ScheduledAction) stopTimer() {
-	// send cancel command to the goroutine
-	sc.cmdChan <- CMD_CANCEL
-}
-
-// Create a new one-off time specification from a Time.
-func NewOneOff(t time.Time) *TimeSpec {
-	return &TimeSpec{recurring: false, when: t}
-}
-
-// Create a new recurring time specification from a map.
-func NewRecurring(config map[string]interface{}) *TimeSpec {
-	result := &TimeSpec{
-		recurring: true,
-		interval:  1,
-		startTime: time.Time{},
-		endTime:   time.Time{},
-		frequency: -1,
-		maxNum:    -1,
-	}
-
-	for k, v := range config {
-		switch k {
-		case "starttime": // expect time
-			result.startTime = v.(time.Time)
-		case "frequency": // expect int, which should be a FREQ_* constant
-			result.frequency = v.(int)
-		case "interval": // expect int: multiplier for frequency e.g. 2 week is a fortnight
-			result.interval = v.(int)
-		// case "byday": // - (optional) a string or array of strings that define days of the week when the action is to be executed. Valid values are "su","mo","tu","we","th","fr","sa"
-		// case "byhours": // byhour - (optional) an int or array of ints that define the hours of the day when the action is to be executed.
-		// case "byminute": // - (optional) an int or array of ints that define the minutes of the hours when the action is to be executed
-		case "endtime": // expect time
-			result.endTime = v.(time.Time)
-		case "maxnum": // expect int
-			result.maxNum = v.(int)
+// Handle any commands that were sent while the action was executing. Returns true if the action
+// has been cancelled. Time updates need no handling, as the next execution is recomputed anyway;
+// swapped action functions are picked up for the next execution.
+func (sc *ScheduledAction) drainCommands(cmds chan command) bool {
+	for {
+		select {
+		case cmd := <-cmds:
+			if cmd == CMD_CANCEL {
+				return true
+			} else if cmd == CMD_SWAP_ACTION {
+				sc.applySwap()
+			}
+		default:
+			return false
 		}
 	}
+}
 
-	// ensure startime and frequency are provided.
-	if result.startTime.IsZero() {
-		panic("recurring scheduled action must have a start date")
-	}
-	if result.frequency < FREQ_SECOND || result.frequency > FREQ_YEAR {
-		panic("recurring scheduled action must have a frequency")
+// Stop a scheduled action.
+func (sc *ScheduledAction) stopTimer() {
+	if sc.driven() {
+		sc.finish()
+		return
 	}
-
-	return result
+	// send cancel command to the goroutine
+	sc.send(CMD_CANCEL)
 }
 
-// Given the current time, evaluate what the next execution time is according to the time spec.
-// Logic is as follows:
-// - if timespec is one-off:
-//   - if the time is in the past, return the zero value for Time. Past scheduled events are not executed.
-//   - otherwise return the time
-// - if timespec is recurring:
-//   - if termination condition is met, return the zero value for Time.
-//   - compute forward from the start date, finding the closest date in the future that meets the spec, and return that.
-func (t *TimeSpec) GetNextExec() time.Time {
-	now := time.Now()
-
-	if t.recurring {
-		// if termination condition is met, return zero time
-		if !t.endTime.IsZero() && t.endTime.Before(now) {
-			return time.Time{}
-		}
-
-		// if start time is in the future, return that
-		if t.startTime.After(now) {
-			return t.startTime
-		}
+// Send a command to the timer goroutine, unless it has already exited.
+func (sc *ScheduledAction) send(cmd command) {
+	sc.lock.Lock()
+	cmds, done := sc.cmdChan, sc.done
+	sc.lock.Unlock()
+	select {
+	case cmds <- cmd:
+	case <-done:
+	}
+}
 
-		// determine period in seconds
-		period := 0
-		switch t.frequency {
-		case FREQ_SECOND:
-			period = 1
-		case FREQ_MINUTE:
-			period = 60
-		case FREQ_HOUR:
-			period = 3600
-		case FREQ_DAY:
-			period = 86400
-		case FREQ_WEEK:
-			period = 604800
-		}
+// Returns true if a timer goroutine has been started for the action.
+func (sa *ScheduledAction) timed() bool {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.cmdChan != nil
+}
 
-		if period > 0 {
-			// it's a fixed number of seconds period, which excludes months and years
-			period *= t.interval
+// Returns true if the action is driven by its scheduler rather than its own timer goroutine.
+func (sa *ScheduledAction) driven() bool {
+	return sa.scheduler != nil && (sa.scheduler.driven || sa.multiplexed)
+}
 
-			// @todo take into account byday, byhour, byminute
-			delta := now.Sub(t.startTime) // difference between start and now.
-			td := int(delta*time.Second) % period
-			prev := time.Unix(now.Unix()-int64(td), 0)
-			next := prev.Add(time.Duration(period) * time.Second)
-			return next
-		}
+// Set when a driven action is next due, finishing it if there are no more executions.
+func (sa *ScheduledAction) reschedule(next time.Time) {
+	if next.IsZero() {
+		sa.finish()
+		return
+	}
+	sa.setNext(next)
+	if sa.multiplexed {
+		sa.scheduler.wakeDriver()
+	}
+}
 
-		// @todo implement month and year
-		switch t.frequency {
-		case FREQ_MONTH:
-		case FREQ_YEAR:
-		}
+// Mark the action as done and remove it from its schedule. The action must not execute again.
+func (sa *ScheduledAction) finish() {
+	// leave the schedule first, so anything waiting on Done sees it gone
+	sa.scheduler.remove(sa)
+	sa.lock.Lock()
+	sa.finishLocked()
+	sa.lock.Unlock()
+}
 
-		return time.Time{}
-	} else {
-		if t.when.Before(now) {
-			return time.Time{}
-		}
-		return t.when
+// Finish the action as its timer goroutine of generation gen exits, unless the action has been
+// added again since, in which case a newer goroutine owns it. This is checked under the scheduler's
+// lock, as the action is added again under it.
+func (sa *ScheduledAction) finishTimer(gen uint64) {
+	s := sa.scheduler
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.timerGen != gen {
+		return
 	}
+	delete(s.actions, sa)
+	delete(s.running, sa)
+	sa.finishLocked()
 }
 
-// Register an instance of a type that might be used for schedule. This is required if actions
-// are being serialised, so that when deserialising, we know how to treat
-// func RegisterType(Action) {
+// Mark the action as done, once it has left its schedule. sa.lock must be held.
+func (sa *ScheduledAction) finishLocked() {
+	if sa.latenessAlert != nil && !sa.next.IsZero() {
+		sa.watchLateness(time.Time{})
+	}
+	sa.next = time.Time{}
+	if sa.state != STATE_DONE {
+		sa.state = STATE_DONE
+		close(sa.finishedChan())
+	}
+	if sa.ctx == nil {
+		sa.ctx, sa.cancelCtx = context.WithCancel(context.Background())
+	}
+	sa.cancelCtx()
+}
 
-// }
+// Shut down the default scheduler, removing every action and cancelling executions in flight.
+func Shutdown() {
+	defaultScheduler.Shutdown()
+}
 
-// Clear the schedule of all scheduled actions.
-// @todo if schedule is already defined and there are executing scheduled actions, terminate them so they're GC'd.
+// Clear the default schedule of all scheduled actions.
 func ClearAll() {
-	schedule = make(map[*ScheduledAction]bool)
+	defaultScheduler.ClearAll()
 }
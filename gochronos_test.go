@@ -2,11 +2,16 @@ package gochronos
 
 import (
 	// "fmt"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestAddOneOff(t *testing.T) {
+	var lock sync.Mutex
 	count := 0
 	param1 := ""
 	param2 := 0
@@ -15,6 +20,8 @@ func TestAddOneOff(t *testing.T) {
 	// properties based on parameters.
 	Add(NewOneOff(time.Now().Add(time.Second)),
 		func(args ...interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
 			param1 = args[0].(string)
 			param2 = args[1].(int)
 			count++
@@ -24,6 +31,9 @@ func TestAddOneOff(t *testing.T) {
 	// kill all scheduled actions
 	time.Sleep(time.Second * 3)
 
+	lock.Lock()
+	defer lock.Unlock()
+
 	if count != 1 {
 		t.Errorf("Expected one-off action to be executed exactly once, was executed %d times", count)
 	}
@@ -36,8 +46,8 @@ func TestAddOneOff(t *testing.T) {
 		t.Errorf("Expected second parameter to be 5, was actually %d", param2)
 	}
 
-	if len(schedule) > 0 {
-		t.Errorf("Expected schedule to empty, contains %d item(s)", len(schedule))
+	if Count() > 0 {
+		t.Errorf("Expected schedule to empty, contains %d item(s)", Count())
 	}
 
 	ClearAll()
@@ -62,14 +72,15 @@ func TestCancel(t *testing.T) {
 		t.Errorf("Expected one-off action to be cancelled and not executed, was executed %d times", count)
 	}
 
-	if len(schedule) > 0 {
-		t.Errorf("Expected schedule to empty, contains %d item(s)", len(schedule))
+	if Count() > 0 {
+		t.Errorf("Expected schedule to empty, contains %d item(s)", Count())
 	}
 
 	ClearAll()
 }
 
 func TestAddRecurring(t *testing.T) {
+	var lock sync.Mutex
 	count := 0
 
 	// starting now, every second
@@ -79,11 +90,16 @@ func TestAddRecurring(t *testing.T) {
 	})
 
 	Add(ts, func(args ...interface{}) {
+		lock.Lock()
 		count++
+		lock.Unlock()
 	})
 
 	time.Sleep(time.Second * 10)
 
+	lock.Lock()
+	defer lock.Unlock()
+
 	if count != 10 {
 		t.Errorf("Expected 1-sec recurring action running for 10 seconds to execute 10 times, was executed %d times", count)
 	}
@@ -92,6 +108,7 @@ func TestAddRecurring(t *testing.T) {
 }
 
 func TestAddRecurringInterval(t *testing.T) {
+	var lock sync.Mutex
 	count := 0
 
 	// starting now, every 2 seconds.
@@ -102,14 +119,660 @@ func TestAddRecurringInterval(t *testing.T) {
 	})
 
 	Add(ts, func(args ...interface{}) {
+		lock.Lock()
 		count++
+		lock.Unlock()
 	})
 
 	time.Sleep(time.Second * 10)
 
+	lock.Lock()
+	defer lock.Unlock()
+
 	if count != 5 {
 		t.Errorf("Expected 2-sec recurring action running for 10 seconds to execute 5 times, was executed %d times", count)
 	}
 
 	ClearAll()
 }
+
+func TestCancelSelf(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	count := 0
+
+	// every second, cancelling itself on the third run
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+	})
+
+	var sa *ScheduledAction
+	sa = v.Add(ts, func(args ...interface{}) {
+		count++
+		if count == 3 {
+			sa.CancelSelf()
+		}
+	})
+
+	v.Advance(5 * time.Second)
+
+	if count != 3 {
+		t.Errorf("Expected self-cancelling action to execute 3 times, was executed %d times", count)
+	}
+	if v.contains(sa) {
+		t.Errorf("Expected self-cancelled action to be removed from the schedule")
+	}
+}
+
+func TestAddMany(t *testing.T) {
+	start := time.Now()
+	f := func(args ...interface{}) {}
+
+	actions, errs := AddMany([]AddRequest{
+		{When: NewOneOff(start.Add(time.Hour)), Action: f},
+		{When: NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_HOUR, "byhour": 9}), Action: f},
+		{When: NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_DAY, "byhour": 9}), Action: f, Params: []interface{}{"x"}},
+	})
+
+	if len(actions) != 3 || len(errs) != 3 {
+		t.Fatalf("Expected 3 results, got %d actions and %d errors", len(actions), len(errs))
+	}
+
+	for i, valid := range []bool{true, false, true} {
+		if valid && (actions[i] == nil || errs[i] != nil) {
+			t.Errorf("Expected request %d to be added, got error %v", i, errs[i])
+		}
+		if !valid && (actions[i] != nil || errs[i] == nil) {
+			t.Errorf("Expected request %d to be rejected", i)
+		}
+	}
+
+	if actions[2] != nil && (len(actions[2].Parameters) != 1 || actions[2].Parameters[0] != "x") {
+		t.Errorf("Expected parameters to be passed through, got %v", actions[2].Parameters)
+	}
+
+	if Count() != 2 {
+		t.Errorf("Expected 2 scheduled actions, schedule contains %d item(s)", Count())
+	}
+
+	ClearAll()
+}
+
+func TestLastScheduledAndRun(t *testing.T) {
+	when := time.Now().Add(500 * time.Millisecond)
+	sa := Add(NewOneOff(when), func(args ...interface{}) {})
+
+	if !sa.LastScheduled().IsZero() || !sa.LastRun().IsZero() {
+		t.Errorf("Expected last scheduled and last run to be zero before executing")
+	}
+
+	time.Sleep(time.Second)
+
+	if !sa.LastScheduled().Equal(when) {
+		t.Errorf("Expected last scheduled to be %s, was %s", when, sa.LastScheduled())
+	}
+	if drift := sa.LastRun().Sub(sa.LastScheduled()); drift < 0 || drift > 100*time.Millisecond {
+		t.Errorf("Expected last run to be shortly after last scheduled, drift was %s", drift)
+	}
+
+	ClearAll()
+}
+
+// A clock that stays at a fixed time.
+type fixedClock struct {
+	t time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.t
+}
+
+func TestTimeUntilNext(t *testing.T) {
+	// per-2-seconds action, checked right after it fires
+	fired := make(chan bool, 10)
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": time.Now(),
+		"frequency": FREQ_SECOND,
+		"interval":  2,
+	})
+	sa := Add(ts, func(args ...interface{}) {
+		fired <- true
+	})
+
+	<-fired
+	time.Sleep(50 * time.Millisecond)
+
+	d, ok := sa.TimeUntilNext()
+	if !ok {
+		t.Fatalf("Expected recurring action to have a next execution")
+	}
+	if d < 1800*time.Millisecond || d > 2*time.Second {
+		t.Errorf("Expected time until next execution to be close to 2s, was %s", d)
+	}
+	ClearAll()
+
+	// against a fake clock, the duration is exact
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := New(WithClock(fixedClock{now}))
+	sa = s.Add(NewOneOff(now.Add(90*time.Second)), func(args ...interface{}) {})
+
+	if d, ok := sa.TimeUntilNext(); !ok || d != 90*time.Second {
+		t.Errorf("Expected time until next execution to be 90s, was %s (%v)", d, ok)
+	}
+	s.Remove(sa)
+
+	// an action that has finished has no next execution
+	sa = Add(NewOneOff(time.Now().Add(100*time.Millisecond)), func(args ...interface{}) {})
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := sa.TimeUntilNext(); ok {
+		t.Errorf("Expected completed one-off to have no next execution")
+	}
+}
+
+func TestScheduledActionString(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := New(WithClock(fixedClock{now}))
+
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": now,
+		"frequency": FREQ_HOUR,
+	})
+	sa := NewScheduledAction(ts, func(args ...interface{}) {}, []interface{}{
+		WithName("backup"), WithTags("nightly", "db"),
+	})
+
+	if str := sa.String(); !strings.Contains(str, "state=pending") {
+		t.Errorf("Expected action not yet added to be pending, got %s", str)
+	}
+
+	s.AddToSchedule(sa)
+	str := sa.String()
+	for _, expected := range []string{`name="backup"`, "tags=[nightly,db]", "recurring", "next=2024-01-01T13:00:00Z", "runs=0", "state=active"} {
+		if !strings.Contains(str, expected) {
+			t.Errorf("Expected %q to contain %q", str, expected)
+		}
+	}
+	s.Remove(sa)
+}
+
+func TestRemoveTwice(t *testing.T) {
+	sa := Add(NewOneOff(time.Now().Add(time.Hour)), func(args ...interface{}) {})
+
+	if !Remove(sa) {
+		t.Errorf("Expected first remove of a live action to return true")
+	}
+	if Remove(sa) {
+		t.Errorf("Expected second remove of the same action to return false")
+	}
+	if Count() > 0 {
+		t.Errorf("Expected schedule to empty, contains %d item(s)", Count())
+	}
+
+	// an action that has finished by itself is already gone
+	sa = Add(NewOneOff(time.Now().Add(100*time.Millisecond)), func(args ...interface{}) {})
+	time.Sleep(300 * time.Millisecond)
+	if Remove(sa) {
+		t.Errorf("Expected remove of a completed action to return false")
+	}
+}
+
+func TestFixedDelay(t *testing.T) {
+	var lock sync.Mutex
+	var starts []time.Time
+
+	// each run takes 300ms, and the next starts 200ms after it completes
+	sa := Add(NewFixedDelay(time.Now().Add(100*time.Millisecond), 200*time.Millisecond), func(args ...interface{}) {
+		lock.Lock()
+		starts = append(starts, time.Now())
+		lock.Unlock()
+		time.Sleep(300 * time.Millisecond)
+	})
+
+	time.Sleep(1300 * time.Millisecond)
+	Remove(sa)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(starts) != 3 {
+		t.Fatalf("Expected fixed-delay action to execute 3 times, was executed %d times", len(starts))
+	}
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 500*time.Millisecond || gap > 600*time.Millisecond {
+			t.Errorf("Expected runs to start 500ms apart, run %d started %s after the previous", i, gap)
+		}
+	}
+}
+
+func TestDisableEnable(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	var fired []time.Time
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+	}), func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+
+	v.Advance(2 * time.Second)
+	sa.Disable()
+	if sa.State() != STATE_DISABLED {
+		t.Errorf("Expected disabled action to be in the disabled state, got %s", sa.State())
+	}
+	v.Advance(3500 * time.Millisecond)
+	if len(fired) != 2 {
+		t.Fatalf("Expected no executions while disabled, got %v", fired)
+	}
+
+	// enabled again, it executes on the next grid point without catching up
+	sa.Enable()
+	v.Advance(time.Second)
+	if len(fired) != 3 || !fired[2].Equal(start.Add(6*time.Second)) {
+		t.Errorf("Expected a single execution on the grid at %s after enabling, got %v", start.Add(6*time.Second), fired)
+	}
+	if sa.RunCount() != 3 {
+		t.Errorf("Expected disabled occurrences not to count as runs, got %d", sa.RunCount())
+	}
+}
+
+func TestRemoveWhileExecuting(t *testing.T) {
+	started := make(chan bool, 10)
+	var lock sync.Mutex
+	count := 0
+	sa := Add(NewRecurring(map[string]interface{}{
+		"starttime": time.Now(),
+		"frequency": FREQ_SECOND,
+	}), func(args ...interface{}) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+		started <- true
+		time.Sleep(500 * time.Millisecond)
+	})
+
+	<-started
+	before := time.Now()
+	Remove(sa)
+	if d := time.Since(before); d > 50*time.Millisecond {
+		t.Errorf("Expected Remove to return immediately while the action executes, took %s", d)
+	}
+
+	select {
+	case <-sa.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected action to be cancelled once it finished executing")
+	}
+	time.Sleep(1200 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if count != 1 {
+		t.Errorf("Expected cancelled action not to execute again, was executed %d times", count)
+	}
+}
+
+func TestAddNilSpec(t *testing.T) {
+	s := New()
+	f := func(args ...interface{}) {}
+
+	for _, ts := range []*TimeSpec{nil, {}} {
+		sa, err := s.AddE(ts, f)
+		if err != ErrNilSpec {
+			t.Errorf("Expected ErrNilSpec adding %#v, got %v", ts, err)
+		}
+		if sa != nil {
+			t.Errorf("Expected no action to be returned adding %#v", ts)
+		}
+	}
+	if s.Count() != 0 {
+		t.Errorf("Expected nothing to be scheduled, schedule contains %d item(s)", s.Count())
+	}
+}
+
+func TestAddNilAction(t *testing.T) {
+	s := New()
+	ts := NewOneOff(time.Now().Add(100 * time.Millisecond))
+
+	sa, err := s.AddE(ts, nil)
+	if err != ErrNilAction || sa != nil {
+		t.Errorf("Expected ErrNilAction and no action adding a nil action, got %v and %v", err, sa)
+	}
+	if _, err := s.AddE(ts, nil, WithRegisteredAction("test.not-registered")); err != ErrNilAction {
+		t.Errorf("Expected ErrNilAction adding an unregistered action, got %v", err)
+	}
+	if s.Count() != 0 {
+		t.Errorf("Expected nothing to be scheduled, schedule contains %d item(s)", s.Count())
+	}
+
+	// nothing is left to panic when the time comes
+	time.Sleep(200 * time.Millisecond)
+}
+
+func TestReanchor(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	var fired []time.Time
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+		"interval":  10,
+	}), func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+
+	v.Advance(23 * time.Second)
+	sa.Reanchor()
+	v.Advance(30 * time.Second)
+
+	expected := []time.Duration{10, 20, 33, 43, 53}
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected %d executions, got %v", len(expected), fired)
+	}
+	for i, e := range expected {
+		if !fired[i].Equal(start.Add(e * time.Second)) {
+			t.Errorf("Expected execution %d at %ds, got %s", i, e, fired[i].Sub(start))
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	var fired []time.Time
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+		"interval":  10,
+	})
+	sa := v.Add(ts, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	}, "job", WithTags("api"))
+
+	v.Advance(25 * time.Second)
+	if err := sa.Patch(WithInterval(20)); err != nil {
+		t.Fatalf("Expected the patch to succeed, got %v", err)
+	}
+	v.Advance(40 * time.Second)
+
+	expected := []time.Duration{10, 20, 40, 60}
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected %d executions, got %v", len(expected), fired)
+	}
+	for i, e := range expected {
+		if !fired[i].Equal(start.Add(e * time.Second)) {
+			t.Errorf("Expected execution %d at %ds, got %s", i, e, fired[i].Sub(start))
+		}
+	}
+
+	if sa.Interval() != 20 || !sa.When.startTime.Equal(start) {
+		t.Errorf("Expected only the interval to change, got %s", sa.When)
+	}
+	if !reflect.DeepEqual(sa.Tags(), []string{"api"}) || !reflect.DeepEqual(sa.Parameters, []interface{}{"job"}) {
+		t.Errorf("Expected tags and params to be unchanged, got %v and %v", sa.Tags(), sa.Parameters)
+	}
+	if ts.interval != 10 {
+		t.Errorf("Expected the original time spec to be unchanged, got interval %d", ts.interval)
+	}
+
+	if err := sa.Patch(WithInterval(0)); err == nil {
+		t.Error("Expected an invalid patch to fail")
+	}
+	if sa.Interval() != 20 {
+		t.Errorf("Expected a failed patch to leave the action unchanged, got interval %d", sa.Interval())
+	}
+	if err := sa.Patch(WithMaxNum(0)); err != nil || sa.When.maxNum != -1 {
+		t.Errorf("Expected WithMaxNum(0) to be unlimited, got %v and %d", err, sa.When.maxNum)
+	}
+}
+
+func TestAddAdaptive(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	// polls every minute, every 10 seconds while there's activity, and every 5 minutes once it
+	// stops
+	activity := []bool{false, true, true, false, false}
+	var fired []time.Time
+	v.AddAdaptive(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) (time.Duration, error) {
+		fired = append(fired, v.Now())
+		if active := activity[len(fired)-1]; active {
+			return 10 * time.Second, nil
+		}
+		if len(fired) > 1 {
+			return 5 * time.Minute, nil
+		}
+		return 0, nil
+	})
+
+	v.AdvanceTo(start.Add(9 * time.Minute))
+
+	expected := []time.Duration{time.Minute, 10 * time.Second, 10 * time.Second, 5 * time.Minute}
+	if len(fired) != len(expected)+1 {
+		t.Fatalf("Expected %d executions, got %v", len(expected)+1, fired)
+	}
+	for i, e := range expected {
+		if gap := fired[i+1].Sub(fired[i]); gap != e {
+			t.Errorf("Expected gap %d to be %s, got %s", i, e, gap)
+		}
+	}
+}
+
+func TestAddCloser(t *testing.T) {
+	s := New()
+	fired := make(chan bool, 1)
+	closer, sa := s.AddCloser(NewOneOff(time.Now().Add(200*time.Millisecond)), func(args ...interface{}) {
+		fired <- true
+	})
+	if s.Count() != 1 {
+		t.Fatalf("Expected action to be scheduled")
+	}
+
+	if err := closer.Close(); err != nil {
+		t.Errorf("Unexpected error closing: %s", err)
+	}
+	if err := closer.Close(); err != nil {
+		t.Errorf("Unexpected error closing a second time: %s", err)
+	}
+	if s.Count() != 0 {
+		t.Errorf("Expected closed action to be removed, schedule contains %d item(s)", s.Count())
+	}
+	select {
+	case <-sa.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected closed action to be done")
+	}
+
+	select {
+	case <-fired:
+		t.Errorf("Expected closed action not to execute")
+	case <-time.After(400 * time.Millisecond):
+	}
+}
+
+func TestOptionAccessors(t *testing.T) {
+	v := NewVirtualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	f := func(args ...interface{}) {}
+	lunch := TimeWindow{Start: 12 * time.Hour, End: 13 * time.Hour}
+
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": v.Now(),
+		"frequency": FREQ_MINUTE,
+		"interval":  5,
+		"maxnum":    10,
+	}), f,
+		WithName("poller"), WithTags("api", "nightly"), WithStartupSpread(time.Second),
+		WithBlackout(lunch), WithRunTimeout(time.Minute), WithRetry(3, 10*time.Second),
+		WithMisfirePolicy(MISFIRE_SKIP, 30*time.Second))
+
+	if sa.Name() != "poller" || !reflect.DeepEqual(sa.Tags(), []string{"api", "nightly"}) {
+		t.Errorf("Expected name poller and tags [api nightly], got %q and %v", sa.Name(), sa.Tags())
+	}
+	if sa.Interval() != 5 || sa.MaxNum() != 10 {
+		t.Errorf("Expected interval 5 and maxnum 10, got %d and %d", sa.Interval(), sa.MaxNum())
+	}
+	if sa.StartupSpread() != time.Second || !reflect.DeepEqual(sa.Blackout(), []TimeWindow{lunch}) {
+		t.Errorf("Expected startup spread and blackout to be kept, got %s and %v", sa.StartupSpread(), sa.Blackout())
+	}
+	if sa.RunTimeout() != time.Minute {
+		t.Errorf("Expected run timeout of a minute, got %s", sa.RunTimeout())
+	}
+	if n, delay := sa.Retry(); n != 3 || delay != 10*time.Second {
+		t.Errorf("Expected 3 retries 10s apart, got %d and %s", n, delay)
+	}
+	if policy, grace := sa.MisfirePolicy(); policy != MISFIRE_SKIP || grace != 30*time.Second {
+		t.Errorf("Expected MISFIRE_SKIP with 30s grace, got %d and %s", policy, grace)
+	}
+
+	plain := v.Add(NewOneOff(v.Now().Add(time.Hour)), f)
+	if plain.Interval() != 0 || plain.MaxNum() != 0 || len(plain.Tags()) != 0 {
+		t.Errorf("Expected a one-off without options to have no interval, maxnum or tags")
+	}
+	if policy, _ := plain.MisfirePolicy(); policy != MISFIRE_FIRE_NOW {
+		t.Errorf("Expected default misfire policy MISFIRE_FIRE_NOW, got %d", policy)
+	}
+}
+
+func TestFarFutureTimer(t *testing.T) {
+	clock := &jumpClock{}
+	s := New(WithClock(clock))
+	s.maxTimerWait = 50 * time.Millisecond
+
+	fired := make(chan time.Time, 1)
+	f := func(args ...interface{}) { fired <- clock.Now() }
+
+	// years away; cancelling is honoured promptly
+	far := s.Add(NewOneOff(clock.Now().AddDate(5, 0, 0)), f)
+	time.Sleep(120 * time.Millisecond)
+	s.Remove(far)
+	select {
+	case <-far.Done():
+	case <-time.After(100 * time.Millisecond):
+		t.Fatalf("Expected cancelling a far-future action to be honoured promptly")
+	}
+
+	// when the clock gets there, the capped timer notices on its next step
+	when := clock.Now().AddDate(5, 0, 0)
+	s.Add(NewOneOff(when), f)
+	time.Sleep(120 * time.Millisecond)
+	clock.jump(5*365*24*time.Hour + 48*time.Hour)
+	select {
+	case at := <-fired:
+		if at.Before(when) {
+			t.Errorf("Expected execution no earlier than %s, got %s", when, at)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected far-future action to execute once the clock reached it")
+	}
+}
+
+func TestSwapAction(t *testing.T) {
+	s := New()
+	running := make(chan bool)
+	release := make(chan bool)
+	calls := make(chan string, 4)
+
+	sa := s.Add(NewRecurring(map[string]interface{}{
+		"starttime": time.Now(),
+		"frequency": FREQ_SECOND,
+	}), func(args ...interface{}) {
+		running <- true
+		<-release
+		calls <- "old"
+	})
+
+	// swap while the first execution is in progress
+	<-running
+	sa.SwapAction(func(args ...interface{}) {
+		calls <- "new"
+	})
+	close(release)
+
+	for _, expected := range []string{"old", "new"} {
+		select {
+		case handler := <-calls:
+			if handler != expected {
+				t.Errorf("Expected %s handler, got %s", expected, handler)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected an execution with the %s handler", expected)
+		}
+	}
+	s.Remove(sa)
+}
+
+func TestDescribe(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	f := func(args ...interface{}) {}
+
+	at := start.Add(2 * time.Hour)
+	oneOff := v.Add(NewOneOff(at), f).Describe()
+	expected := ScheduleInfo{
+		Kind:        KIND_ONE_OFF,
+		Next:        at,
+		At:          at,
+		Description: "once at 2024-01-01T11:00:00Z",
+	}
+	if !reflect.DeepEqual(oneOff, expected) {
+		t.Errorf("Expected one-off to be described as %+v, got %+v", expected, oneOff)
+	}
+
+	recurring := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"interval":  2,
+		"byhour":    []int{9, 17},
+		"maxnum":    10,
+	}), f).Describe()
+	expected = ScheduleInfo{
+		Kind:        KIND_RECURRING,
+		Next:        start.Add(8 * time.Hour),
+		Start:       start,
+		Period:      48 * time.Hour,
+		Frequency:   FREQ_DAY,
+		Interval:    2,
+		ByHour:      []int{9, 17},
+		MaxNum:      10,
+		Description: "every 2 days from 2024-01-01T09:00:00Z at hours 9,17 at most 10 times",
+	}
+	if !reflect.DeepEqual(recurring, expected) {
+		t.Errorf("Expected recurring action to be described as %+v, got %+v", expected, recurring)
+	}
+	if recurring.Kind.String() != "recurring" {
+		t.Errorf("Expected kind recurring, got %s", recurring.Kind)
+	}
+}
+
+func TestLastError(t *testing.T) {
+	v := NewVirtualScheduler(time.Now())
+	failure := errors.New("connection refused")
+	results := []error{failure, nil}
+	sa := v.AddErr(NewRecurring(map[string]interface{}{
+		"starttime": v.Now().Add(time.Minute),
+		"frequency": FREQ_MINUTE,
+		"maxnum":    2,
+	}), func(args ...interface{}) error {
+		err := results[0]
+		results = results[1:]
+		return err
+	})
+
+	if err := sa.LastError(); err != nil {
+		t.Errorf("Expected no error before the first run, got %v", err)
+	}
+	v.Advance(time.Minute)
+	if err := sa.LastError(); err != failure {
+		t.Errorf("Expected the error of the failed run, got %v", err)
+	}
+	v.Advance(time.Minute)
+	if err := sa.LastError(); err != nil {
+		t.Errorf("Expected the error to be cleared by a successful run, got %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package gochronos
+
+import (
+	"math/rand"
+)
+
+// GroupMember is one of the actions of a Group.
+type GroupMember struct {
+	// The action to invoke when the member is picked.
+	Action ActionFunc
+
+	// Relative likelihood of the member being picked. Values less than 1 are treated as 1.
+	Weight int
+}
+
+// A Group is a set of equivalent actions driven by a single time specification. Each time the
+// group is due, exactly one member is picked at random, in proportion to its weight, and its
+// action is invoked. This can be used to spread load across a number of workers.
+type Group struct {
+	When    *TimeSpec
+	Members []GroupMember
+
+	// the scheduler the group was added to, whose random numbers are used to pick members.
+	scheduler *Scheduler
+}
+
+// Create a new group of members that is driven by the time specification. To add it to the
+// schedule, call AddGroup.
+func NewGroup(ts *TimeSpec, members ...GroupMember) *Group {
+	return &Group{When: ts, Members: members}
+}
+
+// Pick a member of the group at random, in proportion to the member weights. Once the group is
+// added to a scheduler, the scheduler's random numbers are used, so picks can be made deterministic
+// with the WithRandSeed scheduler option.
+func (g *Group) Pick() GroupMember {
+	total := 0
+	for _, m := range g.Members {
+		total += memberWeight(m)
+	}
+	random := rand.Float64
+	if g.scheduler != nil {
+		random = g.scheduler.random
+	}
+	n := int(random() * float64(total))
+	for _, m := range g.Members {
+		n -= memberWeight(m)
+		if n < 0 {
+			return m
+		}
+	}
+	return g.Members[len(g.Members)-1]
+}
+
+func memberWeight(m GroupMember) int {
+	if m.Weight < 1 {
+		return 1
+	}
+	return m.Weight
+}
+
+// Invoke the action of a randomly picked member. This is the action of the group's scheduled
+// action.
+func (g *Group) Run(args ...interface{}) {
+	if len(g.Members) == 0 {
+		return
+	}
+	g.Pick().Action(args...)
+}
+
+// Add a group to the default schedule. Parameters are passed to whichever member is picked.
+func AddGroup(g *Group, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddGroup(g, args...)
+}
+
+// Add a group to the schedule. Parameters are passed to whichever member is picked.
+func (s *Scheduler) AddGroup(g *Group, args ...interface{}) *ScheduledAction {
+	g.scheduler = s
+	return s.Add(g.When, g.Run, args...)
+}
+
+// Add an ordered group of actions to the default schedule.
+func AddOrderedGroup(ts *TimeSpec, actions []ActionFunc, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddOrderedGroup(ts, actions, args...)
+}
+
+// Add an ordered group of error-returning actions to the default schedule.
+func AddOrderedGroupErr(ts *TimeSpec, actions []ActionFuncErr, args ...interface{}) *ScheduledAction {
+	return defaultScheduler.AddOrderedGroupErr(ts, actions, args...)
+}
+
+// Add a group of actions that execute at each occurrence of the time specification one after
+// another, in the order given, on a single goroutine, so each finishes before the next starts.
+// The group is a single scheduled action, and parameters are passed to every action.
+func (s *Scheduler) AddOrderedGroup(ts *TimeSpec, actions []ActionFunc, args ...interface{}) *ScheduledAction {
+	return s.Add(ts, func(params ...interface{}) {
+		for _, f := range actions {
+			f(params...)
+		}
+	}, args...)
+}
+
+// Add an ordered group of error-returning actions, as for AddOrderedGroup, except that if an
+// action returns an error, the rest of the group is skipped for that occurrence, and the run fails
+// with the error, as for AddErr.
+func (s *Scheduler) AddOrderedGroupErr(ts *TimeSpec, actions []ActionFuncErr, args ...interface{}) *ScheduledAction {
+	return s.AddErr(ts, func(params ...interface{}) error {
+		for _, f := range actions {
+			if err := f(params...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, args...)
+}
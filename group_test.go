@@ -0,0 +1,143 @@
+package gochronos
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupSelection(t *testing.T) {
+	counts := make([]int, 3)
+	var members []GroupMember
+	for i := range counts {
+		i := i
+		members = append(members, GroupMember{Action: func(args ...interface{}) {
+			counts[i]++
+		}})
+	}
+	g := NewGroup(NewOneOff(time.Now()), members...)
+
+	// roughly uniform over many ticks
+	ticks := 3000
+	for i := 0; i < ticks; i++ {
+		g.Run()
+	}
+	for i, c := range counts {
+		if c < 800 || c > 1200 {
+			t.Errorf("Expected member %d to be picked about 1000 times, was picked %d times", i, c)
+		}
+	}
+
+	// weighted 1:3
+	counts = make([]int, 2)
+	g = NewGroup(NewOneOff(time.Now()),
+		GroupMember{Action: func(args ...interface{}) { counts[0]++ }, Weight: 1},
+		GroupMember{Action: func(args ...interface{}) { counts[1]++ }, Weight: 3})
+	for i := 0; i < 4000; i++ {
+		g.Run()
+	}
+	if counts[0] < 800 || counts[0] > 1200 {
+		t.Errorf("Expected weight 1 member to be picked about 1000 times, was picked %d times", counts[0])
+	}
+}
+
+func TestGroupSeededPicks(t *testing.T) {
+	picks := func() []int {
+		var picked []int
+		var members []GroupMember
+		for i := 0; i < 5; i++ {
+			i := i
+			members = append(members, GroupMember{Action: func(args ...interface{}) {
+				picked = append(picked, i)
+			}})
+		}
+		g := NewGroup(NewOneOff(time.Now().Add(time.Hour)), members...)
+		s := New(WithRandSeed(42))
+		defer s.Remove(s.AddGroup(g))
+		for i := 0; i < 20; i++ {
+			g.Run()
+		}
+		return picked
+	}
+	if first, second := picks(), picks(); !reflect.DeepEqual(first, second) {
+		t.Errorf("Expected the same picks from schedulers with the same seed, got %v and %v", first, second)
+	}
+}
+
+func TestAddGroup(t *testing.T) {
+	var lock sync.Mutex
+	picked := 0
+	param := ""
+	f := func(args ...interface{}) {
+		lock.Lock()
+		picked++
+		param = args[0].(string)
+		lock.Unlock()
+	}
+
+	AddGroup(NewGroup(NewOneOff(time.Now().Add(200*time.Millisecond)),
+		GroupMember{Action: f}, GroupMember{Action: f}), "test")
+
+	time.Sleep(500 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if picked != 1 {
+		t.Errorf("Expected exactly one member to be executed, %d were executed", picked)
+	}
+	if param != "test" {
+		t.Errorf("Expected parameter to be passed to member, got %q", param)
+	}
+
+	ClearAll()
+}
+
+func TestOrderedGroup(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	minutely := NewRecurring(map[string]interface{}{
+		"starttime": start.Add(time.Minute),
+		"frequency": FREQ_MINUTE,
+		"maxnum":    2,
+	})
+
+	var order []string
+	step := func(name string) ActionFunc {
+		return func(args ...interface{}) {
+			order = append(order, name+args[0].(string))
+		}
+	}
+	v.AddOrderedGroup(minutely, []ActionFunc{step("a"), step("b"), step("c")}, "!")
+	v.Advance(5 * time.Minute)
+
+	expected := []string{"a!", "b!", "c!", "a!", "b!", "c!"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected the group to run in order %v each time, got %v", expected, order)
+	}
+
+	// a failure halts the rest of the group
+	order = nil
+	failure := errors.New("b failed")
+	var errs []error
+	stepErr := func(name string, err error) ActionFuncErr {
+		return func(args ...interface{}) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	v.AddOrderedGroupErr(NewOneOff(v.Now().Add(time.Minute)),
+		[]ActionFuncErr{stepErr("a", nil), stepErr("b", failure), stepErr("c", nil)},
+		WithErrorHandler(func(sa *ScheduledAction, err error) {
+			errs = append(errs, err)
+		}))
+	v.Advance(time.Minute)
+
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected the group to halt after the failure, got %v", order)
+	}
+	if len(errs) != 1 || errs[0] != failure {
+		t.Errorf("Expected the run to fail with the group's error, got %v", errs)
+	}
+}
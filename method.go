@@ -0,0 +1,84 @@
+package gochronos
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Add a scheduled action to the default schedule that calls the named method of receiver. See
+// Scheduler.AddMethod.
+func AddMethod(ts *TimeSpec, receiver interface{}, methodName string, args ...interface{}) (*ScheduledAction, error) {
+	return defaultScheduler.AddMethod(ts, receiver, methodName, args...)
+}
+
+// Add a scheduled action that calls the named method of receiver with the parameters, so state can
+// live on the receiver rather than in a closure. An error is returned if the method doesn't exist,
+// or can't be called with the parameters. If the method's only result is an error, it is treated
+// as an error-returning action, as for AddErr; other results are discarded.
+func (s *Scheduler) AddMethod(ts *TimeSpec, receiver interface{}, methodName string, args ...interface{}) (*ScheduledAction, error) {
+	if receiver == nil {
+		return nil, fmt.Errorf("gochronos: can't call %q on a nil receiver", methodName)
+	}
+	m := reflect.ValueOf(receiver).MethodByName(methodName)
+	if !m.IsValid() {
+		return nil, fmt.Errorf("gochronos: %T has no method %q", receiver, methodName)
+	}
+
+	sa := NewScheduledAction(ts, nil, args)
+	if _, err := methodArgs(m.Type(), sa.Parameters); err != nil {
+		return nil, fmt.Errorf("gochronos: can't call %s: %s", methodName, err)
+	}
+
+	returnsErr := m.Type().NumOut() == 1 && m.Type().Out(0) == errorType
+	sa.actionErr = func(params ...interface{}) error {
+		in, err := methodArgs(m.Type(), params)
+		if err != nil {
+			return err
+		}
+		out := m.Call(in)
+		if returnsErr && !out[0].IsNil() {
+			return out[0].Interface().(error)
+		}
+		return nil
+	}
+	return s.addAction(sa)
+}
+
+// Convert parameters to the arguments of a method of type t, returning an error if they don't fit.
+func methodArgs(t reflect.Type, params []interface{}) ([]reflect.Value, error) {
+	n := t.NumIn()
+	if t.IsVariadic() {
+		if len(params) < n-1 {
+			return nil, fmt.Errorf("expected at least %d parameters, got %d", n-1, len(params))
+		}
+	} else if len(params) != n {
+		return nil, fmt.Errorf("expected %d parameters, got %d", n, len(params))
+	}
+
+	in := make([]reflect.Value, len(params))
+	for i, p := range params {
+		var pt reflect.Type
+		if t.IsVariadic() && i >= n-1 {
+			pt = t.In(n - 1).Elem()
+		} else {
+			pt = t.In(i)
+		}
+
+		if p == nil {
+			switch pt.Kind() {
+			case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+				in[i] = reflect.Zero(pt)
+				continue
+			}
+			return nil, fmt.Errorf("parameter %d can't be nil", i)
+		}
+		v := reflect.ValueOf(p)
+		if !v.Type().AssignableTo(pt) {
+			return nil, fmt.Errorf("parameter %d is %s, expected %s", i, v.Type(), pt)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
@@ -0,0 +1,63 @@
+package gochronos
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type counter struct {
+	total int
+	last  string
+}
+
+func (c *counter) Increment(by int, label string) {
+	c.total += by
+	c.last = label
+}
+
+func (c *counter) Fail() error {
+	return errors.New("failed")
+}
+
+func TestAddMethod(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	c := &counter{}
+
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+	if _, err := v.AddMethod(ts, c, "Increment", 5, "tick"); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	v.Advance(3 * time.Hour)
+	if c.total != 15 || c.last != "tick" {
+		t.Errorf("Expected the receiver to be updated 3 times, got total %d and label %q", c.total, c.last)
+	}
+
+	// the method and its parameters are checked when adding
+	if _, err := v.AddMethod(ts, c, "Decrement"); err == nil {
+		t.Errorf("Expected an error for a missing method")
+	}
+	if _, err := v.AddMethod(ts, c, "Increment", "5", "tick"); err == nil {
+		t.Errorf("Expected an error for a parameter of the wrong type")
+	}
+	if _, err := v.AddMethod(ts, c, "Increment", 5); err == nil {
+		t.Errorf("Expected an error for the wrong number of parameters")
+	}
+
+	// an error result is reported
+	var reported error
+	if _, err := v.AddMethod(ts, c, "Fail", WithErrorHandler(func(sa *ScheduledAction, err error) {
+		reported = err
+	})); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	v.Advance(time.Hour)
+	if reported == nil || reported.Error() != "failed" {
+		t.Errorf("Expected the method's error to be reported, got %v", reported)
+	}
+}
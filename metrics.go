@@ -0,0 +1,43 @@
+package gochronos
+
+import (
+	"time"
+)
+
+// MetricsSink receives metrics of the executions of a scheduler's actions, so they can be
+// exported, e.g. to Prometheus or OpenTelemetry, without the package depending on either. Its
+// methods are called from the goroutines that execute actions, so they must be safe for
+// concurrent use, and should return quickly.
+type MetricsSink interface {
+	// Called when an execution starts.
+	IncFires()
+
+	// Called when an execution fails, once it has completed.
+	IncErrors()
+
+	// Called when an execution starts, with how late it started compared to its scheduled time.
+	ObserveDrift(d time.Duration)
+
+	// Called when an execution completes, with the wall-clock time it took.
+	ObserveDuration(d time.Duration)
+}
+
+// Send metrics of the default schedule's executions to sink.
+func SetMetricsSink(sink MetricsSink) {
+	defaultScheduler.SetMetricsSink(sink)
+}
+
+// Send metrics of the scheduler's executions to sink. Skipped occurrences aren't executions, so
+// aren't counted. nil, the default, stops sending metrics.
+func (s *Scheduler) SetMetricsSink(sink MetricsSink) {
+	s.lock.Lock()
+	s.metrics = sink
+	s.lock.Unlock()
+}
+
+// The sink metrics are sent to, or nil.
+func (s *Scheduler) metricsSink() MetricsSink {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.metrics
+}
@@ -0,0 +1,52 @@
+package gochronos
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A sink that records the calls made to it.
+type fakeSink struct {
+	calls []string
+	drift []time.Duration
+}
+
+func (f *fakeSink) IncFires()  { f.calls = append(f.calls, "fire") }
+func (f *fakeSink) IncErrors() { f.calls = append(f.calls, "error") }
+
+func (f *fakeSink) ObserveDrift(d time.Duration) {
+	f.calls = append(f.calls, "drift")
+	f.drift = append(f.drift, d)
+}
+
+func (f *fakeSink) ObserveDuration(d time.Duration) {
+	f.calls = append(f.calls, "duration")
+}
+
+func TestMetricsSink(t *testing.T) {
+	v := NewVirtualScheduler(time.Now())
+	sink := &fakeSink{}
+	v.SetMetricsSink(sink)
+
+	results := []error{nil, errors.New("failed")}
+	v.AddErr(NewRecurring(map[string]interface{}{
+		"starttime": v.Now().Add(time.Minute),
+		"frequency": FREQ_MINUTE,
+		"maxnum":    2,
+	}), func(args ...interface{}) error {
+		err := results[0]
+		results = results[1:]
+		return err
+	})
+	v.Advance(5 * time.Minute)
+
+	expected := []string{"fire", "drift", "duration", "fire", "drift", "duration", "error"}
+	if !reflect.DeepEqual(sink.calls, expected) {
+		t.Errorf("Expected calls %v, got %v", expected, sink.calls)
+	}
+	if !reflect.DeepEqual(sink.drift, []time.Duration{0, 0}) {
+		t.Errorf("Expected no drift in virtual time, got %v", sink.drift)
+	}
+}
@@ -0,0 +1,101 @@
+package gochronos
+
+import (
+	"time"
+)
+
+// Bound the number of goroutines the scheduler uses for timers to n. Each action normally has
+// its own timer goroutine; once n-1 are running, further actions are instead multiplexed onto a
+// single shared driver goroutine, which sleeps until the earliest of them is due. Multiplexed
+// actions execute one at a time on the driver, so a slow one delays the others, but they are
+// otherwise scheduled exactly as before. Actions already added are unaffected. 0, the default,
+// is unlimited.
+func (s *Scheduler) SetMaxGoroutines(n int) {
+	s.lock.Lock()
+	s.maxGoroutines = n
+	s.lock.Unlock()
+}
+
+// Determine whether an action being added is to be multiplexed onto the driver goroutine,
+// starting the driver if it isn't running. s.lock must be held.
+func (s *Scheduler) multiplex(sa *ScheduledAction) bool {
+	if s.driven || s.maxGoroutines <= 0 || len(s.running) < s.maxGoroutines-1 {
+		return false
+	}
+	sa.multiplexed = true
+	if !s.driving {
+		s.driving = true
+		if s.driverWake == nil {
+			s.driverWake = make(chan struct{}, 1)
+		}
+		go s.drive()
+	}
+	return true
+}
+
+// Wake the driver goroutine, so it re-evaluates when its actions are next due.
+func (s *Scheduler) wakeDriver() {
+	select {
+	case s.driverWake <- struct{}{}:
+	default:
+	}
+}
+
+// Execute the multiplexed actions as they fall due, until none are left in the schedule.
+func (s *Scheduler) drive() {
+	for {
+		first, ok := s.firstMultiplexed()
+		if !ok {
+			return
+		}
+
+		d := s.maxTimerWait
+		if !first.IsZero() {
+			if until := first.Sub(s.now()); until < d {
+				d = until
+			}
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-s.driverWake:
+			timer.Stop()
+			continue
+		}
+
+		now := s.now()
+		for _, due := range s.allDue(now) {
+			sa := due.sa
+			if !sa.multiplexed || sa.State() == STATE_DONE {
+				continue
+			}
+			sa.applySwap()
+			sa.fire(due.next)
+			if sa.State() != STATE_DONE {
+				sa.reschedule(sa.nextAfter(s.now()))
+			}
+		}
+	}
+}
+
+// The earliest time a multiplexed action is next due, or the zero time if none are scheduled.
+// Returns false, and marks the driver as stopped, if there are no multiplexed actions left.
+func (s *Scheduler) firstMultiplexed() (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var first time.Time
+	found := false
+	for sa := range s.actions {
+		if !sa.multiplexed {
+			continue
+		}
+		found = true
+		if next, ok := sa.NextExecution(); ok && (first.IsZero() || next.Before(first)) {
+			first = next
+		}
+	}
+	if !found {
+		s.driving = false
+	}
+	return first, found
+}
@@ -0,0 +1,80 @@
+package gochronos
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaxGoroutines(t *testing.T) {
+	s := New()
+	s.SetMaxGoroutines(5)
+	before := runtime.NumGoroutine()
+
+	const actions = 50
+	var lock sync.Mutex
+	fired := make(map[int]int)
+	var wg sync.WaitGroup
+	wg.Add(actions)
+	start := time.Now()
+	for i := 0; i < actions; i++ {
+		ts := NewRecurring(map[string]interface{}{
+			"starttime": start.Add(time.Duration(100+i) * time.Millisecond),
+			"frequency": FREQ_SECOND,
+			"maxnum":    2,
+		})
+		s.Add(ts, func(args ...interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
+			i := args[0].(int)
+			fired[i]++
+			if fired[i] == 2 {
+				wg.Done()
+			}
+		}, i)
+	}
+
+	if n := runtime.NumGoroutine() - before; n > 5 {
+		t.Errorf("Expected at most 5 goroutines for %d actions, got %d", actions, n)
+	}
+
+	wg.Wait()
+	lock.Lock()
+	defer lock.Unlock()
+	for i := 0; i < actions; i++ {
+		if fired[i] != 2 {
+			t.Errorf("Expected action %d to execute twice, executed %d times", i, fired[i])
+		}
+	}
+}
+
+func TestMaxGoroutinesRemove(t *testing.T) {
+	s := New()
+	s.SetMaxGoroutines(1)
+
+	// multiplexing needs the driver goroutine, which a virtual scheduler doesn't use, so this runs
+	// in real time, on a short cadence
+	var lock sync.Mutex
+	count := 0
+	sa := s.Add(NewFixedDelay(time.Now(), 10*time.Millisecond), func(args ...interface{}) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+	})
+	if !waitFor(func() bool { return sa.RunCount() >= 2 }) {
+		t.Fatalf("Expected the multiplexed action to execute, executed %d times", sa.RunCount())
+	}
+	s.Remove(sa)
+	<-sa.Done()
+
+	lock.Lock()
+	n := count
+	lock.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if count != n {
+		t.Errorf("Expected no executions after removal, got %d more", count-n)
+	}
+}
@@ -0,0 +1,72 @@
+package gochronos
+
+import (
+	"sync"
+)
+
+// OnceStore records which run-once keys have been used, so that actions added with WithRunOnceKey
+// execute at most once ever. A store that persists across restarts, such as one backed by a
+// database, prevents one-time tasks from running again when the program restarts.
+type OnceStore interface {
+	// Returns true if the key has been marked done.
+	Done(key string) bool
+
+	// Mark the key done.
+	MarkDone(key string)
+}
+
+// The store used when none has been set, which only remembers keys for the life of the process.
+type memoryOnceStore struct {
+	lock sync.Mutex
+	done map[string]bool
+}
+
+func (m *memoryOnceStore) Done(key string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.done[key]
+}
+
+func (m *memoryOnceStore) MarkDone(key string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.done == nil {
+		m.done = make(map[string]bool)
+	}
+	m.done[key] = true
+}
+
+// Set the store that records the keys of actions added with WithRunOnceKey. Without a store, keys
+// are only remembered by the scheduler for the life of the process.
+func (s *Scheduler) SetOnceStore(store OnceStore) {
+	s.lock.Lock()
+	s.onceStore = store
+	s.lock.Unlock()
+}
+
+// Claim a run-once key, returning false if it has already been used. The key is marked done before
+// the action executes, so an execution that is interrupted is not repeated.
+func (s *Scheduler) claimOnce(key string) bool {
+	s.lock.Lock()
+	if s.onceStore == nil {
+		s.onceStore = &memoryOnceStore{}
+	}
+	store := s.onceStore
+	s.lock.Unlock()
+
+	if store.Done(key) {
+		return false
+	}
+	store.MarkDone(key)
+	return true
+}
+
+// Execute the action at most once ever, as recorded under key in the scheduler's OnceStore. Before
+// executing, the key is checked and marked done; if it was already done, the occurrence is skipped
+// with SKIP_RUN_ONCE. Either way, the action then completes. This is useful for migrations and other
+// one-time tasks that must never run twice, even across restarts.
+func WithRunOnceKey(key string) Option {
+	return func(sa *ScheduledAction) {
+		sa.runOnceKey = key
+	}
+}
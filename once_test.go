@@ -0,0 +1,47 @@
+package gochronos
+
+import (
+	"testing"
+	"time"
+)
+
+// A store shared between schedulers, standing in for persistent storage.
+type testOnceStore map[string]bool
+
+func (s testOnceStore) Done(key string) bool { return s[key] }
+func (s testOnceStore) MarkDone(key string)  { s[key] = true }
+
+func TestRunOnceKey(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := testOnceStore{}
+	count := 0
+	f := func(args ...interface{}) { count++ }
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+
+	// the first run of the program executes the migration once, even though it recurs
+	first := NewVirtualScheduler(start)
+	first.SetOnceStore(store)
+	sa := first.Add(hourly, f, WithRunOnceKey("migrate-v2"))
+	first.Advance(3 * time.Hour)
+	if count != 1 || sa.State() != STATE_DONE {
+		t.Fatalf("Expected action to execute once and complete, executed %d times, state %s", count, sa.State())
+	}
+
+	// after a restart, it is skipped
+	var reasons []SkipReason
+	second := NewVirtualScheduler(start)
+	second.SetOnceStore(store)
+	sa = second.Add(hourly, f, WithRunOnceKey("migrate-v2"), WithOnSkip(func(sa *ScheduledAction, reason SkipReason) {
+		reasons = append(reasons, reason)
+	}))
+	second.Advance(3 * time.Hour)
+	if count != 1 {
+		t.Errorf("Expected action not to execute again after a restart, executed %d times", count)
+	}
+	if len(reasons) != 1 || reasons[0] != SKIP_RUN_ONCE || sa.State() != STATE_DONE {
+		t.Errorf("Expected one SKIP_RUN_ONCE and the action to complete, got %v, state %s", reasons, sa.State())
+	}
+}
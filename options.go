@@ -0,0 +1,439 @@
+package gochronos
+
+import (
+	"time"
+)
+
+// Option configures optional behaviour of a scheduled action. Options can be passed anywhere
+// action parameters are accepted, e.g. to Add or NewScheduledAction. They are applied to the
+// scheduled action and are not passed to the action function.
+type Option func(*ScheduledAction)
+
+// Separate any options from the parameters that are passed to the action function.
+func splitOptions(args []interface{}) ([]interface{}, []Option) {
+	var params []interface{}
+	var opts []Option
+	for _, arg := range args {
+		if opt, ok := arg.(Option); ok {
+			opts = append(opts, opt)
+		} else {
+			params = append(params, arg)
+		}
+	}
+	return params, opts
+}
+
+// Give the action a name, which is used when describing it, e.g. in logs.
+func WithName(name string) Option {
+	return func(sa *ScheduledAction) {
+		sa.name = name
+	}
+}
+
+// Tag the action, so that related actions can be identified.
+func WithTags(tags ...string) Option {
+	return func(sa *ScheduledAction) {
+		sa.tags = append(sa.tags, tags...)
+	}
+}
+
+// Change the interval of the action's recurring time spec, i.e. the multiplier on its frequency,
+// keeping the rest of the spec, e.g. with Patch.
+func WithInterval(n int) Option {
+	return func(sa *ScheduledAction) {
+		sa.patchSpec(func(ts *TimeSpec) { ts.interval = n })
+	}
+}
+
+// Change the end time of the action's recurring time spec, keeping the rest of the spec. The zero
+// time removes the end time.
+func WithEndTime(t time.Time) Option {
+	return func(sa *ScheduledAction) {
+		sa.patchSpec(func(ts *TimeSpec) { ts.endTime = t })
+	}
+}
+
+// Change the maximum number of executions of the action's recurring time spec, keeping the rest of
+// the spec. 0 or less is unlimited.
+func WithMaxNum(n int) Option {
+	if n <= 0 {
+		n = -1
+	}
+	return func(sa *ScheduledAction) {
+		sa.patchSpec(func(ts *TimeSpec) { ts.maxNum = n })
+	}
+}
+
+// Replace the action's time spec with a copy changed by patch, so other actions sharing the spec
+// aren't affected. The spec is swapped under the lock, as the timer goroutine may be reading it.
+func (sa *ScheduledAction) patchSpec(patch func(ts *TimeSpec)) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.When == nil {
+		return
+	}
+	ts := *sa.When
+	patch(&ts)
+	sa.When = &ts
+}
+
+// Shift every occurrence of the action's time spec by d, e.g. so that actions on the same 5 minute
+// grid land at different seconds within the period. Unlike WithStartupSpread, the offset applies
+// to every execution and is the same on every run of the program.
+func WithPhaseOffset(d time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.phaseOffset = d
+	}
+}
+
+// Control whether a recurring action can execute exactly at the start time of its time spec. By
+// default the start time is inclusive, so it is the first occurrence; if inclusive is false,
+// the first occurrence is the one after the start time.
+func WithInclusiveStart(inclusive bool) Option {
+	return func(sa *ScheduledAction) {
+		sa.exclusiveStart = !inclusive
+	}
+}
+
+// When the action is added, execute it straight away, up to max times, for occurrences it missed
+// before then, e.g. while the process was down: those since its last run, or since the start of
+// its time spec. This only applies once, when the action is added; occurrences missed while the
+// scheduler is running, e.g. because of a stall, are not made up.
+func WithStartupCatchUp(max int) Option {
+	return func(sa *ScheduledAction) {
+		sa.startupCatchUp = max
+	}
+}
+
+// Delay the first execution of the action by a random amount within d. Subsequent executions
+// of a recurring action remain on the schedule's normal cadence. This is useful for spreading
+// out the start-up of many recurring actions so they don't all fire at once.
+func WithStartupSpread(d time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.startupSpread = d
+	}
+}
+
+// TimeWindow is a daily window of time, given as offsets from midnight. If End is before Start,
+// the window spans midnight.
+type TimeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// Returns true if the time of day of t falls within the window.
+func (w TimeWindow) contains(t time.Time) bool {
+	tod := t.Sub(midnight(t))
+	if w.Start <= w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// The end of the window containing t.
+func (w TimeWindow) end(t time.Time) time.Time {
+	m := midnight(t)
+	if t.Sub(m) >= w.End {
+		m = m.AddDate(0, 0, 1)
+	}
+	return m.Add(w.End)
+}
+
+// The start of t's day, in t's location.
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Suspend the action during daily blackout windows. Occurrences that fall within a window are
+// skipped, and the action resumes with the first occurrence after the window. Windows are
+// evaluated in the local time of the scheduler.
+func WithBlackout(windows ...TimeWindow) Option {
+	return func(sa *ScheduledAction) {
+		sa.blackout = append(sa.blackout, windows...)
+	}
+}
+
+// Ensure the action has executed recently. When the action is added, if an occurrence fell within
+// window before now, the action is executed once immediately, before Add returns. Older missed
+// occurrences are not executed.
+func WithEnsureRecent(window time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.ensureRecentWindow = window
+	}
+}
+
+// The reason an occurrence of an action was skipped.
+type SkipReason int
+
+const (
+	// The action's gate returned false
+	SKIP_GATE SkipReason = 1 + iota
+	// The occurrence fell within a blackout window
+	SKIP_BLACKOUT
+	// The quota of one of the action's groups was used up
+	SKIP_QUOTA
+	// The action is disabled
+	SKIP_DISABLED
+	// The action woke up too late for the occurrence, and its misfire policy is MISFIRE_SKIP
+	SKIP_MISFIRE
+	// The action has already executed today, and was added with WithOncePerDay
+	SKIP_ONCE_PER_DAY
+	// Nothing changed since the last occurrence, and the action was added with WithChangeTrigger
+	SKIP_UNCHANGED
+	// The action's run-once key has already been used, and it was added with WithRunOnceKey
+	SKIP_RUN_ONCE
+	// The action's readiness gate didn't become ready within its timeout
+	SKIP_NOT_READY
+	// The action is paused
+	SKIP_PAUSED
+)
+
+var skipReasonNames = map[SkipReason]string{
+	SKIP_GATE:     "gate",
+	SKIP_BLACKOUT: "blackout",
+	SKIP_QUOTA:    "quota",
+	SKIP_DISABLED: "disabled",
+	SKIP_MISFIRE:  "misfire",
+
+	SKIP_ONCE_PER_DAY: "once per day",
+	SKIP_UNCHANGED:    "unchanged",
+	SKIP_RUN_ONCE:     "run once",
+	SKIP_NOT_READY:    "not ready",
+	SKIP_PAUSED:       "paused",
+}
+
+func (r SkipReason) String() string {
+	return skipReasonNames[r]
+}
+
+// Put the action in a serial group. Executions of actions in the same group of a scheduler run one
+// at a time, e.g. so jobs for the same entity don't overlap, while different groups still run
+// concurrently. An execution that falls due while another in its group is running waits for it to
+// complete.
+func WithSerialGroup(key string) Option {
+	return func(sa *ScheduledAction) {
+		sa.serialGroup = key
+	}
+}
+
+// Only execute an occurrence if gate returns true when it falls due. Gated-off occurrences are
+// skipped, and don't count towards the maximum number of executions.
+func WithGate(gate func() bool) Option {
+	return func(sa *ScheduledAction) {
+		sa.gate = gate
+	}
+}
+
+// When an occurrence falls due, wait until ready returns true before executing, e.g. so a job
+// doesn't run until the database it needs is up. Unlike WithGate, the occurrence isn't skipped
+// straight away: readiness is re-checked with backoff, for up to timeout. If the action isn't ready
+// by then, the occurrence is skipped with SKIP_NOT_READY. The wait is in real time, even on a
+// virtual scheduler.
+func WithReadinessGate(ready func() bool, timeout time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.ready = ready
+		sa.readyTimeout = timeout
+	}
+}
+
+// Only execute an occurrence if changed returns true when it falls due, i.e. the watched value or
+// resource has changed since it was last checked. This makes a recurring action poll, and only
+// act on change. Occurrences where nothing changed are skipped with SKIP_UNCHANGED.
+func WithChangeTrigger(changed func() bool) Option {
+	return func(sa *ScheduledAction) {
+		sa.changed = changed
+	}
+}
+
+// Call f for each occurrence of the action that is skipped, with the reason. Occurrences skipped by
+// a gate are reported when they fall due. Blackout windows are reported once per window skipped,
+// when the next execution is determined. f is called from the action's timer goroutine, so it should
+// return quickly.
+func WithOnSkip(f func(sa *ScheduledAction, reason SkipReason)) Option {
+	return func(sa *ScheduledAction) {
+		sa.onSkip = f
+	}
+}
+
+// Call f with the error of each failed run of the action. Runs fail if an action added with AddErr
+// returns an error, or if the run exceeds its run timeout.
+func WithErrorHandler(f func(sa *ScheduledAction, err error)) Option {
+	return func(sa *ScheduledAction) {
+		sa.onError = f
+	}
+}
+
+// Recover panics in the action function, failing the run with a *PanicError that matches ErrPanic
+// and holds the panic value and stack, so panics reach the error handler like returned errors.
+// Without this, a panicking action crashes the program.
+func WithPanicRecovery() Option {
+	return func(sa *ScheduledAction) {
+		sa.recoverPanics = true
+	}
+}
+
+// Fail a run with ErrRunTimeout if the action doesn't complete within d. Go can't stop the action
+// function, so it is left to finish in the background, and the schedule carries on.
+func WithRunTimeout(d time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.runTimeout = d
+	}
+}
+
+// Call alert with the scheduled time of an occurrence if the action hasn't started executing it
+// within grace of that time, e.g. because the scheduler is wedged or executions are starved.
+// This is checked independently of the action, so it catches executions that never start,
+// including those that are skipped. alert is called from its own goroutine. Lateness isn't
+// checked in virtual time.
+func WithLatenessAlert(grace time.Duration, alert func(sa *ScheduledAction, scheduled time.Time)) Option {
+	return func(sa *ScheduledAction) {
+		sa.latenessGrace = grace
+		sa.latenessAlert = alert
+	}
+}
+
+// Give the context of each execution of an action added with AddCtx a deadline of the action's
+// next occurrence, or of its run timeout if that is sooner, so a long execution knows not to run
+// into the next one.
+func WithDeadlineUntilNext() Option {
+	return func(sa *ScheduledAction) {
+		sa.deadlineUntilNext = true
+	}
+}
+
+// Give each run of the action an idempotency token, "name@time" from the action's name and the
+// run's scheduled time in UTC, so downstream systems can dedupe work it enqueues. A retry has the
+// same token as the run it retries. The token is passed in the context of an action added with
+// AddCtx, where IdempotencyToken returns it, and otherwise as an extra, final string parameter.
+// The action should be named with WithName, so that its tokens are stable across restarts.
+func WithIdempotencyTokens() Option {
+	return func(sa *ScheduledAction) {
+		sa.idempotency = true
+	}
+}
+
+// Retry a failed run up to n times, delay after each failure. Retries take precedence over the time
+// specification, which resumes once a run succeeds or the retries are used up. Retries count towards
+// maxnum.
+func WithRetry(n int, delay time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.retries = n
+		sa.retryDelay = delay
+	}
+}
+
+// Double the delay given to WithRetry after each retry of a failed run, up to max, so that
+// something that is down isn't hammered.
+func WithRetryBackoff(max time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.retryBackoffMax = max
+	}
+}
+
+// Randomly vary each retry delay by up to fraction of it either way, e.g. 0.2 for ±20%, so that
+// many actions failing at once don't retry at once. This applies after WithRetryBackoff. The
+// random numbers can be made deterministic with the WithRandSeed scheduler option.
+func WithRetryJitter(fraction float64) Option {
+	return func(sa *ScheduledAction) {
+		sa.retryJitter = fraction
+	}
+}
+
+// If n consecutive runs fail, including retries, execute fallback once with the action's
+// parameters, and stop the action, e.g. to alert someone. Runs fail as described for
+// WithErrorHandler.
+func WithFallback(n int, fallback ActionFunc) Option {
+	return func(sa *ScheduledAction) {
+		sa.fallbackAfter = n
+		sa.fallback = fallback
+	}
+}
+
+// Disable the action if more than failures runs fail within any window of length window, to avoid
+// an error storm from a job that is consistently failing. Once disabled, the error handler is
+// called with ErrErrorRateExceeded. The action can be enabled again with Enable.
+func WithMaxErrorRate(failures int, window time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.maxErrors = failures
+		sa.errorWindow = window
+	}
+}
+
+// Adapt the period of a recurring action to how long its executions take, measured by the
+// scheduler's clock. If its recent executions consistently take longer than the period, the
+// period doubles, so the action isn't perpetually running late; when they consistently take less
+// than half of it, it halves again. The period stays between min and max. The current period is
+// given by ScheduledAction.CurrentInterval.
+func WithAdaptiveInterval(min, max time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.adaptiveMin = min
+		sa.adaptiveMax = max
+	}
+}
+
+// When the action has a backoff time spec, restart the backoff from its base interval after each
+// successful run, so the cadence tightens again once the action recovers. Runs fail as described
+// for WithErrorHandler.
+func WithResetBackoffOnSuccess() Option {
+	return func(sa *ScheduledAction) {
+		sa.resetBackoff = true
+	}
+}
+
+// How an occurrence is handled when an action wakes up too late for it.
+type MisfirePolicy int
+
+const (
+	// Execute the occurrence now, as if it was on time
+	MISFIRE_FIRE_NOW MisfirePolicy = 1 + iota
+	// Skip the occurrence, and wait for the next one on the schedule
+	MISFIRE_SKIP
+	// Don't execute the occurrence, and roll on to the next one on the schedule's original grid,
+	// without reporting the occurrence as skipped
+	MISFIRE_RESCHEDULE
+)
+
+// Apply policy to occurrences that the action wakes up for more than grace late, e.g. because the
+// process was suspended or overloaded. Occurrences within grace execute normally. Without a misfire
+// policy, late occurrences execute as soon as possible.
+func WithMisfirePolicy(policy MisfirePolicy, grace time.Duration) Option {
+	return func(sa *ScheduledAction) {
+		sa.misfirePolicy = policy
+		sa.misfireGrace = grace
+	}
+}
+
+// If the action is a one-off whose time has already passed when it is added, execute it once
+// immediately rather than discarding it, however long ago it was due. This is useful when
+// restoring persisted one-offs.
+func WithRunIfOverdue() Option {
+	return func(sa *ScheduledAction) {
+		sa.runIfOverdue = true
+	}
+}
+
+// Execute the action at most once per calendar day, in the local time of the scheduler, however
+// many occurrences, catch-ups or manual fires would otherwise execute it. Further executions on
+// the same day are skipped with SKIP_ONCE_PER_DAY.
+func WithOncePerDay() Option {
+	return func(sa *ScheduledAction) {
+		sa.oncePerDay = true
+	}
+}
+
+// Pass the parameters returned by provider to the action, calling it afresh for each execution, in
+// place of the parameters given when the action was added.
+func WithParamProvider(provider func() []interface{}) Option {
+	return func(sa *ScheduledAction) {
+		sa.paramProvider = provider
+	}
+}
+
+// Pass base followed by the parameters returned by provider to the action, calling provider afresh
+// for each execution. This saves repeating constant parameters in the provider.
+func WithMergeParams(base []interface{}, provider func() []interface{}) Option {
+	base = append([]interface{}(nil), base...)
+	return WithParamProvider(func() []interface{} {
+		return append(append([]interface{}(nil), base...), provider()...)
+	})
+}
@@ -0,0 +1,853 @@
+package gochronos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartupSpread(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start, WithRandSeed(1))
+	var fired []time.Time
+	spread := 800 * time.Millisecond
+
+	// every second, with the first execution spread over 800ms
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start.Add(time.Second),
+		"frequency": FREQ_SECOND,
+	})
+	v.Add(ts, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	}, WithStartupSpread(spread))
+
+	v.Advance(4 * time.Second)
+
+	if len(fired) < 3 {
+		t.Fatalf("Expected at least 3 executions in 4 seconds, was executed %d times", len(fired))
+	}
+	grid := start.Add(time.Second)
+	if delay := fired[0].Sub(grid); delay <= 0 || delay > spread {
+		t.Errorf("Expected first execution to be delayed by up to %s, was delayed by %s", spread, delay)
+	}
+	for _, f := range fired[1:] {
+		if !f.Equal(f.Truncate(time.Second)) {
+			t.Errorf("Expected subsequent executions on the second, got %s", f)
+		}
+	}
+}
+
+func TestBlackout(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// every hour, except between 2am and 4am
+	sa := NewScheduledAction(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	}), func(args ...interface{}) {}, []interface{}{
+		WithBlackout(TimeWindow{Start: 2 * time.Hour, End: 4 * time.Hour}),
+	})
+
+	var hours []int
+	for next := sa.nextAfter(start); next.Before(start.AddDate(0, 0, 1)); next = sa.nextAfter(next) {
+		hours = append(hours, next.Hour())
+	}
+
+	if len(hours) != 21 {
+		t.Errorf("Expected 21 executions in a day, got %d: %v", len(hours), hours)
+	}
+	for _, h := range hours {
+		if h == 2 || h == 3 {
+			t.Errorf("Expected no executions during the blackout, got one at %d:00", h)
+		}
+	}
+	if hours[0] != 1 || hours[1] != 4 {
+		t.Errorf("Expected executions to resume at 4:00 after 1:00, got %v", hours)
+	}
+}
+
+func TestEnsureRecent(t *testing.T) {
+	// hourly, with the most recent occurrence 50 minutes ago
+	now := time.Now()
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": now.Add(-170 * time.Minute),
+		"frequency": FREQ_HOUR,
+	})
+
+	count := 0
+	f := func(args ...interface{}) {
+		count++
+	}
+
+	s := New()
+	sa := s.Add(ts, f, WithEnsureRecent(time.Hour))
+	if count != 1 {
+		t.Errorf("Expected action to execute on add when occurrence was within the window, executed %d times", count)
+	}
+	s.Remove(sa)
+
+	count = 0
+	sa = s.Add(ts, f, WithEnsureRecent(30*time.Minute))
+	if count != 0 {
+		t.Errorf("Expected action not to execute on add when occurrence was outside the window, executed %d times", count)
+	}
+	s.Remove(sa)
+}
+
+func TestOnSkip(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	open := false
+	count := 0
+	var reasons []SkipReason
+	v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	}), func(args ...interface{}) {
+		count++
+	}, WithGate(func() bool {
+		return open
+	}), WithOnSkip(func(sa *ScheduledAction, reason SkipReason) {
+		reasons = append(reasons, reason)
+	}))
+
+	// gated off, the tick is skipped
+	v.Advance(time.Hour)
+	if count != 0 {
+		t.Errorf("Expected gated-off action not to execute, was executed %d times", count)
+	}
+	if len(reasons) != 1 || reasons[0] != SKIP_GATE {
+		t.Errorf("Expected one skip with the gate reason, got %v", reasons)
+	}
+
+	open = true
+	v.Advance(time.Hour)
+	if count != 1 || len(reasons) != 1 {
+		t.Errorf("Expected gated-on action to execute without a skip, executed %d times with skips %v", count, reasons)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	var lock sync.Mutex
+	calls := 0
+	var errs []error
+	v.AddErr(NewOneOff(start.Add(time.Hour)), func(args ...interface{}) error {
+		lock.Lock()
+		calls++
+		lock.Unlock()
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	}, WithRunTimeout(20*time.Millisecond), WithRetry(1, time.Minute), WithErrorHandler(func(sa *ScheduledAction, err error) {
+		errs = append(errs, err)
+	}))
+
+	v.AdvanceTo(start.Add(2 * time.Hour))
+
+	// the timed-out run is retried once, which also times out
+	lock.Lock()
+	defer lock.Unlock()
+	if calls != 2 {
+		t.Errorf("Expected timed-out action to be retried once, was called %d times", calls)
+	}
+	if len(errs) != 2 || errs[0] != ErrRunTimeout || errs[1] != ErrRunTimeout {
+		t.Errorf("Expected error handler to receive ErrRunTimeout twice, got %v", errs)
+	}
+	if v.Count() != 0 {
+		t.Errorf("Expected one-off to finish after the retries, schedule contains %d item(s)", v.Count())
+	}
+}
+
+func TestResetBackoffOnSuccess(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start.Add(-time.Second))
+
+	// fails the first four times, then recovers
+	var fired []time.Time
+	v.AddErr(NewBackoff(start, time.Minute, time.Hour), func(args ...interface{}) error {
+		fired = append(fired, v.Now())
+		if len(fired) <= 4 {
+			return errors.New("not ready")
+		}
+		return nil
+	}, WithResetBackoffOnSuccess())
+
+	v.AdvanceTo(start.Add(17 * time.Minute))
+
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, time.Minute, time.Minute}
+	if len(fired) != len(expected)+1 {
+		t.Fatalf("Expected %d executions, got %v", len(expected)+1, fired)
+	}
+	for i, e := range expected {
+		if gap := fired[i+1].Sub(fired[i]); gap != e {
+			t.Errorf("Expected interval %d to be %s, got %s", i, e, gap)
+		}
+	}
+}
+
+// A real-time clock that can be jumped forward, to simulate waking up late.
+type jumpClock struct {
+	lock   sync.Mutex
+	offset time.Duration
+}
+
+func (c *jumpClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+func (c *jumpClock) jump(d time.Duration) {
+	c.lock.Lock()
+	c.offset += d
+	c.lock.Unlock()
+}
+
+func TestMisfirePolicy(t *testing.T) {
+	for _, policy := range []MisfirePolicy{MISFIRE_FIRE_NOW, MISFIRE_SKIP, MISFIRE_RESCHEDULE} {
+		clock := &jumpClock{}
+		s := New(WithClock(clock))
+
+		// hourly, due shortly, and woken up 90 minutes late
+		start := clock.Now().Add(50 * time.Millisecond)
+		outcome := make(chan string, 1)
+		sa := s.Add(NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_HOUR,
+		}), func(args ...interface{}) {
+			outcome <- "run"
+		}, WithMisfirePolicy(policy, time.Minute), WithOnSkip(func(sa *ScheduledAction, reason SkipReason) {
+			outcome <- reason.String()
+		}))
+		clock.jump(90 * time.Minute)
+
+		// the late occurrence has been handled once the action moves on to the next one
+		grid := start.Truncate(time.Second).Add(2 * time.Hour)
+		if !waitFor(func() bool { next, _ := sa.NextExecution(); return next.Equal(grid) }) {
+			t.Fatalf("policy %d: expected the late occurrence to be handled", policy)
+		}
+		var got string
+		select {
+		case got = <-outcome:
+		default:
+		}
+
+		switch policy {
+		case MISFIRE_FIRE_NOW:
+			if got != "run" || !sa.LastScheduled().Equal(start) {
+				t.Errorf("Expected FireNow to execute the missed occurrence, got %q for %s", got, sa.LastScheduled())
+			}
+		case MISFIRE_SKIP:
+			if got != "misfire" || sa.RunCount() != 0 {
+				t.Errorf("Expected Skip to skip the missed occurrence, got %q after %d runs", got, sa.RunCount())
+			}
+		case MISFIRE_RESCHEDULE:
+			if got != "" || sa.RunCount() != 0 {
+				t.Errorf("Expected Reschedule not to execute or report the missed occurrence, got %q after %d runs", got, sa.RunCount())
+			}
+		}
+		s.Remove(sa)
+	}
+}
+
+func TestOncePerDay(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	count := 0
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"byhour":    9,
+	}), func(args ...interface{}) {
+		count++
+	}, WithOncePerDay())
+
+	// fired manually in the morning, the scheduled occurrence that day is skipped
+	v.Advance(8 * time.Hour)
+	sa.Fire()
+	v.Advance(4 * time.Hour)
+	if count != 1 {
+		t.Errorf("Expected one execution on the first day, got %d", count)
+	}
+
+	// the next day it executes as scheduled
+	v.Advance(24 * time.Hour)
+	if count != 2 {
+		t.Errorf("Expected the action to execute again the next day, got %d executions", count)
+	}
+}
+
+func TestMergeParams(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	n := 0
+	var received [][]interface{}
+	v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	}), func(args ...interface{}) {
+		received = append(received, args)
+	}, WithMergeParams([]interface{}{"db", 5}, func() []interface{} {
+		n++
+		return []interface{}{n}
+	}))
+
+	v.Advance(2 * time.Hour)
+
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 executions, got %d", len(received))
+	}
+	for i, args := range received {
+		if len(args) != 3 || args[0] != "db" || args[1] != 5 || args[2] != i+1 {
+			t.Errorf("Expected execution %d to receive [db 5 %d], got %v", i, i+1, args)
+		}
+	}
+}
+
+func TestRunIfOverdue(t *testing.T) {
+	s := New()
+	past := NewOneOff(time.Now().Add(-24 * time.Hour))
+
+	fired := make(chan bool, 2)
+	f := func(args ...interface{}) { fired <- true }
+
+	// without the option, an overdue one-off never executes
+	dropped := s.Add(past, f)
+	sa := s.Add(past, f, WithRunIfOverdue())
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected overdue one-off to execute immediately")
+	}
+	<-sa.Done()
+
+	select {
+	case <-fired:
+		t.Errorf("Expected overdue one-off without WithRunIfOverdue not to execute")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if sa.RunCount() != 1 || dropped.RunCount() != 0 {
+		t.Errorf("Expected run counts 1 and 0, got %d and %d", sa.RunCount(), dropped.RunCount())
+	}
+}
+
+func TestChangeTrigger(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	// the watched value at each minute
+	values := []string{"a", "a", "b", "b", "b", "a", "c"}
+	tick := 0
+	last := ""
+	changed := func() bool {
+		value := values[tick]
+		tick++
+		if value == last {
+			return false
+		}
+		last = value
+		return true
+	}
+
+	var fired []string
+	unchanged := 0
+	v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) {
+		fired = append(fired, last)
+	}, WithChangeTrigger(changed), WithOnSkip(func(sa *ScheduledAction, reason SkipReason) {
+		if reason == SKIP_UNCHANGED {
+			unchanged++
+		}
+	}))
+
+	v.Advance(time.Duration(len(values)) * time.Minute)
+
+	if !reflect.DeepEqual(fired, []string{"a", "b", "a", "c"}) {
+		t.Errorf("Expected executions on each change [a b a c], got %v", fired)
+	}
+	if unchanged != 3 {
+		t.Errorf("Expected 3 occurrences skipped as unchanged, got %d", unchanged)
+	}
+}
+
+func TestPhaseOffset(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	every5 := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+		"interval":  5,
+	})
+
+	fired := make(map[string][]time.Time)
+	f := func(args ...interface{}) {
+		name := args[0].(string)
+		fired[name] = append(fired[name], v.Now())
+	}
+	v.Add(every5, f, "a", WithPhaseOffset(17*time.Second))
+	v.Add(every5, f, "b", WithPhaseOffset(2*time.Minute+40*time.Second))
+
+	v.Advance(20 * time.Minute)
+
+	for name, offset := range map[string]time.Duration{"a": 17 * time.Second, "b": 2*time.Minute + 40*time.Second} {
+		times := fired[name]
+		if len(times) != 4 {
+			t.Fatalf("Expected %s to execute 4 times, got %v", name, times)
+		}
+		for i, at := range times {
+			if expected := start.Add(time.Duration(i)*5*time.Minute + offset); !at.Equal(expected) {
+				t.Errorf("Expected %s execution %d at %s, got %s", name, i, expected, at)
+			}
+		}
+	}
+}
+
+func TestSerialGroup(t *testing.T) {
+	s := New()
+	at := NewOneOff(time.Now().Add(100 * time.Millisecond))
+
+	var lock sync.Mutex
+	running := make(map[string]int)
+	overlapped := make(map[string]bool)
+	f := func(args ...interface{}) {
+		group := args[0].(string)
+		lock.Lock()
+		running[group]++
+		if running[group] > 1 {
+			overlapped[group] = true
+		}
+		lock.Unlock()
+		time.Sleep(200 * time.Millisecond)
+		lock.Lock()
+		running[group]--
+		lock.Unlock()
+	}
+
+	// the same time, in the same group and in different groups
+	a1 := s.Add(at, f, "same", WithSerialGroup("entity-1"))
+	a2 := s.Add(at, f, "same", WithSerialGroup("entity-1"))
+	b1 := s.Add(at, f, "different", WithSerialGroup("entity-2"))
+	b2 := s.Add(at, f, "different", WithSerialGroup("entity-3"))
+	for _, sa := range []*ScheduledAction{a1, a2, b1, b2} {
+		<-sa.Done()
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if overlapped["same"] {
+		t.Errorf("Expected actions in the same group not to overlap")
+	}
+	if !overlapped["different"] {
+		t.Errorf("Expected actions in different groups to run concurrently")
+	}
+}
+
+func TestFallback(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	attempts := 0
+	var fallbacks [][]interface{}
+	sa := v.AddErr(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) error {
+		attempts++
+		// a success resets the count of consecutive failures
+		if attempts == 2 {
+			return nil
+		}
+		return errors.New("unavailable")
+	}, "primary-db", WithFallback(3, func(args ...interface{}) {
+		fallbacks = append(fallbacks, args)
+	}))
+
+	v.Advance(10 * time.Minute)
+
+	if attempts != 5 {
+		t.Errorf("Expected the action to stop after 3 consecutive failures at attempt 5, made %d attempts", attempts)
+	}
+	if len(fallbacks) != 1 || len(fallbacks[0]) != 1 || fallbacks[0][0] != "primary-db" {
+		t.Errorf("Expected fallback to execute once with the action's params, got %v", fallbacks)
+	}
+	if sa.State() != STATE_DONE {
+		t.Errorf("Expected action to stop after the fallback, state %s", sa.State())
+	}
+}
+
+func TestReadinessGate(t *testing.T) {
+	s := New()
+
+	var lock sync.Mutex
+	up := false
+	ready := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return up
+	}
+	time.AfterFunc(300*time.Millisecond, func() {
+		lock.Lock()
+		up = true
+		lock.Unlock()
+	})
+
+	added := time.Now()
+	ran := make(chan time.Time, 1)
+	s.Add(NewOneOff(added.Add(50*time.Millisecond)), func(args ...interface{}) {
+		ran <- time.Now()
+	}, WithReadinessGate(ready, 2*time.Second))
+
+	select {
+	case at := <-ran:
+		if waited := at.Sub(added); waited < 300*time.Millisecond || waited > 1500*time.Millisecond {
+			t.Errorf("Expected action to run soon after becoming ready at 300ms, ran after %s", waited)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected action to run once ready")
+	}
+
+	// never ready, so the occurrence is skipped once the timeout passes
+	reasons := make(chan SkipReason, 1)
+	s.Add(NewOneOff(time.Now().Add(50*time.Millisecond)), func(args ...interface{}) {
+		t.Errorf("Expected action that never became ready not to run")
+	}, WithReadinessGate(func() bool { return false }, 200*time.Millisecond),
+		WithOnSkip(func(sa *ScheduledAction, reason SkipReason) { reasons <- reason }))
+	select {
+	case reason := <-reasons:
+		if reason != SKIP_NOT_READY {
+			t.Errorf("Expected SKIP_NOT_READY, got %s", reason)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected occurrence to be skipped after the timeout")
+	}
+}
+
+func TestMaxErrorRate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	attempts := 0
+	var reported []error
+	sa := v.AddErr(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) error {
+		attempts++
+		return errors.New("unavailable")
+	}, WithMaxErrorRate(3, 10*time.Minute), WithErrorHandler(func(sa *ScheduledAction, err error) {
+		reported = append(reported, err)
+	}))
+
+	v.Advance(10 * time.Minute)
+
+	if attempts != 4 || sa.State() != STATE_DISABLED {
+		t.Errorf("Expected action to disable itself after the 4th failure, made %d attempts, state %s", attempts, sa.State())
+	}
+	if len(reported) != 5 || reported[4] != ErrErrorRateExceeded {
+		t.Errorf("Expected 4 failures followed by ErrErrorRateExceeded, got %v", reported)
+	}
+
+	// failures spread out over more than the window don't disable it
+	slow := 0
+	sa = v.AddErr(NewRecurring(map[string]interface{}{
+		"starttime": v.Now(),
+		"frequency": FREQ_HOUR,
+	}), func(args ...interface{}) error {
+		slow++
+		return errors.New("unavailable")
+	}, WithMaxErrorRate(3, 10*time.Minute))
+	v.Advance(10 * time.Hour)
+	if slow != 10 || sa.State() != STATE_ACTIVE {
+		t.Errorf("Expected infrequent failures not to disable the action, made %d attempts, state %s", slow, sa.State())
+	}
+}
+
+func TestInclusiveStart(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(now)
+	start := now.Add(time.Hour)
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+	f := func(args ...interface{}) {}
+
+	inclusive := v.Add(hourly, f)
+	explicit := v.Add(hourly, f, WithInclusiveStart(true))
+	exclusive := v.Add(hourly, f, WithInclusiveStart(false))
+
+	for name, test := range map[string]struct {
+		sa       *ScheduledAction
+		expected time.Time
+	}{
+		"default":   {inclusive, start},
+		"inclusive": {explicit, start},
+		"exclusive": {exclusive, start.Add(time.Hour)},
+	} {
+		if next, _ := test.sa.NextExecution(); !next.Equal(test.expected) {
+			t.Errorf("%s: expected first execution at %s, got %s", name, test.expected, next)
+		}
+	}
+
+	// after the start, both follow the same cadence
+	v.Advance(3 * time.Hour)
+	if inclusive.RunCount() != 3 || exclusive.RunCount() != 2 {
+		t.Errorf("Expected 3 inclusive and 2 exclusive executions, got %d and %d", inclusive.RunCount(), exclusive.RunCount())
+	}
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// each execution takes work, in virtual time
+	run := func(interval int, work time.Duration, runs int) ([]time.Duration, time.Duration) {
+		v := NewVirtualScheduler(start)
+		var fired []time.Time
+		sa := v.Add(NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_MINUTE,
+			"interval":  interval,
+		}), func(args ...interface{}) {
+			fired = append(fired, v.Now())
+			v.clock.set(v.Now().Add(work))
+		}, WithAdaptiveInterval(time.Minute, 8*time.Minute))
+		for len(fired) < runs {
+			v.Advance(time.Minute)
+		}
+		var spacing []time.Duration
+		for i := 1; i < len(fired); i++ {
+			spacing = append(spacing, fired[i].Sub(fired[i-1]))
+		}
+		current, _ := sa.CurrentInterval()
+		return spacing, current
+	}
+
+	spacing, current := run(1, 5*time.Minute, 12)
+	if spacing[0] != time.Minute || spacing[len(spacing)-1] != 8*time.Minute || current != 8*time.Minute {
+		t.Errorf("Expected a slow action's interval to grow from 1m up to 8m, got spacing %v and interval %s", spacing, current)
+	}
+
+	spacing, current = run(8, 0, 12)
+	if spacing[0] != 8*time.Minute || spacing[len(spacing)-1] != time.Minute || current != time.Minute {
+		t.Errorf("Expected a fast action's interval to shrink from 8m down to 1m, got spacing %v and interval %s", spacing, current)
+	}
+}
+
+func TestStartupCatchUp(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start.Add(-4 * time.Hour),
+		"frequency": FREQ_HOUR,
+	})
+
+	// the occurrences from 06:00 to 10:00 were missed before the action was added
+	v := NewVirtualScheduler(start)
+	var fired []time.Time
+	v.Add(hourly, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	}, WithStartupCatchUp(2))
+	v.Advance(2 * time.Hour)
+
+	expected := []time.Time{start, start, start.Add(time.Hour), start.Add(2 * time.Hour)}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected 2 make-up executions on startup, then the schedule, got %v", fired)
+	}
+
+	// with external ticks, ticks that stop for a while model a stall in operation
+	s := New(WithExternalTicks())
+	s.Tick(start)
+	var ticked []time.Time
+	s.Add(hourly, func(args ...interface{}) {
+		ticked = append(ticked, s.now())
+	}, WithStartupCatchUp(1))
+	s.Tick(start)
+	s.Tick(start.Add(time.Hour))
+	s.Tick(start.Add(3*time.Hour + 30*time.Minute))
+	s.Tick(start.Add(4 * time.Hour))
+
+	expected = []time.Time{start, start.Add(time.Hour), start.Add(3*time.Hour + 30*time.Minute), start.Add(4 * time.Hour)}
+	if !reflect.DeepEqual(ticked, expected) {
+		t.Errorf("Expected no make-up executions after a stall, got %v", ticked)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	v := NewVirtualScheduler(time.Now())
+	var errs []error
+	v.Add(NewRecurring(map[string]interface{}{
+		"starttime": v.Now().Add(time.Minute),
+		"frequency": FREQ_MINUTE,
+		"maxnum":    2,
+	}), func(args ...interface{}) {
+		panic("out of widgets")
+	}, WithPanicRecovery(), WithErrorHandler(func(sa *ScheduledAction, err error) {
+		errs = append(errs, err)
+	}))
+	v.Advance(5 * time.Minute)
+
+	if len(errs) != 2 {
+		t.Fatalf("Expected the error handler to be called for each panic, called %d times", len(errs))
+	}
+	if !errors.Is(errs[0], ErrPanic) {
+		t.Errorf("Expected an ErrPanic, got %v", errs[0])
+	}
+	var p *PanicError
+	if !errors.As(errs[0], &p) || p.Value != "out of widgets" || len(p.Stack) == 0 {
+		t.Errorf("Expected a PanicError with the panic value and stack, got %#v", errs[0])
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	delays := func(seed int64) []time.Duration {
+		v := NewVirtualScheduler(start, WithRandSeed(seed))
+		var runs []time.Time
+		v.AddErr(NewOneOff(start.Add(time.Minute)), func(args ...interface{}) error {
+			runs = append(runs, v.Now())
+			return errors.New("unavailable")
+		}, WithRetry(5, 10*time.Second), WithRetryBackoff(time.Minute), WithRetryJitter(0.2))
+		v.Advance(time.Hour)
+
+		var delays []time.Duration
+		for i := 1; i < len(runs); i++ {
+			delays = append(delays, runs[i].Sub(runs[i-1]))
+		}
+		return delays
+	}
+
+	got := delays(1)
+	nominal := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	if len(got) != len(nominal) {
+		t.Fatalf("Expected %d retries, got %d", len(nominal), len(got))
+	}
+	jittered := false
+	for i, d := range nominal {
+		min, max := time.Duration(float64(d)*0.8), time.Duration(float64(d)*1.2)
+		if got[i] < min || got[i] > max {
+			t.Errorf("Expected retry %d after between %s and %s, got %s", i+1, min, max, got[i])
+		}
+		if got[i] != d {
+			jittered = true
+		}
+	}
+	if !jittered {
+		t.Errorf("Expected retry delays to be jittered, got %v", got)
+	}
+
+	if again := delays(1); !reflect.DeepEqual(again, got) {
+		t.Errorf("Expected the same seed to give the same delays, got %v and %v", got, again)
+	}
+	if other := delays(2); reflect.DeepEqual(other, got) {
+		t.Errorf("Expected a different seed to give different delays, got %v for both", got)
+	}
+}
+
+func TestIdempotencyTokens(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	var tokens []string
+	v.AddErr(NewRecurring(map[string]interface{}{
+		"starttime": start.Add(time.Hour),
+		"frequency": FREQ_HOUR,
+	}), func(args ...interface{}) error {
+		tokens = append(tokens, args[len(args)-1].(string))
+		if len(tokens) == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, "queue", WithName("enqueue"), WithIdempotencyTokens(), WithRetry(1, time.Minute))
+	v.Advance(150 * time.Minute)
+
+	expected := []string{"enqueue@2024-01-01T01:00:00Z", "enqueue@2024-01-01T01:00:00Z", "enqueue@2024-01-01T02:00:00Z"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Expected tokens %v, with the retry reusing the first, got %v", expected, tokens)
+	}
+
+	var ctxTokens []string
+	v.AddCtx(NewOneOff(v.Now().Add(time.Minute)), func(ctx context.Context, args ...interface{}) {
+		if token, ok := IdempotencyToken(ctx); ok && len(args) == 0 {
+			ctxTokens = append(ctxTokens, token)
+		}
+	}, WithName("export"), WithIdempotencyTokens())
+	v.Advance(time.Hour)
+	if len(ctxTokens) != 1 || ctxTokens[0] != "export@2024-01-01T02:31:00Z" {
+		t.Errorf("Expected the token in the context only, got %v", ctxTokens)
+	}
+}
+
+func TestLatenessAlert(t *testing.T) {
+	// serial execution lets one slow action starve another
+	s := New(WithSerialExecution())
+	var lock sync.Mutex
+	var alerts []time.Time
+	alert := func(sa *ScheduledAction, scheduled time.Time) {
+		lock.Lock()
+		alerts = append(alerts, scheduled)
+		lock.Unlock()
+	}
+
+	now := time.Now()
+	blocker := s.Add(NewOneOff(now.Add(50*time.Millisecond)), func(args ...interface{}) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	due := now.Add(100 * time.Millisecond)
+	starved := s.Add(NewOneOff(due), func(args ...interface{}) {},
+		WithLatenessAlert(100*time.Millisecond, alert))
+	punctual := s.Add(NewOneOff(now.Add(20*time.Millisecond)), func(args ...interface{}) {},
+		WithLatenessAlert(100*time.Millisecond, alert))
+
+	for _, sa := range []*ScheduledAction{blocker, starved, punctual} {
+		<-sa.Done()
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(alerts) != 1 || !alerts[0].Equal(due) {
+		t.Errorf("Expected one lateness alert for the starved occurrence at %s, got %v", due, alerts)
+	}
+}
+
+func TestDeadlineUntilNext(t *testing.T) {
+	deadlines := make(chan time.Duration, 2)
+	every := func() *TimeSpec {
+		return NewRecurring(map[string]interface{}{
+			"starttime": time.Now().Add(100 * time.Millisecond),
+			"frequency": FREQ_MINUTE,
+		})
+	}
+	record := func(ctx context.Context, args ...interface{}) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadlines <- 0
+			return
+		}
+		deadlines <- time.Until(deadline)
+	}
+
+	// the next occurrence is a minute away, less the start time's fraction of a second, unless the
+	// run timeout is sooner
+	s := New()
+	defer s.Remove(s.AddCtx(every(), record, WithDeadlineUntilNext()))
+	defer s.Remove(s.AddCtx(every(), record, WithDeadlineUntilNext(), WithRunTimeout(5*time.Second)))
+
+	got := []time.Duration{<-deadlines, <-deadlines}
+	sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+	for i, expected := range []time.Duration{5 * time.Second, time.Minute} {
+		if got[i] < expected-1200*time.Millisecond || got[i] > expected {
+			t.Errorf("Expected a deadline about %s away, got %s", expected, got[i])
+		}
+	}
+}
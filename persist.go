@@ -0,0 +1,413 @@
+package gochronos
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions that can be restored when a saved schedule is loaded, by name.
+var registry = make(map[string]ActionFunc)
+
+// This is used to synchronise access to the registry.
+var registryLock sync.Mutex
+
+// Register an action under a name. Functions can't be serialised, so scheduled actions can only be
+// saved if their action is registered, and created with WithRegisteredAction. When a schedule is
+// loaded, the action is looked up by the same name.
+func RegisterAction(name string, f ActionFunc) {
+	registryLock.Lock()
+	registry[name] = f
+	registryLock.Unlock()
+}
+
+// Look up a registered action by name.
+func registeredAction(name string) (ActionFunc, bool) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	f, ok := registry[name]
+	return f, ok
+}
+
+// Use the action registered under name, so that the scheduled action can be saved and loaded.
+// This replaces any action function passed when creating the scheduled action.
+func WithRegisteredAction(name string) Option {
+	return func(sa *ScheduledAction) {
+		sa.actionName = name
+		if f, ok := registeredAction(name); ok {
+			sa.Action = f
+		}
+	}
+}
+
+// The saved form of a scheduled action.
+type savedAction struct {
+	Action   string        `json:"action"`
+	When     *TimeSpec     `json:"when"`
+	Params   []interface{} `json:"params,omitempty"`
+	RunCount int           `json:"runcount,omitempty"`
+}
+
+// A format in which a schedule can be saved.
+type SaveFormat int
+
+const (
+	// Human-readable JSON. Parameters are restored as JSON values, so numbers become float64.
+	FORMAT_JSON SaveFormat = iota
+
+	// Compact gob encoding, for Go-to-Go persistence. Parameters keep their types, but the types
+	// of any parameters that aren't basic types must be registered with RegisterType.
+	FORMAT_GOB
+)
+
+var formatNames = []string{"json", "gob"}
+
+func (f SaveFormat) String() string {
+	return formatNames[f]
+}
+
+// Register the type of value, so that parameters of that type can be saved and loaded with
+// FORMAT_GOB.
+func RegisterType(value interface{}) {
+	gob.Register(value)
+}
+
+// When a schedule is first loaded into the scheduler, execute the one-offs that are already due,
+// e.g. because they came due while the process was down, once each in the order they were due,
+// before the rest of the schedule is added. They would otherwise be discarded as past. Later loads
+// are unaffected.
+func WithStartupFlush() SchedulerOption {
+	return func(s *Scheduler) {
+		s.startupFlush = true
+	}
+}
+
+// Save the default schedule.
+func Save(w io.Writer) error {
+	return defaultScheduler.Save(w)
+}
+
+// Load scheduled actions into the default schedule.
+func Load(r io.Reader) error {
+	return defaultScheduler.Load(r)
+}
+
+// Save the default schedule in the given format.
+func SaveWith(w io.Writer, format SaveFormat) error {
+	return defaultScheduler.SaveWith(w, format)
+}
+
+// Load scheduled actions saved in the given format into the default schedule.
+func LoadWith(r io.Reader, format SaveFormat) error {
+	return defaultScheduler.LoadWith(r, format)
+}
+
+// Save the schedule as JSON, so it can be restored with Load. Each action must have been created
+// with WithRegisteredAction. The number of times each action has executed is saved, so an action
+// limited by maxnum only executes its remaining number of times once restored. Parameters are
+// saved as JSON, so on loading, numbers are restored as float64.
+func (s *Scheduler) Save(w io.Writer) error {
+	return s.SaveWith(w, FORMAT_JSON)
+}
+
+// Save the schedule in the given format, so it can be restored with LoadWith and the same format.
+// This is otherwise the same as Save.
+func (s *Scheduler) SaveWith(w io.Writer, format SaveFormat) error {
+	// save in the order the actions were added
+	actions := s.ordered()
+	saved := make([]savedAction, 0, len(actions))
+	for _, sa := range actions {
+		if sa.actionName == "" {
+			return fmt.Errorf("gochronos: can't save scheduled action without a registered action")
+		}
+		saved = append(saved, savedAction{
+			Action:   sa.actionName,
+			When:     sa.spec(),
+			Params:   sa.Parameters,
+			RunCount: sa.RunCount(),
+		})
+	}
+
+	switch format {
+	case FORMAT_JSON:
+		return json.NewEncoder(w).Encode(saved)
+	case FORMAT_GOB:
+		return gob.NewEncoder(w).Encode(saved)
+	}
+	return fmt.Errorf("gochronos: unknown save format %d", format)
+}
+
+// Load scheduled actions saved by Save, adding them to the schedule. If any action isn't
+// registered, or its time spec is missing or invalid, an error is returned and nothing is added. If the schedule becomes full,
+// ErrScheduleFull is returned and the remaining actions are not added.
+func (s *Scheduler) Load(r io.Reader) error {
+	return s.LoadWith(r, FORMAT_JSON)
+}
+
+// Load scheduled actions saved by SaveWith in the given format. This is otherwise the same as
+// Load.
+func (s *Scheduler) LoadWith(r io.Reader, format SaveFormat) error {
+	var saved []savedAction
+	var err error
+	switch format {
+	case FORMAT_JSON:
+		err = json.NewDecoder(r).Decode(&saved)
+	case FORMAT_GOB:
+		err = gob.NewDecoder(r).Decode(&saved)
+	default:
+		err = fmt.Errorf("gochronos: unknown save format %d", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	actions := make([]*ScheduledAction, 0, len(saved))
+	for _, a := range saved {
+		if _, ok := registeredAction(a.Action); !ok {
+			return fmt.Errorf("gochronos: action %q is not registered", a.Action)
+		}
+		if err := a.When.Validate(); err != nil {
+			return fmt.Errorf("gochronos: saved action %q has an invalid time spec: %w", a.Action, err)
+		}
+		sa := NewScheduledAction(a.When, nil, a.Params)
+		WithRegisteredAction(a.Action)(sa)
+		sa.runCount = a.RunCount
+		actions = append(actions, sa)
+	}
+
+	for _, sa := range s.flush(actions) {
+		if err := s.addToSchedule(sa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// If the scheduler flushes on startup and hasn't yet, execute the one-offs among the loaded
+// actions that are already due, in the order they were due, returning the actions that remain to
+// be added.
+func (s *Scheduler) flush(actions []*ScheduledAction) []*ScheduledAction {
+	s.lock.Lock()
+	flush := s.startupFlush && !s.flushed
+	s.flushed = true
+	s.lock.Unlock()
+	if !flush {
+		return actions
+	}
+
+	now := s.now()
+	var due, remaining []*ScheduledAction
+	for _, sa := range actions {
+		if ts := sa.When; !ts.recurring && ts.times == nil && !ts.when.After(now) && sa.RunCount() == 0 {
+			due = append(due, sa)
+		} else {
+			remaining = append(remaining, sa)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].When.when.Before(due[j].When.when) })
+
+	for _, sa := range due {
+		sa.scheduler = s
+		sa.setState(STATE_ACTIVE)
+		sa.run(sa.When.when)
+		sa.finish()
+	}
+	return remaining
+}
+
+// The saved form of a time specification.
+type timeSpecJSON struct {
+	Recurring bool        `json:"recurring"`
+	When      *time.Time  `json:"when,omitempty"`
+	Times     []time.Time `json:"times,omitempty"`
+	StartTime *time.Time  `json:"starttime,omitempty"`
+	EndTime   *time.Time  `json:"endtime,omitempty"`
+	Frequency int         `json:"frequency,omitempty"`
+	Interval  int         `json:"interval,omitempty"`
+	ByDay     []string    `json:"byday,omitempty"`
+	ByHour    []int       `json:"byhour,omitempty"`
+	ByMinute  []int       `json:"byminute,omitempty"`
+	MaxNum    int         `json:"maxnum,omitempty"`
+
+	ByMonthDay []int `json:"bymonthday,omitempty"`
+
+	AlignToDay bool          `json:"aligntoday,omitempty"`
+	FixedDelay bool          `json:"fixeddelay,omitempty"`
+	Delay      time.Duration `json:"delay,omitempty"`
+
+	Backoff     bool          `json:"backoff,omitempty"`
+	BackoffBase time.Duration `json:"backoffbase,omitempty"`
+	BackoffMax  time.Duration `json:"backoffmax,omitempty"`
+
+	Every time.Duration `json:"every,omitempty"`
+
+	BusinessHours *businessHoursJSON `json:"businesshours,omitempty"`
+
+	Weekly map[string][]string `json:"weekly,omitempty"`
+
+	ISOWeeks string `json:"isoweeks,omitempty"`
+
+	Seasons []string `json:"seasons,omitempty"`
+}
+
+// The saved form of business hours.
+type businessHoursJSON struct {
+	Location string        `json:"location"`
+	Open     string        `json:"open"`
+	Close    string        `json:"close"`
+	Every    time.Duration `json:"every"`
+}
+
+// Returns nil for the zero time, so it is omitted.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// Returns the zero time for nil.
+func requiredTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// Marshal the time specification as JSON.
+func (t *TimeSpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.saved())
+}
+
+// The saved form of the time specification.
+func (t *TimeSpec) saved() timeSpecJSON {
+	j := timeSpecJSON{
+		Recurring: t.recurring,
+		When:      optionalTime(t.when),
+		Times:     t.times,
+		StartTime: optionalTime(t.startTime),
+		EndTime:   optionalTime(t.endTime),
+		Frequency: t.frequency,
+		Interval:  t.interval,
+		ByHour:    t.byHour,
+		ByMinute:  t.byMinute,
+		MaxNum:    t.maxNum,
+
+		ByMonthDay: t.byMonthDay,
+
+		AlignToDay: t.alignToDay,
+		FixedDelay: t.fixedDelay,
+		Delay:      t.delay,
+
+		Backoff:     t.backoff,
+		BackoffBase: t.backoffBase,
+		BackoffMax:  t.backoffMax,
+
+		Every: t.every,
+	}
+	if b := t.business; b != nil {
+		j.BusinessHours = &businessHoursJSON{Location: b.loc.String(), Open: clock(b.open), Close: clock(b.close), Every: b.every}
+	}
+	if t.weekly != nil {
+		j.Weekly = t.weekly.clocks()
+	}
+	if t.isoWeeks {
+		j.ISOWeeks = isoParityName(t.isoOdd)
+	}
+	for _, d := range t.byDay {
+		j.ByDay = append(j.ByDay, dayCodes[d])
+	}
+	for _, season := range t.seasons {
+		j.Seasons = append(j.Seasons, monthDay(season.from)+"/"+monthDay(season.to))
+	}
+	return j
+}
+
+// Unmarshal a time specification from JSON.
+func (t *TimeSpec) UnmarshalJSON(data []byte) error {
+	var j timeSpecJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	return t.restore(j)
+}
+
+// Restore the time specification from its saved form.
+func (t *TimeSpec) restore(j timeSpecJSON) error {
+	*t = TimeSpec{
+		recurring: j.Recurring,
+		when:      requiredTime(j.When),
+		times:     j.Times,
+		startTime: requiredTime(j.StartTime),
+		endTime:   requiredTime(j.EndTime),
+		frequency: j.Frequency,
+		interval:  j.Interval,
+		byHour:    j.ByHour,
+		byMinute:  j.ByMinute,
+		maxNum:    j.MaxNum,
+
+		byMonthDay: j.ByMonthDay,
+
+		alignToDay: j.AlignToDay,
+		fixedDelay: j.FixedDelay,
+		delay:      j.Delay,
+
+		backoff:     j.Backoff,
+		backoffBase: j.BackoffBase,
+		backoffMax:  j.BackoffMax,
+
+		every: j.Every,
+	}
+	if b := j.BusinessHours; b != nil {
+		loc, err := time.LoadLocation(b.Location)
+		if err != nil {
+			return err
+		}
+		business := &businessHours{loc: loc, every: b.Every}
+		if business.open, err = parseClock(b.Open); err != nil {
+			return err
+		}
+		if business.close, err = parseClock(b.Close); err != nil {
+			return err
+		}
+		t.business = business
+	}
+	if j.Weekly != nil {
+		var err error
+		if t.weekly, err = weeklyFrom(j.Weekly); err != nil {
+			return err
+		}
+	}
+	switch j.ISOWeeks {
+	case "":
+	case "odd", "even":
+		t.isoWeeks = true
+		t.isoOdd = j.ISOWeeks == "odd"
+	default:
+		return fmt.Errorf("gochronos: ISO week parity must be odd or even, got %q", j.ISOWeeks)
+	}
+	if len(j.ByDay) > 0 {
+		var err error
+		if t.byDay, err = dayList(j.ByDay); err != nil {
+			return err
+		}
+	}
+	for _, days := range j.Seasons {
+		from, to, _ := strings.Cut(days, "/")
+		var season season
+		var err error
+		if season.from, err = parseMonthDay(from); err != nil {
+			return err
+		}
+		if season.to, err = parseMonthDay(to); err != nil {
+			return err
+		}
+		t.seasons = append(t.seasons, season)
+	}
+	return nil
+}
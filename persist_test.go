@@ -0,0 +1,220 @@
+package gochronos
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadRunCount(t *testing.T) {
+	count := 0
+	RegisterAction("test.count", func(args ...interface{}) {
+		count++
+	})
+
+	// every second, for at most 4 executions
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+		"maxnum":    4,
+	})
+
+	v := NewVirtualScheduler(start)
+	sa := v.Add(ts, nil, WithRegisteredAction("test.count"))
+	for sa.RunCount() < 2 {
+		v.Advance(time.Second)
+	}
+
+	var buf bytes.Buffer
+	if err := v.Save(&buf); err != nil {
+		t.Fatalf("Unexpected error saving schedule: %s", err)
+	}
+	v.Remove(sa)
+
+	// restore into a new scheduler, which should only run the remaining 2 executions
+	restored := NewVirtualScheduler(v.Now())
+	if err := restored.Load(&buf); err != nil {
+		t.Fatalf("Unexpected error loading schedule: %s", err)
+	}
+	if restored.Count() != 1 {
+		t.Fatalf("Expected 1 action to be restored, got %d", restored.Count())
+	}
+
+	restored.Advance(10 * time.Second)
+
+	if count != 4 {
+		t.Errorf("Expected action to execute 4 times in total across save and load, executed %d times", count)
+	}
+	if restored.Count() != 0 {
+		t.Errorf("Expected restored action to be removed once it reached maxnum")
+	}
+}
+
+func TestSaveUnregistered(t *testing.T) {
+	s := New()
+	sa := s.Add(NewOneOff(time.Now().Add(time.Hour)), func(args ...interface{}) {})
+
+	var buf bytes.Buffer
+	if err := s.Save(&buf); err == nil {
+		t.Errorf("Expected error saving an action that isn't registered")
+	}
+	s.Remove(sa)
+}
+
+func TestLoadInvalidSpec(t *testing.T) {
+	RegisterAction("test.invalid", func(args ...interface{}) {})
+	s := New(WithStartupFlush())
+	for _, saved := range []string{
+		`[{"action": "test.invalid", "when": null}]`,
+		`[{"action": "test.invalid", "when": {"recurring": true, "starttime": "2024-01-01T00:00:00Z", "frequency": 3, "byminute": [5], "maxnum": -1}}]`,
+	} {
+		if err := s.Load(strings.NewReader(saved)); err == nil {
+			t.Errorf("Expected error loading %s", saved)
+		}
+	}
+	if s.Count() != 0 {
+		t.Errorf("Expected no actions to be added, got %d", s.Count())
+	}
+}
+
+func TestTimeSpecJSON(t *testing.T) {
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+		"endtime":   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		"frequency": FREQ_WEEK,
+		"interval":  2,
+		"byday":     []string{"mo", "fr"},
+		"byhour":    []int{9, 17},
+		"maxnum":    10,
+	})
+
+	data, err := ts.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling: %s", err)
+	}
+	var restored TimeSpec
+	if err := restored.UnmarshalJSON(data); err != nil {
+		t.Fatalf("Unexpected error unmarshalling: %s", err)
+	}
+
+	now := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		a, b := ts.NextAfter(now), restored.NextAfter(now)
+		if !a.Equal(b) {
+			t.Fatalf("Expected restored spec to produce %s, got %s", a, b)
+		}
+		now = a
+	}
+}
+
+type savedPoint struct {
+	X, Y int
+}
+
+func TestSaveFormats(t *testing.T) {
+	RegisterAction("test.format", func(args ...interface{}) {})
+	RegisterType(savedPoint{})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, format := range []SaveFormat{FORMAT_JSON, FORMAT_GOB} {
+		v := NewVirtualScheduler(start)
+		v.Add(NewOneOff(start.Add(time.Hour)), nil, "once", WithRegisteredAction("test.format"))
+		v.Add(NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_DAY,
+			"byhour":    []int{9, 17},
+		}), nil, "daily", WithRegisteredAction("test.format"))
+		if format == FORMAT_GOB {
+			v.Add(NewTimes(start.Add(2*time.Hour), start.Add(3*time.Hour)), nil, savedPoint{1, 2}, 3,
+				WithRegisteredAction("test.format"))
+		}
+
+		var buf bytes.Buffer
+		if err := v.SaveWith(&buf, format); err != nil {
+			t.Fatalf("%s: unexpected error saving schedule: %s", format, err)
+		}
+		restored := NewVirtualScheduler(start)
+		if err := restored.LoadWith(&buf, format); err != nil {
+			t.Fatalf("%s: unexpected error loading schedule: %s", format, err)
+		}
+
+		original, loaded := inOrder(v.Scheduler), inOrder(restored.Scheduler)
+		if len(original) != len(loaded) {
+			t.Fatalf("%s: expected %d actions to be restored, got %d", format, len(original), len(loaded))
+		}
+		for i := range original {
+			a, b := original[i], loaded[i]
+			if !reflect.DeepEqual(a.Parameters, b.Parameters) {
+				t.Errorf("%s: expected parameters %v, got %v", format, a.Parameters, b.Parameters)
+			}
+			an, _ := a.NextExecution()
+			bn, _ := b.NextExecution()
+			if !an.Equal(bn) {
+				t.Errorf("%s: expected next execution %s, got %s", format, an, bn)
+			}
+			from := start.AddDate(0, 0, 1)
+			if a, b := a.When.NextAfter(from), b.When.NextAfter(from); !a.Equal(b) {
+				t.Errorf("%s: expected restored spec to produce %s, got %s", format, a, b)
+			}
+		}
+	}
+}
+
+// The actions of the schedule, in the order they were added.
+func inOrder(s *Scheduler) []*ScheduledAction {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var actions []*ScheduledAction
+	for sa := range s.actions {
+		actions = append(actions, sa)
+	}
+	sort.Slice(actions, func(i, j int) bool { return actions[i].seq < actions[j].seq })
+	return actions
+}
+
+func TestStartupFlush(t *testing.T) {
+	var fired []string
+	RegisterAction("test.flush", func(args ...interface{}) {
+		fired = append(fired, args[0].(string))
+	})
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	for _, due := range []struct {
+		name string
+		at   time.Duration
+	}{{"b", 2 * time.Hour}, {"c", 3 * time.Hour}, {"a", time.Hour}, {"later", 10 * time.Hour}} {
+		v.Add(NewOneOff(start.Add(due.at)), nil, due.name, WithRegisteredAction("test.flush"))
+	}
+	var buf bytes.Buffer
+	if err := v.Save(&buf); err != nil {
+		t.Fatalf("Unexpected error saving: %s", err)
+	}
+	saved := buf.String()
+
+	// the process was down while a, b and c came due
+	restored := NewVirtualScheduler(start.Add(5*time.Hour), WithStartupFlush())
+	if err := restored.Load(strings.NewReader(saved)); err != nil {
+		t.Fatalf("Unexpected error loading: %s", err)
+	}
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected due one-offs to execute in order %v on loading, got %v", expected, fired)
+	}
+	if restored.Count() != 1 {
+		t.Errorf("Expected only the future one-off to remain scheduled, got %d actions", restored.Count())
+	}
+
+	// only the first load is flushed
+	fired = nil
+	if err := restored.Load(strings.NewReader(saved)); err != nil {
+		t.Fatalf("Unexpected error loading: %s", err)
+	}
+	restored.Advance(10 * time.Hour)
+	if expected := []string{"later", "later"}; !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected past one-offs not to execute on a later load, got %v", fired)
+	}
+}
@@ -0,0 +1,102 @@
+package gochronos
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RunEvent records an execution of an action.
+type RunEvent struct {
+	// The name of the action, as set by WithName.
+	Action string
+
+	// When the execution was scheduled for, and when it actually started.
+	Scheduled time.Time
+	Actual    time.Time
+
+	// The error the execution failed with, or nil.
+	Err error
+}
+
+// A Recorder captures the executions of a scheduler's actions, in the order they complete, so
+// that they can be examined or replayed with Replay, e.g. to reproduce a problem seen in
+// production against a virtual scheduler. It is safe for concurrent use.
+type Recorder struct {
+	lock   sync.Mutex
+	events []RunEvent
+}
+
+// Create a new, empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// The events recorded so far, in order.
+func (r *Recorder) Events() []RunEvent {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]RunEvent(nil), r.events...)
+}
+
+func (r *Recorder) record(e RunEvent) {
+	r.lock.Lock()
+	r.events = append(r.events, e)
+	r.lock.Unlock()
+}
+
+// Record the executions of the default schedule's actions with r.
+func SetRecorder(r *Recorder) {
+	defaultScheduler.SetRecorder(r)
+}
+
+// Record the executions of the scheduler's actions with r. nil, the default, stops recording.
+func (s *Scheduler) SetRecorder(r *Recorder) {
+	s.lock.Lock()
+	s.recorder = r
+	s.lock.Unlock()
+}
+
+// Record an execution, if the scheduler has a recorder.
+func (s *Scheduler) record(sa *ScheduledAction, scheduled, actual time.Time, err error) {
+	s.lock.Lock()
+	r := s.recorder
+	s.lock.Unlock()
+	if r != nil {
+		r.record(RunEvent{Action: sa.Name(), Scheduled: scheduled, Actual: actual, Err: err})
+	}
+}
+
+// Replay the executions captured by a recorder against a virtual scheduler. Each event executes
+// the action in the scheduler with the same name, with the virtual time set to when the event
+// actually started, and for the time it was scheduled, so the recorded sequence is reproduced
+// exactly, however late each execution was. The actions' own schedules aren't consulted, and
+// their functions decide the outcome of each execution afresh. Actions are identified by name, so
+// each action that executed must have a name, set by WithName, that no other action in the
+// scheduler has. Returns an error, before anything executes, if an event's action is unnamed,
+// isn't in the scheduler, or isn't the only action in it with its name.
+func Replay(r *Recorder, v *VirtualScheduler) error {
+	events := r.Events()
+	named := make(map[string][]*ScheduledAction)
+	for _, sa := range v.ordered() {
+		named[sa.Name()] = append(named[sa.Name()], sa)
+	}
+	for _, e := range events {
+		switch {
+		case e.Action == "":
+			return fmt.Errorf("gochronos: recorded action has no name, so it can't be replayed")
+		case len(named[e.Action]) == 0:
+			return fmt.Errorf("gochronos: recorded action %q is not in the scheduler", e.Action)
+		case len(named[e.Action]) > 1:
+			return fmt.Errorf("gochronos: recorded action %q is not the only action with its name", e.Action)
+		}
+	}
+
+	for _, e := range events {
+		if e.Actual.After(v.Now()) {
+			v.clock.set(e.Actual)
+		}
+		named[e.Action][0].run(e.Scheduled)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package gochronos
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	failure := errors.New("report failed")
+	addActions := func(s *Scheduler) {
+		s.Add(NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_MINUTE,
+		}), func(args ...interface{}) {}, WithName("poll"))
+		reports := 0
+		s.AddErr(NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_MINUTE,
+			"interval":  2,
+		}), func(args ...interface{}) error {
+			if reports++; reports%2 == 0 {
+				return failure
+			}
+			return nil
+		}, WithName("report"))
+	}
+
+	// record a run driven by irregular ticks, so executions are late
+	s := New(WithExternalTicks())
+	s.Tick(start)
+	recorder := NewRecorder()
+	s.SetRecorder(recorder)
+	addActions(s)
+	for _, d := range []time.Duration{70 * time.Second, 2 * time.Minute, 210 * time.Second, 5 * time.Minute} {
+		s.Tick(start.Add(d))
+	}
+	recorded := recorder.Events()
+	if len(recorded) == 0 {
+		t.Fatalf("Expected executions to be recorded")
+	}
+
+	v := NewVirtualScheduler(start)
+	replayed := NewRecorder()
+	v.SetRecorder(replayed)
+	addActions(v.Scheduler)
+	if err := Replay(recorder, v); err != nil {
+		t.Fatalf("Unexpected error replaying: %s", err)
+	}
+	if !reflect.DeepEqual(replayed.Events(), recorded) {
+		t.Errorf("Expected the replay to reproduce %v, got %v", recorded, replayed.Events())
+	}
+
+	if err := Replay(recorder, NewVirtualScheduler(start)); err == nil {
+		t.Errorf("Expected an error replaying against a scheduler without the actions")
+	}
+	duplicated := NewVirtualScheduler(start)
+	addActions(duplicated.Scheduler)
+	addActions(duplicated.Scheduler)
+	if err := Replay(recorder, duplicated); err == nil {
+		t.Errorf("Expected an error replaying against a scheduler with duplicate names")
+	}
+}
+
+func TestReplayUnnamed(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	recorder := NewRecorder()
+	v.SetRecorder(recorder)
+	for i := 0; i < 2; i++ {
+		v.Add(NewOneOff(start.Add(time.Minute)), func(args ...interface{}) {})
+	}
+	v.Advance(time.Minute)
+
+	replay := NewVirtualScheduler(start)
+	for i := 0; i < 2; i++ {
+		replay.Add(NewOneOff(start.Add(time.Minute)), func(args ...interface{}) {})
+	}
+	if err := Replay(recorder, replay); err == nil {
+		t.Errorf("Expected an error replaying unnamed actions")
+	}
+}
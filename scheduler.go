@@ -0,0 +1,839 @@
+package gochronos
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Scheduler holds a schedule of actions, and executes them in accordance with their time
+// specifications. The package-level functions operate on a default scheduler; separate schedulers
+// can be created with New to keep schedules isolated from each other.
+type Scheduler struct {
+	// A list of scheduled actions. This is the schedule that is executed.
+	actions map[*ScheduledAction]bool
+
+	// This is used to synchronise updates to the schedule across goroutines.
+	lock sync.Mutex
+
+	// if serial execution is enabled, actions are sent here to be executed one at a time, by a
+	// dedicated goroutine, which sets serialBusy, accessed atomically, while it executes one. The
+	// goroutine is running until serialStop, guarded by lock, is closed.
+	serial     chan func()
+	serialBusy int32
+	serialStop chan struct{}
+
+	// the source of the current time.
+	clock Clock
+
+	// if set, the location the schedule is evaluated in, guarded by locationLock.
+	location     *time.Location
+	locationLock sync.Mutex
+
+	// the number of actions that have been added.
+	seq uint64
+
+	// if set, actions don't have timer goroutines; instead they are executed by advance.
+	driven bool
+
+	// if the scheduler is driven by ticks, the clock that holds the time of the latest tick.
+	ticks *virtualClock
+
+	// the maximum number of actions in the schedule, or 0 for unlimited.
+	maxActions int
+
+	// the shortest gap between occurrences allowed for actions being added, or 0 for any.
+	minInterval time.Duration
+
+	// execution quotas shared by the actions with a tag, keyed by tag.
+	quotas map[string]*quota
+
+	// if admissionMax is set, actions aren't added if that many are already due within
+	// admissionWindow of their first execution.
+	admissionWindow time.Duration
+	admissionMax    int
+
+	// the actions with a running timer goroutine, whether or not they are still in the schedule.
+	// Entries are removed as the goroutines exit.
+	running map[*ScheduledAction]bool
+
+	// if anything has asked for durations, the duration of each execution is sent here. Durations
+	// that don't fit in the buffer are counted in droppedDurations.
+	durations        chan ActionDuration
+	droppedDurations uint64
+
+	// if set, called when an action is added and more than duplicateThreshold actions have an
+	// equal time spec.
+	duplicateWarner    func(spec *TimeSpec, count int)
+	duplicateThreshold int
+
+	// held while an action in a serial group executes, keyed by group.
+	serialGroups map[string]*sync.Mutex
+
+	// the longest a timer goroutine sleeps before re-evaluating its next execution time.
+	maxTimerWait time.Duration
+
+	// records the keys of actions added with WithRunOnceKey.
+	onceStore OnceStore
+
+	// if set, actions beyond this many timer goroutines are multiplexed onto a driver goroutine,
+	// which is running if driving is set, and is woken by driverWake.
+	maxGoroutines int
+	driving       bool
+	driverWake    chan struct{}
+
+	// if startupFlush is set, one-offs that are already due when the first schedule is loaded are
+	// executed straight away. flushed is set once that has happened.
+	startupFlush bool
+	flushed      bool
+
+	// if set, receives metrics of executions.
+	metrics MetricsSink
+
+	// if set, records executions.
+	recorder *Recorder
+
+	// if set, caches the next occurrences of each action.
+	upcoming *upcomingCache
+
+	// if set, the source of random numbers, e.g. for jitter, guarded by randLock.
+	rand     *rand.Rand
+	randLock sync.Mutex
+}
+
+// The longest a timer is armed for. Further-off executions are re-evaluated at least this often,
+// so they stay accurate if the clock is adjusted.
+const maxTimerWait = 24 * time.Hour
+
+// The size of the buffer of execution durations.
+const durationBuffer = 100
+
+// ActionDuration reports how long an execution of an action took.
+type ActionDuration struct {
+	Action  *ScheduledAction
+	Start   time.Time
+	Elapsed time.Duration
+}
+
+// A budget of executions per sliding window of time.
+type quota struct {
+	max int
+	per time.Duration
+
+	// the times of executions within the current window.
+	fired []time.Time
+}
+
+// Returned when adding an action to a schedule that already holds its maximum number of actions.
+var ErrScheduleFull = errors.New("gochronos: schedule is full")
+
+// Returned when adding an action without an action function, which would have nothing to execute.
+// This includes an action created with WithRegisteredAction whose name isn't registered.
+var ErrNilAction = errors.New("gochronos: action is nil")
+
+// Returned when adding an action whose time spec executes more often than the scheduler's minimum
+// interval, as set by SetMinInterval.
+var ErrIntervalTooSmall = errors.New("gochronos: interval is below the minimum")
+
+// Returned when adding an action whose first execution falls in a part of the schedule that is
+// already busy, as set by SetAdmissionWindow.
+var ErrScheduleCongested = errors.New("gochronos: schedule is congested")
+
+// SchedulerOption configures optional behaviour of a Scheduler.
+type SchedulerOption func(*Scheduler)
+
+// Create a new scheduler with an empty schedule, isolated from the default schedule and any other
+// scheduler, e.g. one per tenant or subsystem.
+func New(opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{actions: make(map[*ScheduledAction]bool), clock: realClock{}, maxTimerWait: maxTimerWait}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Execute all actions of the scheduler on a single dedicated goroutine, locked to its OS thread.
+// Executions are serialised in the order they fall due, so actions never run concurrently with
+// each other. This is useful when actions are not thread-safe. A long-running action delays any
+// others that fall due while it runs.
+func WithSerialExecution() SchedulerOption {
+	return func(s *Scheduler) {
+		s.serial = make(chan func())
+	}
+}
+
+// Seed the scheduler's random numbers, which are used for retry jitter, start-up spread and picking
+// group members, so that they are deterministic, e.g. in tests. Otherwise the global source of
+// math/rand is used.
+func WithRandSeed(seed int64) SchedulerOption {
+	return func(s *Scheduler) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// A random number in [0, 1), from the scheduler's seeded source if it has one.
+func (s *Scheduler) random() float64 {
+	if s.rand == nil {
+		return rand.Float64()
+	}
+	s.randLock.Lock()
+	defer s.randLock.Unlock()
+	return s.rand.Float64()
+}
+
+// The current time according to the scheduler's clock.
+func (s *Scheduler) now() time.Time {
+	s.locationLock.Lock()
+	loc := s.location
+	s.locationLock.Unlock()
+	if loc != nil {
+		return s.clock.Now().In(loc)
+	}
+	return s.clock.Now()
+}
+
+// Run actions sent for serial execution, until stop is closed by Shutdown.
+func (s *Scheduler) executeSerially(stop chan struct{}) {
+	runtime.LockOSThread()
+	for {
+		select {
+		case f := <-s.serial:
+			atomic.StoreInt32(&s.serialBusy, 1)
+			f()
+			atomic.StoreInt32(&s.serialBusy, 0)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// The channel that is closed to stop the serial execution goroutine, starting the goroutine if it
+// isn't running.
+func (s *Scheduler) serialRunning() chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.serialStop == nil {
+		s.serialStop = make(chan struct{})
+		go s.executeSerially(s.serialStop)
+	}
+	return s.serialStop
+}
+
+// Returns true if the scheduler's serial execution goroutine is executing an action, so an action
+// fired now is taken to be fired by that one.
+func (s *Scheduler) serialExecuting() bool {
+	return s.serial != nil && atomic.LoadInt32(&s.serialBusy) == 1
+}
+
+// Execute an action, either on the calling goroutine, or if serial execution is enabled, on the
+// scheduler's execution goroutine. Returns once the action has completed. If inline is set, e.g.
+// for an action fired by another on the execution goroutine, it runs on the calling goroutine
+// straight away, as it can't wait for the execution goroutine to be free.
+func (s *Scheduler) execute(inline bool, f func()) {
+	if s.serial == nil || inline {
+		f()
+		return
+	}
+	done := make(chan struct{})
+	run := func() {
+		defer close(done)
+		f()
+	}
+	for {
+		select {
+		case s.serial <- run:
+			<-done
+			return
+		case <-s.serialRunning():
+			// stopped by Shutdown before the action could be sent, so start another
+		}
+	}
+}
+
+// Returns true if the scheduler runs in virtual time, i.e. it was created by NewVirtualScheduler,
+// or with WithExternalTicks.
+// Time only moves for a virtual scheduler when it is advanced, so real sleeps won't make actions
+// execute.
+func (s *Scheduler) IsVirtual() bool {
+	return s.driven
+}
+
+// Limit the actions tagged with group to max executions in total in any window of length per.
+// When the quota is used up, occurrences are skipped with SKIP_QUOTA until the window moves on.
+// Setting a max of 0 or less removes the quota.
+func (s *Scheduler) SetGroupQuota(group string, max int, per time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if max <= 0 {
+		delete(s.quotas, group)
+		return
+	}
+	if s.quotas == nil {
+		s.quotas = make(map[string]*quota)
+	}
+	s.quotas[group] = &quota{max: max, per: per}
+}
+
+// Take an execution from the quotas of each of the action's groups at now. Returns false, taking
+// nothing, if any of them is used up.
+func (s *Scheduler) takeQuota(sa *ScheduledAction, now time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.quotas) == 0 {
+		return true
+	}
+
+	var taken []*quota
+	for _, tag := range sa.tags {
+		q := s.quotas[tag]
+		if q == nil {
+			continue
+		}
+		// drop executions that have left the window
+		i := 0
+		for i < len(q.fired) && !q.fired[i].After(now.Add(-q.per)) {
+			i++
+		}
+		q.fired = q.fired[i:]
+		if len(q.fired) >= q.max {
+			return false
+		}
+		taken = append(taken, q)
+	}
+	for _, q := range taken {
+		q.fired = append(q.fired, now)
+	}
+	return true
+}
+
+// Limit the schedule to n actions, so that adding more returns ErrScheduleFull. This is a safety
+// valve against runaway registration. 0, the default, is unlimited.
+func (s *Scheduler) SetMaxActions(n int) {
+	s.lock.Lock()
+	s.maxActions = n
+	s.lock.Unlock()
+}
+
+// Set a floor on how often any action may execute, e.g. to protect a downstream service, so that
+// adding an action whose time spec has a shorter gap between occurrences returns
+// ErrIntervalTooSmall. This covers fixed periods, fixed delays, the base of a backoff and the gaps
+// between times allowed by by-* rules. Actions already in the schedule are unaffected. 0, the
+// default, disables the check.
+func (s *Scheduler) SetMinInterval(d time.Duration) {
+	s.lock.Lock()
+	s.minInterval = d
+	s.lock.Unlock()
+}
+
+// Smooth out bursts of actions by rejecting adds that would overload the near-term schedule. An
+// action is not added, and ErrScheduleCongested is returned, if its first execution is within
+// window of now and max actions are already due in that window. A max of 0 or less, the default,
+// disables admission control.
+func (s *Scheduler) SetAdmissionWindow(window time.Duration, max int) {
+	s.lock.Lock()
+	s.admissionWindow = window
+	s.admissionMax = max
+	s.lock.Unlock()
+}
+
+// The lock held while an action in the serial group executes.
+func (s *Scheduler) serialGroup(key string) *sync.Mutex {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.serialGroups == nil {
+		s.serialGroups = make(map[string]*sync.Mutex)
+	}
+	group, ok := s.serialGroups[key]
+	if !ok {
+		group = &sync.Mutex{}
+		s.serialGroups[key] = group
+	}
+	return group
+}
+
+// Warn about accidental fan-out: when an action is added, if more than threshold actions in the
+// schedule have a time spec equal to its spec, warn is called with the spec and the number of
+// actions that have it. Actions with equal specs execute at the same instants, and can stampede.
+// A nil warn disables the warning.
+func (s *Scheduler) SetDuplicateWarner(threshold int, warn func(spec *TimeSpec, count int)) {
+	s.lock.Lock()
+	s.duplicateThreshold = threshold
+	s.duplicateWarner = warn
+	s.lock.Unlock()
+}
+
+// The number of actions in the schedule whose time spec is equal to ts. s.lock must be held.
+func (s *Scheduler) duplicates(ts *TimeSpec) int {
+	if s.duplicateWarner == nil {
+		return 0
+	}
+	n := 0
+	for sa := range s.actions {
+		if sa.spec().Equal(ts) {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns true if the action would overload the near-term schedule. s.lock must be held.
+func (s *Scheduler) congested(sa *ScheduledAction, now time.Time) bool {
+	if s.admissionMax <= 0 {
+		return false
+	}
+	end := now.Add(s.admissionWindow)
+	first := now
+	if !sa.startsNow(now) {
+		first = sa.findNext(now, false)
+	}
+	if first.IsZero() || first.After(end) {
+		return false
+	}
+
+	due := 0
+	for other := range s.actions {
+		if next, ok := other.NextExecution(); ok && !next.After(end) {
+			due++
+		}
+	}
+	return due >= s.admissionMax
+}
+
+// Add a scheduled action to the schedule, returning an error if its time specification is invalid
+// or the schedule is full or congested, in which case the action is not added.
+func (s *Scheduler) AddToSchedule(sa *ScheduledAction) error {
+	_, err := s.addAction(sa)
+	return err
+}
+
+// Add a scheduled action to the schedule, returning ErrNilSpec if it has no time spec,
+// ErrNilAction if it has no action function, ErrIntervalTooSmall if it executes more often than
+// the minimum interval, ErrScheduleFull if the schedule is full, or ErrScheduleCongested if it is
+// congested.
+func (s *Scheduler) addToSchedule(sa *ScheduledAction) error {
+	ts := sa.spec()
+	if ts == nil {
+		return ErrNilSpec
+	}
+	if sa.Action == nil && sa.actionErr == nil && sa.actionCtx == nil {
+		return ErrNilAction
+	}
+	s.lock.Lock()
+	min := s.minInterval
+	s.lock.Unlock()
+	if min > 0 {
+		if gap := ts.shortestGap(s.now()); gap > 0 && gap < min {
+			return ErrIntervalTooSmall
+		}
+	}
+
+	s.lock.Lock()
+	if s.maxActions > 0 && len(s.actions) >= s.maxActions {
+		s.lock.Unlock()
+		return ErrScheduleFull
+	}
+	if s.congested(sa, s.now()) {
+		s.lock.Unlock()
+		return ErrScheduleCongested
+	}
+
+	// add a scheduled action to the list
+	s.actions[sa] = true
+	if sa.scheduler != s {
+		// an action added again keeps its scheduler, which a goroutine left over from before
+		// may still be reading
+		sa.scheduler = s
+	}
+	s.seq++
+	sa.seq = s.seq
+	sa.renew()
+	sa.setState(STATE_ACTIVE)
+	multiplexed := s.multiplex(sa)
+
+	duplicates := s.duplicates(ts)
+	warn := s.duplicateWarner
+	s.lock.Unlock()
+
+	if warn != nil && duplicates > s.duplicateThreshold {
+		warn(ts, duplicates)
+	}
+
+	now := s.now()
+	next, known := sa.ensureRecent(now)
+	sa.catchUpOnStartup(now)
+	first := sa.firstAfter(now, next, known)
+	if s.driven || multiplexed {
+		sa.reschedule(first)
+	} else {
+		sa.startTimer(first)
+	}
+	return nil
+}
+
+// Add a scheduled action to the schedule. This is the same as AddE, except that the error is
+// discarded; nil is returned if the action could not be added.
+func (s *Scheduler) Add(ts *TimeSpec, f ActionFunc, args ...interface{}) *ScheduledAction {
+	sa, _ := s.AddE(ts, f, args...)
+	return sa
+}
+
+// Add a scheduled action to the schedule, returning an error if the time specification is invalid
+// or the schedule is full or congested.
+func (s *Scheduler) AddE(ts *TimeSpec, f ActionFunc, args ...interface{}) (*ScheduledAction, error) {
+	return s.addAction(NewScheduledAction(ts, f, args))
+}
+
+// Add a scheduled action with an error-returning action function to the schedule. nil is returned
+// if the action could not be added.
+func (s *Scheduler) AddErr(ts *TimeSpec, f ActionFuncErr, args ...interface{}) *ScheduledAction {
+	sa := NewScheduledAction(ts, nil, args)
+	sa.actionErr = f
+	sa, _ = s.addAction(sa)
+	return sa
+}
+
+// Add a scheduled action with a context-aware action function to the schedule. Each execution is
+// passed a context, which carries any deadline the execution has, and is cancelled when the
+// action is removed or the scheduler shuts down. nil is returned if the action could not be added.
+func (s *Scheduler) AddCtx(ts *TimeSpec, f ActionFuncCtx, args ...interface{}) *ScheduledAction {
+	sa := NewScheduledAction(ts, nil, args)
+	sa.actionCtx = f
+	sa, _ = s.addAction(sa)
+	return sa
+}
+
+// Add a scheduled action with an adaptive action function to the schedule. Each time the action
+// returns a non-zero duration, that becomes its cadence, e.g. to poll more often when there is
+// activity. Errors are handled as for AddErr. nil is returned if the action could not be added.
+func (s *Scheduler) AddAdaptive(ts *TimeSpec, f ActionFuncAdaptive, args ...interface{}) *ScheduledAction {
+	sa := NewScheduledAction(ts, nil, args)
+	sa.actionErr = func(params ...interface{}) error {
+		d, err := f(params...)
+		if d > 0 {
+			sa.adapt(d)
+		}
+		return err
+	}
+	added, _ := s.addAction(sa)
+	return added
+}
+
+// Add a new scheduled action to the schedule, returning an error if its time specification is
+// invalid or the schedule is full.
+func (s *Scheduler) addAction(sa *ScheduledAction) (*ScheduledAction, error) {
+	if err := sa.When.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.addToSchedule(sa); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// Add a scheduled action that executes once immediately, and then follows its time specification.
+// The immediate execution ignores the recurrence entirely, e.g. to kick off a nightly job as soon
+// as it's added. It counts towards maxnum. nil is returned if the action could not be added.
+func (s *Scheduler) AddWithKickoff(ts *TimeSpec, f ActionFunc, args ...interface{}) *ScheduledAction {
+	sa := NewScheduledAction(ts, f, args)
+	sa.kickoff = true
+	sa, _ = s.addAction(sa)
+	return sa
+}
+
+// Add a scheduled action, returning a closer that cancels it as well as the action, for the
+// defer-Close idiom. Both are nil if the action could not be added.
+func (s *Scheduler) AddCloser(ts *TimeSpec, f ActionFunc, args ...interface{}) (io.Closer, *ScheduledAction) {
+	sa := s.Add(ts, f, args...)
+	if sa == nil {
+		return nil, nil
+	}
+	return sa, sa
+}
+
+// Add a batch of scheduled actions. Each request is added independently, and the returned slices
+// are aligned with specs: for each request, either the scheduled action or the error is non-nil.
+func (s *Scheduler) AddMany(specs []AddRequest) ([]*ScheduledAction, []error) {
+	actions := make([]*ScheduledAction, len(specs))
+	errs := make([]error, len(specs))
+	for i, req := range specs {
+		args := append([]interface{}(nil), req.Params...)
+		for _, opt := range req.Options {
+			args = append(args, opt)
+		}
+		actions[i], errs[i] = s.AddE(req.When, req.Action, args...)
+	}
+	return actions, errs
+}
+
+// Remove a scheduled action from the schedule, returning true if it was live and is now being
+// cancelled, or false if it was already gone.
+func (s *Scheduler) Remove(sa *ScheduledAction) bool {
+	// Take the action out of the schedule straight away, so that only the first remove cancels it.
+	s.lock.Lock()
+	live := s.actions[sa]
+	delete(s.actions, sa)
+	s.lock.Unlock()
+	if !live {
+		return false
+	}
+
+	// Tell the timer goroutine to stop. This in turn will trigger the goroutine to finish. An
+	// execution in flight holds up the goroutine, so its context is cancelled straight away.
+	sa.cancelContext()
+	sa.stopTimer()
+	return true
+}
+
+// Shut down the scheduler: remove every action from the schedule, cancelling the contexts of any
+// executions in flight, so that context-aware actions can stop early, and stop the goroutines of
+// serial execution and of multiplexed actions once they are idle. Actions can be added again
+// afterwards, and their goroutines are started again as needed.
+func (s *Scheduler) Shutdown() {
+	for _, sa := range s.ordered() {
+		s.Remove(sa)
+	}
+
+	s.lock.Lock()
+	if s.serialStop != nil {
+		close(s.serialStop)
+		s.serialStop = nil
+	}
+	s.lock.Unlock()
+	// the driver exits when it wakes to find no multiplexed actions
+	s.wakeDriver()
+}
+
+// Remove all scheduled actions that have the tag, returning the number removed.
+func (s *Scheduler) RemoveByTag(tag string) int {
+	removed := 0
+	for _, sa := range s.withTag(tag) {
+		if s.Remove(sa) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Pause all scheduled actions that have the tag, returning the number paused. Actions that aren't
+// active are left alone.
+func (s *Scheduler) PauseByTag(tag string) int {
+	paused := 0
+	for _, sa := range s.withTag(tag) {
+		if sa.pause() {
+			paused++
+		}
+	}
+	return paused
+}
+
+// Resume all paused scheduled actions that have the tag, returning the number resumed.
+func (s *Scheduler) ResumeByTag(tag string) int {
+	resumed := 0
+	for _, sa := range s.withTag(tag) {
+		if sa.resume() {
+			resumed++
+		}
+	}
+	return resumed
+}
+
+// The scheduled actions that have the tag.
+func (s *Scheduler) withTag(tag string) []*ScheduledAction {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var matched []*ScheduledAction
+	for sa := range s.actions {
+		if sa.HasTag(tag) {
+			matched = append(matched, sa)
+		}
+	}
+	return matched
+}
+
+// The distinct tags of all the actions in the schedule, sorted.
+func (s *Scheduler) Tags() []string {
+	s.lock.Lock()
+	seen := make(map[string]bool)
+	for sa := range s.actions {
+		for _, tag := range sa.tags {
+			seen[tag] = true
+		}
+	}
+	s.lock.Unlock()
+
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Remove scheduled action from list. This assumes the timer goroutine
+// is not going to trigger more events. This can be called by the timer
+// goroutines when they reach termination, so locking is required on the structure.
+func (s *Scheduler) remove(sa *ScheduledAction) {
+	s.lock.Lock()
+
+	delete(s.actions, sa)
+
+	s.lock.Unlock()
+}
+
+// The actions in the schedule, in the order they were added.
+func (s *Scheduler) ordered() []*ScheduledAction {
+	s.lock.Lock()
+	actions := make([]*ScheduledAction, 0, len(s.actions))
+	for sa := range s.actions {
+		actions = append(actions, sa)
+	}
+	s.lock.Unlock()
+	sort.Slice(actions, func(i, j int) bool { return actions[i].seq < actions[j].seq })
+	return actions
+}
+
+// Returns true if the action is in the schedule.
+func (s *Scheduler) contains(sa *ScheduledAction) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.actions[sa]
+}
+
+// The number of actions in the schedule.
+func (s *Scheduler) Count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.actions)
+}
+
+// The times each action in the schedule will execute from start up to end, computed without
+// executing anything, e.g. to preview what will happen tonight. Actions that won't execute in the
+// window are omitted. Gates and quotas can't be predicted, so aren't taken into account.
+func (s *Scheduler) OccurrencesBetween(start, end time.Time) map[*ScheduledAction][]time.Time {
+	s.lock.Lock()
+	actions := make([]*ScheduledAction, 0, len(s.actions))
+	for sa := range s.actions {
+		actions = append(actions, sa)
+	}
+	s.lock.Unlock()
+
+	result := make(map[*ScheduledAction][]time.Time)
+	for _, sa := range actions {
+		if times := sa.between(start, end); len(times) > 0 {
+			result[sa] = times
+		}
+	}
+	return result
+}
+
+// The number of executions projected across all the actions in the schedule in each bucket of the
+// window from now, e.g. for capacity planning, or to spot future spikes. The first bucket starts
+// now, and a last, partial bucket is included if bucket doesn't divide window. Executions are
+// projected as by OccurrencesBetween.
+func (s *Scheduler) ProjectedLoad(window, bucket time.Duration) []int {
+	if window <= 0 || bucket <= 0 {
+		return nil
+	}
+	start := s.now()
+	counts := make([]int, (window+bucket-1)/bucket)
+	for _, times := range s.OccurrencesBetween(start, start.Add(window)) {
+		for _, t := range times {
+			counts[t.Sub(start)/bucket]++
+		}
+	}
+	return counts
+}
+
+// The number of actions in the schedule in each state. Actions that are done are removed from the
+// schedule, so aren't counted.
+func (s *Scheduler) CountByState() map[State]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := make(map[State]int)
+	for sa := range s.actions {
+		counts[sa.State()]++
+	}
+	return counts
+}
+
+// A channel on which the wall-clock duration of each execution of the schedule's actions is sent,
+// e.g. to build latency histograms. The channel is buffered, and if a consumer falls behind,
+// durations are dropped rather than delaying the actions; see DroppedDurations.
+func (s *Scheduler) Durations() <-chan ActionDuration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.durations == nil {
+		s.durations = make(chan ActionDuration, durationBuffer)
+	}
+	return s.durations
+}
+
+// The number of execution durations that were dropped because the Durations channel was full.
+func (s *Scheduler) DroppedDurations() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.droppedDurations
+}
+
+// Report the duration of an execution, if anything has asked for durations.
+func (s *Scheduler) reportDuration(d ActionDuration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.durations == nil {
+		return
+	}
+	select {
+	case s.durations <- d:
+	default:
+		s.droppedDurations++
+	}
+}
+
+// Record that the action's timer goroutine is running. It is removed by finishTimer as the
+// goroutine exits.
+func (s *Scheduler) track(sa *ScheduledAction) {
+	s.lock.Lock()
+	if s.running == nil {
+		s.running = make(map[*ScheduledAction]bool)
+	}
+	s.running[sa] = true
+	s.lock.Unlock()
+}
+
+// Cancel the timer goroutines of actions that are no longer in the schedule but are still running,
+// returning the number cancelled. Orphaned actions otherwise keep executing against a schedule
+// they're no longer part of.
+func (s *Scheduler) ReapOrphans() int {
+	s.lock.Lock()
+	var orphans []*ScheduledAction
+	for sa := range s.running {
+		if !s.actions[sa] {
+			orphans = append(orphans, sa)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, sa := range orphans {
+		sa.stopTimer()
+	}
+	return len(orphans)
+}
+
+// Clear the schedule of all scheduled actions, cancelling them as Remove does. The schedule is
+// replaced under the lock, so this is safe to call concurrently with adding and removing actions.
+func (s *Scheduler) ClearAll() {
+	s.lock.Lock()
+	cleared := s.actions
+	s.actions = make(map[*ScheduledAction]bool)
+	s.lock.Unlock()
+
+	for sa := range cleared {
+		sa.cancelContext()
+		sa.stopTimer()
+	}
+}
@@ -0,0 +1,824 @@
+package gochronos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSerialExecution(t *testing.T) {
+	s := New(WithSerialExecution())
+
+	var lock sync.Mutex
+	running := 0
+	maxRunning := 0
+	count := 0
+
+	f := func(args ...interface{}) {
+		lock.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		lock.Unlock()
+
+		time.Sleep(100 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		count++
+		lock.Unlock()
+	}
+
+	// several actions that all fall due at the same time
+	when := time.Now().Add(200 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		s.Add(NewOneOff(when), f)
+	}
+
+	time.Sleep(time.Second)
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if count != 4 {
+		t.Errorf("Expected all 4 actions to execute, %d executed", count)
+	}
+	if maxRunning != 1 {
+		t.Errorf("Expected actions to never run concurrently, %d ran at once", maxRunning)
+	}
+	if s.Count() != 0 {
+		t.Errorf("Expected schedule to be empty, contains %d item(s)", s.Count())
+	}
+}
+
+func TestSerialExecutionFire(t *testing.T) {
+	s := New(WithSerialExecution())
+
+	// an action that fires another on the serial goroutine
+	fired := make(chan struct{})
+	other := s.Add(NewOneOff(time.Now().Add(time.Hour)), func(args ...interface{}) {
+		close(fired)
+	})
+	s.Add(NewOneOff(time.Now().Add(100*time.Millisecond)), func(args ...interface{}) {
+		other.Fire()
+	})
+
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Errorf("Expected an action fired from another in a serial schedule to execute")
+	}
+	s.Shutdown()
+}
+
+func TestAddToScheduleInvalid(t *testing.T) {
+	s := New()
+	sa := NewScheduledAction(NewRecurring(map[string]interface{}{
+		"starttime": time.Now(),
+		"frequency": FREQ_MINUTE,
+		"interval":  0,
+	}), func(args ...interface{}) {}, nil)
+	if err := s.AddToSchedule(sa); err == nil {
+		t.Errorf("Expected error adding an action with an invalid time spec")
+	}
+	if s.Count() != 0 {
+		t.Errorf("Expected the action not to be added, got %d actions", s.Count())
+	}
+}
+
+// Wait up to a second for a condition to become true.
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestIsolatedSchedulers(t *testing.T) {
+	a, b := New(), New()
+	f := func(args ...interface{}) {}
+	sa := a.Add(NewOneOff(time.Now().Add(time.Hour)), f)
+	b.Add(NewOneOff(time.Now().Add(time.Hour)), f)
+	b.Add(NewOneOff(time.Now().Add(time.Hour)), f)
+
+	if a.Count() != 1 || b.Count() != 2 {
+		t.Fatalf("Expected schedules of 1 and 2 actions, got %d and %d", a.Count(), b.Count())
+	}
+	if b.Remove(sa) {
+		t.Errorf("Expected removing another scheduler's action to have no effect")
+	}
+	b.ClearAll()
+	if a.Count() != 1 || b.Count() != 0 {
+		t.Errorf("Expected clearing one schedule to leave the other, got %d and %d", a.Count(), b.Count())
+	}
+	a.ClearAll()
+}
+
+func TestCancelOnRemove(t *testing.T) {
+	run := func(stop func(s *Scheduler, sa *ScheduledAction)) error {
+		s := New()
+		started := make(chan struct{})
+		stopped := make(chan error, 1)
+		sa := s.AddCtx(NewOneOff(time.Now().Add(50*time.Millisecond)), func(ctx context.Context, args ...interface{}) {
+			close(started)
+			select {
+			case <-ctx.Done():
+				stopped <- ctx.Err()
+			case <-time.After(5 * time.Second):
+				stopped <- nil
+			}
+		})
+
+		<-started
+		stop(s, sa)
+		select {
+		case err := <-stopped:
+			return err
+		case <-time.After(time.Second):
+			return errors.New("action kept running")
+		}
+	}
+
+	if err := run(func(s *Scheduler, sa *ScheduledAction) { s.Remove(sa) }); err != context.Canceled {
+		t.Errorf("Expected removing the action to cancel its context, got %v", err)
+	}
+	if err := run(func(s *Scheduler, sa *ScheduledAction) { s.Shutdown() }); err != context.Canceled {
+		t.Errorf("Expected shutting down the scheduler to cancel the context, got %v", err)
+	}
+}
+
+func TestReAddRealTimer(t *testing.T) {
+	s := New()
+	var runs int32
+	sa := s.Add(NewFixedDelay(time.Now(), 10*time.Millisecond), func(args ...interface{}) {
+		atomic.AddInt32(&runs, 1)
+	})
+
+	for _, stop := range []func(){
+		func() { s.Remove(sa) },
+		func() { sa.Drain(context.Background()) },
+	} {
+		time.Sleep(50 * time.Millisecond)
+		stop()
+		if err := s.AddToSchedule(sa); err != nil {
+			t.Fatalf("Expected to add the action again, got %s", err)
+		}
+		before := atomic.LoadInt32(&runs)
+		time.Sleep(100 * time.Millisecond)
+		if s.Count() != 1 || sa.State() != STATE_ACTIVE {
+			t.Fatalf("Expected the action to stay scheduled once added again, got %d actions and state %s", s.Count(), sa.State())
+		}
+		if atomic.LoadInt32(&runs) == before {
+			t.Errorf("Expected the action to run once added again")
+		}
+	}
+
+	s.Remove(sa)
+	time.Sleep(30 * time.Millisecond)
+	stopped := atomic.LoadInt32(&runs)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != stopped {
+		t.Errorf("Expected no runs after the action was removed, got %d more", n-stopped)
+	}
+}
+
+func TestContextAfterReAdd(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	var errs []error
+	sa := v.AddCtx(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	}), func(ctx context.Context, args ...interface{}) {
+		errs = append(errs, ctx.Err())
+	})
+
+	v.Advance(time.Hour)
+	v.Remove(sa)
+	v.AddToSchedule(sa)
+	v.Advance(time.Hour)
+	v.Remove(sa)
+	if len(errs) != 2 || errs[0] != nil || errs[1] != nil {
+		t.Errorf("Expected an action added again to execute with a live context, got %v", errs)
+	}
+}
+
+func TestShutdownStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// serial, with every action multiplexed onto the driver
+	s := New(WithSerialExecution())
+	s.SetMaxGoroutines(1)
+	ran := make(chan struct{}, 1)
+	f := func(args ...interface{}) {
+		select {
+		case ran <- struct{}{}:
+		default:
+		}
+	}
+	s.Add(NewRecurring(map[string]interface{}{
+		"starttime": time.Now(),
+		"frequency": FREQ_SECOND,
+	}), f)
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the action to execute")
+	}
+
+	s.Shutdown()
+	if !waitFor(func() bool { return runtime.NumGoroutine() <= before }) {
+		t.Errorf("Expected shutdown to stop the scheduler's goroutines, %d running, was %d", runtime.NumGoroutine(), before)
+	}
+
+	// the goroutines start again for actions added afterwards
+	s.Add(NewOneOff(time.Now().Add(50*time.Millisecond)), f)
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Errorf("Expected an action added after shutdown to execute")
+	}
+	s.Shutdown()
+}
+
+func TestRemoveByTag(t *testing.T) {
+	s := New()
+	when := NewOneOff(time.Now().Add(time.Hour))
+	f := func(args ...interface{}) {}
+
+	var tagged, untagged []*ScheduledAction
+	for i := 0; i < 3; i++ {
+		tagged = append(tagged, s.Add(when, f, WithTags("nightly", "other")))
+	}
+	for i := 0; i < 2; i++ {
+		untagged = append(untagged, s.Add(when, f, WithTags("other")))
+	}
+
+	if n := s.RemoveByTag("nightly"); n != 3 {
+		t.Errorf("Expected 3 actions to be removed, %d were removed", n)
+	}
+
+	if !waitFor(func() bool { return s.Count() == 2 }) {
+		t.Fatalf("Expected 2 actions to remain, schedule contains %d item(s)", s.Count())
+	}
+	for _, sa := range tagged {
+		if s.contains(sa) {
+			t.Errorf("Expected tagged action to be removed")
+		}
+	}
+	for _, sa := range untagged {
+		if !s.contains(sa) {
+			t.Errorf("Expected untagged action to remain")
+		}
+	}
+
+	s.RemoveByTag("other")
+}
+
+// Run with -race to check that the schedule is consistently synchronised.
+func TestConcurrentClearAll(t *testing.T) {
+	s := New()
+	f := func(args ...interface{}) {}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				// past one-offs finish and remove themselves straight away
+				s.Add(NewOneOff(time.Now().Add(-time.Second)), f)
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				s.ClearAll()
+				s.Count()
+			}
+		}()
+	}
+	wg.Wait()
+
+	s.ClearAll()
+	if s.Count() != 0 {
+		t.Errorf("Expected schedule to be empty after ClearAll, contains %d item(s)", s.Count())
+	}
+
+	var added []*ScheduledAction
+	for i := 0; i < 10; i++ {
+		added = append(added, s.Add(NewOneOff(time.Now().Add(time.Hour)), f))
+	}
+	if s.Count() != 10 {
+		t.Errorf("Expected 10 actions in the schedule, contains %d item(s)", s.Count())
+	}
+	for _, sa := range added {
+		s.Remove(sa)
+	}
+}
+
+func TestMaxActions(t *testing.T) {
+	v := NewVirtualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	v.SetMaxActions(2)
+	f := func(args ...interface{}) {}
+
+	for i := 0; i < 2; i++ {
+		if _, err := v.AddE(NewOneOff(v.Now().Add(time.Hour)), f); err != nil {
+			t.Fatalf("Expected action %d to be added, got %s", i, err)
+		}
+	}
+
+	sa, err := v.AddE(NewOneOff(v.Now().Add(time.Hour)), f)
+	if err != ErrScheduleFull {
+		t.Errorf("Expected ErrScheduleFull adding beyond the cap, got %v", err)
+	}
+	if sa != nil {
+		t.Errorf("Expected no action to be returned beyond the cap")
+	}
+	if v.Count() != 2 {
+		t.Errorf("Expected schedule to contain 2 actions, contains %d", v.Count())
+	}
+}
+
+func TestMinInterval(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	v.SetMinInterval(time.Second)
+	f := func(args ...interface{}) {}
+
+	if _, err := v.AddE(NewFixedDelay(start, 100*time.Millisecond), f); err != ErrIntervalTooSmall {
+		t.Errorf("Expected ErrIntervalTooSmall adding a 100ms interval, got %v", err)
+	}
+	if _, err := v.AddE(NewFixedDelay(start, 2*time.Second), f); err != nil {
+		t.Errorf("Expected a 2s interval to be added, got %s", err)
+	}
+
+	// the gaps between times allowed by by-* rules count too
+	minutes := NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_HOUR, "byminute": []int{0, 30}})
+	v.SetMinInterval(time.Hour)
+	if _, err := v.AddE(minutes, f); err != ErrIntervalTooSmall {
+		t.Errorf("Expected ErrIntervalTooSmall adding occurrences 30 minutes apart, got %v", err)
+	}
+	if v.Count() != 1 {
+		t.Errorf("Expected schedule to contain 1 action, contains %d", v.Count())
+	}
+}
+
+func TestRecomputeAll(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	var fired []time.Time
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"byhour":    9,
+		"byminute":  0,
+	}), func(args ...interface{}) { fired = append(fired, v.Now()) })
+
+	expected := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	if next, _ := sa.NextExecution(); !next.Equal(expected) {
+		t.Errorf("Expected the next execution at %s, got %s", expected, next)
+	}
+
+	// 9am in the new zone is 14:00 UTC
+	v.SetLocation(time.FixedZone("EST", -5*60*60))
+	v.RecomputeAll()
+	expected = time.Date(2024, 1, 1, 14, 0, 0, 0, time.UTC)
+	if next, _ := sa.NextExecution(); !next.Equal(expected) {
+		t.Errorf("Expected the next execution at %s after changing location, got %s", expected, next)
+	}
+	v.AdvanceTo(time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC))
+	if len(fired) != 2 || !fired[0].Equal(expected) || !fired[1].Equal(expected.AddDate(0, 0, 1)) {
+		t.Errorf("Expected executions at 14:00 UTC each day, got %v", fired)
+	}
+}
+
+func TestProjectedLoad(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	f := func(args ...interface{}) {}
+
+	// every 15 minutes from 00:15, and at 00:30 and 01:30, in buckets of an hour
+	v.Add(NewRecurring(map[string]interface{}{"starttime": start.Add(15 * time.Minute), "frequency": FREQ_MINUTE, "interval": 15}), f)
+	v.Add(NewRecurring(map[string]interface{}{"starttime": start.Add(30 * time.Minute), "frequency": FREQ_HOUR}), f)
+	v.Add(NewOneOff(start.Add(150*time.Minute)), f)
+
+	expected := []int{3 + 1, 4 + 1, 4 + 1 + 1}
+	if load := v.ProjectedLoad(3*time.Hour, time.Hour); !reflect.DeepEqual(load, expected) {
+		t.Errorf("Expected projected load %v, got %v", expected, load)
+	}
+
+	// a partial last bucket covers the end of the window
+	expected = []int{3 + 1, 4 + 1, 2}
+	if load := v.ProjectedLoad(140*time.Minute, time.Hour); !reflect.DeepEqual(load, expected) {
+		t.Errorf("Expected projected load %v with a partial bucket, got %v", expected, load)
+	}
+}
+
+func TestAddWithKickoff(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(now)
+
+	// every night at 2am
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": time.Date(2024, 1, 1, 2, 0, 0, 0, time.UTC),
+		"frequency": FREQ_DAY,
+	})
+	var fired []time.Time
+	v.AddWithKickoff(ts, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+
+	v.AdvanceTo(time.Date(2024, 1, 3, 3, 0, 0, 0, time.UTC))
+
+	expected := []time.Time{
+		now,
+		time.Date(2024, 1, 2, 2, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 3, 2, 0, 0, 0, time.UTC),
+	}
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected %d executions, got %v", len(expected), fired)
+	}
+	for i := range expected {
+		if !fired[i].Equal(expected[i]) {
+			t.Errorf("Expected execution %d at %s, got %s", i, expected[i], fired[i])
+		}
+	}
+}
+
+func TestGroupQuota(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	v.SetGroupQuota("api", 5, time.Hour)
+
+	// two pollers every 10 minutes would make 12 calls an hour between them
+	var fired []time.Time
+	skips := 0
+	for i := 0; i < 2; i++ {
+		v.Add(NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_MINUTE,
+			"interval":  10,
+		}), func(args ...interface{}) {
+			fired = append(fired, v.Now())
+		}, WithTags("api"), WithOnSkip(func(sa *ScheduledAction, reason SkipReason) {
+			if reason == SKIP_QUOTA {
+				skips++
+			}
+		}))
+	}
+
+	v.AdvanceTo(start.Add(3 * time.Hour))
+
+	if skips == 0 {
+		t.Errorf("Expected some occurrences to be skipped by the quota")
+	}
+	for i := range fired {
+		inWindow := 0
+		for _, f := range fired {
+			if f.After(fired[i].Add(-time.Hour)) && !f.After(fired[i]) {
+				inWindow++
+			}
+		}
+		if inWindow > 5 {
+			t.Fatalf("Expected at most 5 executions in any hour, got %d in the hour up to %s", inWindow, fired[i])
+		}
+	}
+	if len(fired) < 10 {
+		t.Errorf("Expected the quota to refill as the window moves on, got %d executions", len(fired))
+	}
+}
+
+func TestCountByState(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	f := func(args ...interface{}) {}
+
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+	v.Add(hourly, f)
+	v.Add(hourly, f)
+	v.Add(hourly, f).Disable()
+	v.Add(NewOneOff(start.Add(time.Minute)), f)
+
+	counts := v.CountByState()
+	if counts[STATE_ACTIVE] != 3 || counts[STATE_DISABLED] != 1 {
+		t.Errorf("Expected 3 active and 1 disabled, got %v", counts)
+	}
+
+	// the one-off completes, and leaves the schedule
+	v.Advance(2 * time.Minute)
+	counts = v.CountByState()
+	if counts[STATE_ACTIVE] != 2 || counts[STATE_DISABLED] != 1 || counts[STATE_DONE] != 0 {
+		t.Errorf("Expected 2 active and 1 disabled after the one-off completed, got %v", counts)
+	}
+}
+
+func TestAdmissionWindow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	v.SetAdmissionWindow(time.Minute, 3)
+	f := func(args ...interface{}) {}
+
+	burst := NewOneOff(start.Add(30 * time.Second))
+	for i := 0; i < 3; i++ {
+		if _, err := v.AddE(burst, f); err != nil {
+			t.Fatalf("Expected action %d to be admitted, got %s", i, err)
+		}
+	}
+	if _, err := v.AddE(burst, f); err != ErrScheduleCongested {
+		t.Errorf("Expected ErrScheduleCongested beyond the window cap, got %v", err)
+	}
+
+	// actions due outside the window are still admitted
+	if _, err := v.AddE(NewOneOff(start.Add(time.Hour)), f); err != nil {
+		t.Errorf("Expected action outside the window to be admitted, got %s", err)
+	}
+	if v.Count() != 4 {
+		t.Errorf("Expected 4 actions in the schedule, contains %d", v.Count())
+	}
+
+	// once the burst has run, the window has room again
+	v.Advance(time.Minute)
+	if _, err := v.AddE(NewOneOff(v.Now().Add(30*time.Second)), f); err != nil {
+		t.Errorf("Expected action to be admitted after the burst, got %s", err)
+	}
+}
+
+func TestAdmissionSkipsReportedOnce(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	v.SetAdmissionWindow(time.Hour, 10)
+
+	// hourly, with the occurrences at 1:00 and 2:00 in a blackout
+	skips := 0
+	sa, err := v.AddE(NewRecurring(map[string]interface{}{
+		"starttime": start.Add(-30 * time.Minute),
+		"frequency": FREQ_HOUR,
+	}), func(args ...interface{}) {}, WithBlackout(TimeWindow{time.Hour, 3 * time.Hour}), WithEnsureRecent(time.Minute),
+		WithOnSkip(func(sa *ScheduledAction, reason SkipReason) {
+			skips++
+		}))
+	if err != nil {
+		t.Fatalf("Expected action to be admitted, got %s", err)
+	}
+	if skips != 1 {
+		t.Errorf("Expected the blackout to be reported once on add, got %d reports", skips)
+	}
+	if next, _ := sa.NextExecution(); !next.Equal(start.Add(150 * time.Minute)) {
+		t.Errorf("Expected next execution after the blackout at 3:00, got %s", next)
+	}
+}
+
+func TestOccurrencesBetween(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	count := 0
+	f := func(args ...interface{}) {
+		count++
+	}
+
+	// every 2 hours, at most 3 times, and twice-daily outside a blackout
+	limited := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+		"interval":  2,
+		"maxnum":    3,
+	}), f)
+	daily := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"byhour":    []int{1, 3, 22},
+	}), f, WithBlackout(TimeWindow{Start: 2 * time.Hour, End: 4 * time.Hour}))
+	v.Add(NewOneOff(start.AddDate(0, 0, 5)), f)
+
+	occurrences := v.OccurrencesBetween(start.Add(time.Minute), start.AddDate(0, 0, 1))
+
+	expected := map[*ScheduledAction][]time.Time{
+		limited: {start.Add(2 * time.Hour), start.Add(4 * time.Hour), start.Add(6 * time.Hour)},
+		daily:   {start.Add(time.Hour), start.Add(22 * time.Hour)},
+	}
+	if len(occurrences) != len(expected) {
+		t.Fatalf("Expected occurrences for %d actions, got %d", len(expected), len(occurrences))
+	}
+	for sa, times := range expected {
+		got := occurrences[sa]
+		if len(got) != len(times) {
+			t.Errorf("Expected %v for %s, got %v", times, sa.When, got)
+			continue
+		}
+		for i := range times {
+			if !got[i].Equal(times[i]) {
+				t.Errorf("Expected %v for %s, got %v", times, sa.When, got)
+				break
+			}
+		}
+	}
+	if count != 0 {
+		t.Errorf("Expected nothing to execute, %d executions happened", count)
+	}
+}
+
+func TestClearAllCancels(t *testing.T) {
+	s := New()
+	sa := s.Add(NewFixedDelay(time.Now(), 10*time.Millisecond), func(args ...interface{}) {})
+
+	s.ClearAll()
+	select {
+	case <-sa.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a cleared action to be cancelled")
+	}
+	if n := s.ReapOrphans(); n != 0 {
+		t.Errorf("Expected ClearAll to leave no orphans, got %d", n)
+	}
+}
+
+func TestReapOrphans(t *testing.T) {
+	s := New()
+	var lock sync.Mutex
+	count := 0
+	sa := s.Add(NewFixedDelay(time.Now(), 20*time.Millisecond), func(args ...interface{}) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+	})
+
+	// orphan the action by taking it out of the schedule without cancelling it
+	s.lock.Lock()
+	delete(s.actions, sa)
+	s.lock.Unlock()
+	current := s.Add(NewOneOff(time.Now().Add(time.Hour)), func(args ...interface{}) {})
+	before := sa.RunCount()
+	time.Sleep(100 * time.Millisecond)
+	if sa.RunCount() == before {
+		t.Fatalf("Expected orphaned action to still be executing")
+	}
+
+	if n := s.ReapOrphans(); n != 1 {
+		t.Errorf("Expected 1 orphan to be reaped, got %d", n)
+	}
+	<-sa.Done()
+	lock.Lock()
+	reaped := count
+	lock.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	lock.Lock()
+	defer lock.Unlock()
+	if count != reaped {
+		t.Errorf("Expected reaped action to stop executing, executed %d more times", count-reaped)
+	}
+	if n := s.ReapOrphans(); n != 0 {
+		t.Errorf("Expected nothing left to reap, got %d", n)
+	}
+	s.Remove(current)
+}
+
+func TestDurations(t *testing.T) {
+	s := New()
+	durations := s.Durations()
+
+	sa := s.Add(NewOneOff(time.Now().Add(50*time.Millisecond)), func(args ...interface{}) {
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	select {
+	case d := <-durations:
+		if d.Action != sa {
+			t.Errorf("Expected the duration to be reported for the action")
+		}
+		if d.Elapsed < 100*time.Millisecond || d.Elapsed > 150*time.Millisecond {
+			t.Errorf("Expected elapsed to be about 100ms, got %s", d.Elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expected the execution duration to be reported")
+	}
+
+	// durations beyond the buffer are dropped, rather than blocking
+	v := NewVirtualScheduler(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	v.Durations()
+	v.Add(NewRecurring(map[string]interface{}{
+		"starttime": v.Now(),
+		"frequency": FREQ_SECOND,
+	}), func(args ...interface{}) {})
+	v.Advance(time.Duration(durationBuffer+5) * time.Second)
+	if n := v.DroppedDurations(); n != 5 {
+		t.Errorf("Expected 5 durations to be dropped, got %d", n)
+	}
+}
+
+func TestTags(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	f := func(args ...interface{}) {}
+	ts := NewOneOff(start.Add(time.Hour))
+
+	v.Add(ts, f, WithTags("reports", "nightly"))
+	v.Add(ts, f, WithTags("nightly", "cleanup"))
+	v.Add(ts, f, WithTags("reports"))
+	v.Add(ts, f)
+
+	expected := []string{"cleanup", "nightly", "reports"}
+	if tags := v.Tags(); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected tags %v, got %v", expected, tags)
+	}
+
+	v.RemoveByTag("cleanup")
+	expected = []string{"nightly", "reports"}
+	if tags := v.Tags(); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected tags %v after removing cleanup, got %v", expected, tags)
+	}
+}
+
+func TestDuplicateWarner(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	var counts []int
+	v.SetDuplicateWarner(2, func(spec *TimeSpec, count int) {
+		counts = append(counts, count)
+	})
+
+	f := func(args ...interface{}) {}
+	hourly := func() *TimeSpec {
+		return NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_HOUR,
+		})
+	}
+	for i := 0; i < 4; i++ {
+		// separately created specs are equal
+		v.Add(hourly(), f)
+	}
+	v.Add(NewOneOff(start.Add(time.Hour)), f)
+
+	if !reflect.DeepEqual(counts, []int{3, 4}) {
+		t.Errorf("Expected warnings with counts [3 4], got %v", counts)
+	}
+}
+
+func TestPauseByTag(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	hourly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+	})
+
+	fired := make(map[string][]time.Time)
+	f := func(args ...interface{}) {
+		name := args[0].(string)
+		fired[name] = append(fired[name], v.Now())
+	}
+	v.Add(hourly, f, "sales", WithTags("reporting"))
+	v.Add(hourly, f, "stock", WithTags("reporting"))
+	v.Add(hourly, f, "import", WithTags("import"))
+	v.Add(hourly, f, "disabled", WithTags("reporting")).Disable()
+
+	if n := v.PauseByTag("reporting"); n != 2 {
+		t.Errorf("Expected 2 actions to be paused, paused %d", n)
+	}
+	if n := v.PauseByTag("reporting"); n != 0 {
+		t.Errorf("Expected pausing paused actions to have no effect, paused %d", n)
+	}
+	pausedAt := v.Now()
+	v.Advance(3*time.Hour + 30*time.Minute)
+	resumedAt := v.Now()
+
+	if n := v.ResumeByTag("reporting"); n != 2 {
+		t.Errorf("Expected 2 actions to be resumed, resumed %d", n)
+	}
+	v.Advance(time.Hour)
+
+	for _, name := range []string{"sales", "stock"} {
+		times := fired[name]
+		for _, at := range times {
+			if at.After(pausedAt) && at.Before(resumedAt) {
+				t.Errorf("Expected %s not to execute while paused, executed at %s", name, at)
+			}
+		}
+		// it catches up once on resuming, and then carries on
+		expected := []time.Time{resumedAt, start.Add(4 * time.Hour)}
+		if !reflect.DeepEqual(times, expected) {
+			t.Errorf("Expected %s to execute at %v, got %v", name, expected, times)
+		}
+	}
+	if len(fired["import"]) != 4 || len(fired["disabled"]) != 0 {
+		t.Errorf("Expected untagged action to execute 4 times and disabled none, got %d and %d", len(fired["import"]), len(fired["disabled"]))
+	}
+}
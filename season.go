@@ -0,0 +1,156 @@
+package gochronos
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A window of days that repeats every year, from one day of the year to another inclusive, each
+// given as month*100+day, e.g. 1201 for 1 December. Windows where from is after to wrap around the
+// end of the year.
+type season struct {
+	from, to int
+}
+
+// Return a copy of the recurring time specification that only occurs in the given months, every
+// year, e.g. WithActiveMonths(12) for a job that only runs in December. Occurrences outside the
+// months are skipped, and resume when the months next come round.
+func (t *TimeSpec) WithActiveMonths(months ...time.Month) *TimeSpec {
+	result := *t
+	result.seasons = append([]season(nil), t.seasons...)
+	for _, m := range months {
+		// the days of the month in a leap year, so that February includes the 29th
+		days := time.Date(2000, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+		result.seasons = append(result.seasons, season{int(m)*100 + 1, int(m)*100 + days})
+	}
+	return &result
+}
+
+// Return a copy of the recurring time specification that only occurs from one day of the year to
+// another inclusive, every year, each given as "MM-DD", e.g. ("11-15", "02-15") for a winter
+// window that wraps around the new year. Occurrences outside the window are skipped, and resume
+// when the window next comes round. Panics if either day is malformed.
+func (t *TimeSpec) WithActiveRange(fromMonthDay, toMonthDay string) *TimeSpec {
+	from, err := parseMonthDay(fromMonthDay)
+	if err != nil {
+		panic(err.Error())
+	}
+	to, err := parseMonthDay(toMonthDay)
+	if err != nil {
+		panic(err.Error())
+	}
+	result := *t
+	result.seasons = append(append([]season(nil), t.seasons...), season{from, to})
+	return &result
+}
+
+// Parse a day of the year given as "MM-DD" to month*100+day.
+func parseMonthDay(mmdd string) (int, error) {
+	d, err := time.Parse("01-02", mmdd)
+	if err != nil {
+		return 0, fmt.Errorf("gochronos: day of the year %q must be MM-DD", mmdd)
+	}
+	return int(d.Month())*100 + d.Day(), nil
+}
+
+// Format a day of the year as "MM-DD".
+func monthDay(md int) string {
+	return fmt.Sprintf("%02d-%02d", md/100, md%100)
+}
+
+// Returns true if the day of c falls in the season.
+func (s season) contains(c time.Time) bool {
+	md := int(c.Month())*100 + c.Day()
+	if s.from <= s.to {
+		return md >= s.from && md <= s.to
+	}
+	return md >= s.from || md <= s.to
+}
+
+// Midnight at the start of the day md in the given year, in loc.
+func seasonDay(year, md int, loc *time.Location) time.Time {
+	return time.Date(year, time.Month(md/100), md%100, 0, 0, 0, 0, loc)
+}
+
+// Midnight at the end of the day md in the given year, in loc. 29 February ends with the month in
+// years that aren't leap years.
+func seasonEnd(year, md int, loc *time.Location) time.Time {
+	first := time.Date(year, time.Month(md/100), 1, 0, 0, 0, 0, loc)
+	if last := first.AddDate(0, 1, -1).Day(); md%100 >= last {
+		return first.AddDate(0, 1, 0)
+	}
+	return first.AddDate(0, 0, md%100)
+}
+
+// Returns true if c falls in any of the seasons the time spec is limited to.
+func (t *TimeSpec) inSeason(c time.Time) bool {
+	for _, s := range t.seasons {
+		if s.contains(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// The first time a season starts after c, which is outside them all.
+func (t *TimeSpec) nextSeasonStart(c time.Time) time.Time {
+	var first time.Time
+	for _, s := range t.seasons {
+		for _, year := range []int{c.Year(), c.Year() + 1} {
+			if start := seasonDay(year, s.from, c.Location()); start.After(c) && (first.IsZero() || start.Before(first)) {
+				first = start
+			}
+		}
+	}
+	return first
+}
+
+// The last time a season ended at or before c, which is outside them all.
+func (t *TimeSpec) previousSeasonEnd(c time.Time) time.Time {
+	var last time.Time
+	for _, s := range t.seasons {
+		for _, year := range []int{c.Year() - 1, c.Year()} {
+			end := seasonEnd(year, s.to, c.Location())
+			if !end.After(c) && end.After(last) {
+				last = end
+			}
+		}
+	}
+	return last
+}
+
+// The months of the seasons, if they are all whole months.
+func (t *TimeSpec) seasonMonths() ([]int, bool) {
+	var months []int
+	for _, s := range t.seasons {
+		m := time.Month(s.from / 100)
+		if s.from%100 != 1 || s.to != s.from+time.Date(2000, m+1, 0, 0, 0, 0, 0, time.UTC).Day()-1 {
+			return nil, false
+		}
+		months = append(months, int(m))
+	}
+	return months, true
+}
+
+// Describe the seasons, e.g. "12-01 to 12-31".
+func (t *TimeSpec) seasonNames() string {
+	names := make([]string, len(t.seasons))
+	for i, s := range t.seasons {
+		names[i] = monthDay(s.from) + " to " + monthDay(s.to)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Returns true if the seasons of the time specs are the same, in the same order.
+func seasonsEqual(a, b []season) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package gochronos
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestActiveMonths(t *testing.T) {
+	start := time.Date(2024, 11, 1, 8, 0, 0, 0, time.UTC)
+	noon := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"byhour":    12,
+		"byminute":  0,
+	}).WithActiveMonths(time.December)
+
+	v := NewVirtualScheduler(start)
+	var fired []time.Time
+	v.Add(noon, func(args ...interface{}) { fired = append(fired, v.Now()) })
+
+	v.AdvanceTo(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC))
+	if len(fired) != 0 {
+		t.Errorf("Expected no executions in November, got %v", fired)
+	}
+	v.AdvanceTo(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	if len(fired) != 31 || !fired[0].Equal(time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC)) ||
+		!fired[30].Equal(time.Date(2024, 12, 31, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 31 executions at noon through December, got %v", fired)
+	}
+
+	// the next occurrence is in the following December
+	expected := time.Date(2025, 12, 1, 12, 0, 0, 0, time.UTC)
+	if next := noon.NextAfter(v.Now()); !next.Equal(expected) {
+		t.Errorf("Expected the next execution at %s, got %s", expected, next)
+	}
+	if prev, ok := noon.PreviousBefore(time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)); !ok || !prev.Equal(fired[30]) {
+		t.Errorf("Expected the previous execution at %s, got %s", fired[30], prev)
+	}
+}
+
+func TestActiveRange(t *testing.T) {
+	start := time.Date(2024, 11, 10, 0, 0, 0, 0, time.UTC)
+	daily := NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_DAY})
+	winter := daily.WithActiveRange("11-14", "11-15").WithActiveRange("12-31", "01-01")
+
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	expected := []time.Time{day(2024, 11, 14), day(2024, 11, 15), day(2024, 12, 31), day(2025, 1, 1), day(2025, 11, 14)}
+	if times := winter.Between(start, day(2025, 11, 15)); !reflect.DeepEqual(times, expected) {
+		t.Errorf("Expected executions at %v, got %v", expected, times)
+	}
+	if winter.Equal(daily) {
+		t.Errorf("Expected specs with different seasons not to be equal")
+	}
+
+	data, err := winter.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling: %s", err)
+	}
+	var restored TimeSpec
+	if err := restored.UnmarshalJSON(data); err != nil {
+		t.Fatalf("Unexpected error unmarshalling: %s", err)
+	}
+	if !restored.Equal(winter) {
+		t.Errorf("Expected %s after restoring, got %s", winter, &restored)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected a panic for a malformed day of the year")
+		}
+	}()
+	daily.WithActiveRange("13-01", "12-31")
+}
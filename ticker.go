@@ -0,0 +1,66 @@
+package gochronos
+
+import (
+	"sync"
+	"time"
+)
+
+// Deliver each occurrence of the time specification on a channel, like a time.Ticker driven by
+// the specification, for use in select loops. The returned function stops the ticker; once it
+// returns, no more times are delivered. As with time.Ticker, ticks are dropped rather than queued
+// if the receiver falls behind. The channel is closed once there are no more occurrences, so a
+// one-off delivers a single tick. The ticker runs on its own scheduler, so it isn't part of the
+// default schedule.
+func (t *TimeSpec) Ticker() (<-chan time.Time, func()) {
+	return New().Ticker(t)
+}
+
+// Deliver each occurrence of the time specification on a channel, as TimeSpec.Ticker does, in the
+// scheduler's time. On a virtual scheduler, ticks are delivered as time is advanced.
+func (s *Scheduler) Ticker(ts *TimeSpec) (<-chan time.Time, func()) {
+	c := make(chan time.Time, 1)
+
+	// sends and closing the channel are serialised, so nothing is sent once it is closed
+	var lock sync.Mutex
+	closed := false
+	closeTicks := func() {
+		lock.Lock()
+		if !closed {
+			closed = true
+			close(c)
+		}
+		lock.Unlock()
+	}
+
+	var sa *ScheduledAction
+	sa = NewScheduledAction(ts, func(args ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case c <- sa.LastScheduled():
+		default:
+		}
+	}, nil)
+	if err := s.AddToSchedule(sa); err != nil {
+		closeTicks()
+		return c, func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		<-sa.Done()
+		closeTicks()
+		close(done)
+	}()
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			s.Remove(sa)
+		})
+		<-done
+	}
+}
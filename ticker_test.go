@@ -0,0 +1,53 @@
+package gochronos
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTicker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	before := runtime.NumGoroutine()
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start.Add(time.Second),
+		"frequency": FREQ_SECOND,
+	})
+
+	ticks, stop := v.Ticker(ts)
+	for i := 1; i <= 3; i++ {
+		v.Advance(time.Second)
+		select {
+		case tick := <-ticks:
+			if expected := start.Add(time.Duration(i) * time.Second); !tick.Equal(expected) {
+				t.Errorf("Expected tick %d at %s, got %s", i, expected, tick)
+			}
+		default:
+			t.Fatalf("Expected tick %d once time reached it", i)
+		}
+	}
+	stop()
+	stop()
+
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Expected the ticker's goroutine to exit when stopped, %d goroutines left over", n-before)
+	}
+	v.Advance(5 * time.Second)
+	if tick, ok := <-ticks; ok {
+		t.Errorf("Expected no ticks after stopping, got %s", tick)
+	}
+}
+
+func TestTickerOneOff(t *testing.T) {
+	at := time.Now().Add(50 * time.Millisecond)
+	ticks, stop := NewOneOff(at).Ticker()
+	defer stop()
+
+	if tick := <-ticks; !tick.Equal(at) {
+		t.Errorf("Expected a tick at %s, got %s", at, tick)
+	}
+	if _, ok := <-ticks; ok {
+		t.Errorf("Expected the channel to be closed after the only occurrence")
+	}
+}
@@ -0,0 +1,1058 @@
+package gochronos
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	FREQ_SECOND int = 1 + iota
+	FREQ_MINUTE
+	FREQ_HOUR
+	FREQ_DAY
+	FREQ_WEEK
+	FREQ_MONTH
+	FREQ_YEAR
+)
+
+// Names of the FREQ_* constants, for display.
+var freqNames = map[int]string{
+	FREQ_SECOND: "second",
+	FREQ_MINUTE: "minute",
+	FREQ_HOUR:   "hour",
+	FREQ_DAY:    "day",
+	FREQ_WEEK:   "week",
+	FREQ_MONTH:  "month",
+	FREQ_YEAR:   "year",
+}
+
+// Day codes understood by the "byday" property, in time.Weekday order.
+var dayCodes = []string{"su", "mo", "tu", "we", "th", "fr", "sa"}
+
+// A specification of when to execute an action. This can either be one-off, created by NewOneOff(), or
+// recurring, created by NewRecurring().
+type TimeSpec struct {
+	recurring bool
+	when      time.Time
+
+	// multi-shot specs execute at each of these times, in order.
+	times []time.Time
+
+	startTime time.Time
+	endTime   time.Time
+	frequency int // one of FREQ_ constants
+	interval  int
+	byDay     []time.Weekday
+	byHour    []int
+	byMinute  []int
+	maxNum    int
+
+	// days of the month, from 1, or counted back from the last day of the month, from -1.
+	byMonthDay []int
+
+	// if set, fixed periods are aligned to midnight of the start day rather than the start time
+	alignToDay bool
+
+	// fixed-delay specs execute delay after each execution completes, rather than at a fixed rate.
+	fixedDelay bool
+	delay      time.Duration
+
+	// backoff specs start with an interval of base, which doubles after each execution up to max.
+	backoff     bool
+	backoffBase time.Duration
+	backoffMax  time.Duration
+
+	// if set, the fixed period between executions, in place of the frequency and interval.
+	every time.Duration
+
+	// business hours specs execute at a fixed interval within opening hours on weekdays.
+	business *businessHours
+
+	// weekly specs execute at different times of day on different days of the week.
+	weekly *weeklySchedule
+
+	// if isoWeeks is set, occurrences are limited to odd ISO week numbers if isoOdd is set, and
+	// even ones otherwise.
+	isoWeeks bool
+	isoOdd   bool
+
+	// if set, occurrences are limited to these windows of days, every year.
+	seasons []season
+}
+
+// Returned when validating a nil or zero-value time specification, which would never execute.
+var ErrNilSpec = errors.New("gochronos: time spec is nil or empty")
+
+// Create a new one-off time specification from a Time.
+func NewOneOff(t time.Time) *TimeSpec {
+	return &TimeSpec{recurring: false, when: t}
+}
+
+// Create a new multi-shot time specification, which executes at each of the given times, in order,
+// and then terminates. This is like a batch of one-offs under a single scheduled action. As with a
+// one-off, times in the past are not executed.
+func NewTimes(times ...time.Time) *TimeSpec {
+	sorted := append([]time.Time(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+	return &TimeSpec{recurring: false, times: sorted}
+}
+
+// Create a new fixed-delay time specification. The first execution is at start, or if start is in
+// the past, delay after the action is added. Each subsequent execution is delay after the previous
+// one completes, so a slow action pushes back the executions that follow it. The "endtime" and
+// "maxnum" properties of recurring specifications aren't available.
+func NewFixedDelay(start time.Time, delay time.Duration) *TimeSpec {
+	return &TimeSpec{
+		recurring:  true,
+		startTime:  start,
+		fixedDelay: true,
+		delay:      delay,
+		interval:   1,
+		maxNum:     -1,
+	}
+}
+
+// Create a new backoff time specification. The first execution is at start, and the interval
+// between executions starts at base and doubles each time, until it reaches max, where it holds
+// steady. This suits polling something that is slow to become ready.
+func NewBackoff(start time.Time, base, max time.Duration) *TimeSpec {
+	return &TimeSpec{
+		recurring:   true,
+		startTime:   start,
+		backoff:     true,
+		backoffBase: base,
+		backoffMax:  max,
+		interval:    1,
+		maxNum:      -1,
+	}
+}
+
+// Return a copy of the recurring time specification that executes every d from start, keeping
+// only its end time and maximum number of executions.
+func (t *TimeSpec) withEvery(start time.Time, d time.Duration) *TimeSpec {
+	return &TimeSpec{
+		recurring: true,
+		startTime: start,
+		endTime:   t.endTime,
+		every:     d,
+		interval:  1,
+		maxNum:    t.maxNum,
+	}
+}
+
+// Create a new recurring time specification from a map.
+func NewRecurring(config map[string]interface{}) *TimeSpec {
+	result := &TimeSpec{
+		recurring: true,
+		interval:  1,
+		startTime: time.Time{},
+		endTime:   time.Time{},
+		frequency: -1,
+		maxNum:    -1,
+	}
+
+	for k, v := range config {
+		switch k {
+		case "starttime": // expect time
+			result.startTime = v.(time.Time)
+		case "frequency": // expect int, which should be a FREQ_* constant
+			result.frequency = v.(int)
+		case "interval": // expect int: multiplier for frequency e.g. 2 week is a fortnight
+			result.interval = v.(int)
+		case "byday": // expect string or []string of day codes: "su","mo","tu","we","th","fr","sa"
+			result.byDay = parseDays(v)
+		case "byhour": // expect int or []int of hours of the day
+			result.byHour = intList(v)
+		case "byminute": // expect int or []int of minutes of the hour
+			result.byMinute = intList(v)
+		case "bymonthday": // expect int or []int of days of the month, negative from the end
+			result.byMonthDay = intList(v)
+		case "endtime": // expect time
+			result.endTime = v.(time.Time)
+		case "maxnum": // expect int
+			result.maxNum = v.(int)
+		case "aligntoday": // expect bool: align fixed periods to midnight of the start day
+			result.alignToDay = v.(bool)
+		}
+	}
+
+	// ensure startime and frequency are provided.
+	if result.startTime.IsZero() {
+		panic("recurring scheduled action must have a start date")
+	}
+	if result.frequency < FREQ_SECOND || result.frequency > FREQ_YEAR {
+		panic("recurring scheduled action must have a frequency")
+	}
+
+	return result
+}
+
+// Return a copy of the recurring time specification with a period of the given number of minutes.
+// This expresses periods the integer interval of a coarser frequency can't, e.g. 2160 minutes for
+// every 1.5 days. Occurrences are aligned to the start time, or with "aligntoday" to midnight of
+// the start day.
+func (t *TimeSpec) WithPeriodMinutes(minutes int) *TimeSpec {
+	result := *t
+	result.frequency = FREQ_MINUTE
+	result.interval = minutes
+	return &result
+}
+
+// Return a copy of the recurring time specification that only occurs in odd ISO weeks if odd is
+// set, or even ISO weeks otherwise, e.g. for a fortnightly payroll aligned to ISO week numbers
+// rather than to the start time. Weeks are numbered as by time.Time.ISOWeek, in the location of
+// each occurrence, so week 53 and week 1 of the next year are both odd.
+func (t *TimeSpec) WithISOWeekParity(odd bool) *TimeSpec {
+	result := *t
+	result.isoWeeks = true
+	result.isoOdd = odd
+	return &result
+}
+
+// Returns true if the ISO week of c has the parity the time spec is limited to.
+func (t *TimeSpec) inISOWeek(c time.Time) bool {
+	_, week := c.ISOWeek()
+	return (week%2 == 1) == t.isoOdd
+}
+
+// The start of the ISO week containing c, i.e. midnight on its Monday, in c's location.
+func isoWeekStart(c time.Time) time.Time {
+	return time.Date(c.Year(), c.Month(), c.Day()-(int(c.Weekday())+6)%7, 0, 0, 0, 0, c.Location())
+}
+
+// Convert a day code or list of day codes to weekdays.
+func parseDays(v interface{}) []time.Weekday {
+	var codes []string
+	switch d := v.(type) {
+	case string:
+		codes = []string{d}
+	case []string:
+		codes = d
+	default:
+		panic("byday must be a string or []string")
+	}
+
+	days, err := dayList(codes)
+	if err != nil {
+		panic(err.Error())
+	}
+	return days
+}
+
+// Convert a list of day codes to weekdays.
+func dayList(codes []string) ([]time.Weekday, error) {
+	days := make([]time.Weekday, 0, len(codes))
+	for _, code := range codes {
+		found := false
+		for i, c := range dayCodes {
+			if strings.ToLower(code) == c {
+				days = append(days, time.Weekday(i))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("gochronos: unknown day code %q", code)
+		}
+	}
+	return days, nil
+}
+
+// Convert an int or list of ints to a list.
+func intList(v interface{}) []int {
+	switch i := v.(type) {
+	case int:
+		return []int{i}
+	case []int:
+		return append([]int(nil), i...)
+	}
+	panic("expected an int or []int")
+}
+
+// Describe the time specification in one line, e.g. "every 2 days from 2024-01-01T09:00:00Z".
+func (t *TimeSpec) String() string {
+	if len(t.times) > 0 {
+		return fmt.Sprintf("at %d times from %s to %s", len(t.times),
+			t.times[0].Format(time.RFC3339), t.times[len(t.times)-1].Format(time.RFC3339))
+	}
+	if !t.recurring {
+		return "once at " + t.when.Format(time.RFC3339)
+	}
+
+	if t.weekly != nil {
+		return "weekly on " + t.weekly.String()
+	}
+	if b := t.business; b != nil {
+		return fmt.Sprintf("every %s from %s to %s on weekdays in %s", b.every, clock(b.open), clock(b.close), b.loc)
+	}
+	if t.fixedDelay {
+		return fmt.Sprintf("%s after each run from %s", t.delay, t.startTime.Format(time.RFC3339))
+	}
+	if t.backoff {
+		return fmt.Sprintf("backing off from %s to %s from %s", t.backoffBase, t.backoffMax, t.startTime.Format(time.RFC3339))
+	}
+
+	var b strings.Builder
+	b.WriteString("every ")
+	if t.every > 0 {
+		b.WriteString(t.every.String())
+	} else if t.interval != 1 {
+		fmt.Fprintf(&b, "%d %ss", t.interval, freqNames[t.frequency])
+	} else {
+		b.WriteString(freqNames[t.frequency])
+	}
+	b.WriteString(" from " + t.startTime.Format(time.RFC3339))
+	if len(t.byDay) > 0 {
+		codes := make([]string, len(t.byDay))
+		for i, d := range t.byDay {
+			codes[i] = dayCodes[d]
+		}
+		b.WriteString(" on " + strings.Join(codes, ","))
+	}
+	if len(t.byMonthDay) > 0 {
+		b.WriteString(" on days " + joinInts(t.byMonthDay))
+	}
+	if len(t.byHour) > 0 {
+		b.WriteString(" at hours " + joinInts(t.byHour))
+	}
+	if len(t.byMinute) > 0 {
+		b.WriteString(" at minutes " + joinInts(t.byMinute))
+	}
+	if t.alignToDay {
+		b.WriteString(" aligned to the day")
+	}
+	if !t.endTime.IsZero() {
+		b.WriteString(" until " + t.endTime.Format(time.RFC3339))
+	}
+	if t.isoWeeks {
+		b.WriteString(" in " + isoParityName(t.isoOdd) + " ISO weeks")
+	}
+	if len(t.seasons) > 0 {
+		b.WriteString(" each year from " + t.seasonNames())
+	}
+	if t.maxNum > 0 {
+		fmt.Fprintf(&b, " at most %d times", t.maxNum)
+	}
+	return b.String()
+}
+
+// "odd" or "even".
+func isoParityName(odd bool) string {
+	if odd {
+		return "odd"
+	}
+	return "even"
+}
+
+func joinInts(list []int) string {
+	s := make([]string, len(list))
+	for i, v := range list {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ",")
+}
+
+// Check that a recurring time specification is consistent, returning an error describing the first
+// problem found. The by-* rules select times within each period of the frequency, so each rule must
+// refer to a unit that is finer than the frequency:
+//   - byminute requires FREQ_HOUR or coarser
+//   - byhour requires FREQ_DAY or coarser
+//   - byday requires FREQ_WEEK or coarser
+//
+// For example, byhour with FREQ_DAY means "these hours of every day", whereas byhour with FREQ_HOUR
+// is contradictory. A nil or zero-value time specification is reported as ErrNilSpec.
+func (t *TimeSpec) Validate() error {
+	if t == nil || (!t.recurring && t.when.IsZero() && t.times == nil) {
+		return ErrNilSpec
+	}
+	if !t.recurring {
+		return nil
+	}
+	if t.business != nil {
+		return t.business.validate()
+	}
+	if t.weekly != nil {
+		return t.weekly.validate()
+	}
+
+	if t.startTime.IsZero() {
+		return errors.New("gochronos: recurring time spec must have a start time")
+	}
+	if t.fixedDelay {
+		if t.delay <= 0 {
+			return fmt.Errorf("gochronos: fixed delay must be positive, got %s", t.delay)
+		}
+		return nil
+	}
+	if t.backoff {
+		if t.backoffBase <= 0 || t.backoffMax < t.backoffBase {
+			return fmt.Errorf("gochronos: backoff must have a positive base no greater than max, got %s and %s", t.backoffBase, t.backoffMax)
+		}
+		return nil
+	}
+	if t.every == 0 && (t.frequency < FREQ_SECOND || t.frequency > FREQ_YEAR) {
+		return errors.New("gochronos: recurring time spec must have a frequency")
+	}
+	if t.every < 0 {
+		return fmt.Errorf("gochronos: period must be positive, got %s", t.every)
+	}
+	if t.interval < 1 {
+		return fmt.Errorf("gochronos: interval must be at least 1, got %d", t.interval)
+	}
+	if t.maxNum == 0 || t.maxNum < -1 {
+		return fmt.Errorf("gochronos: maxnum must be at least 1, got %d", t.maxNum)
+	}
+
+	if len(t.byMinute) > 0 && t.frequency < FREQ_HOUR {
+		return errors.New("gochronos: byminute requires a frequency of FREQ_HOUR or coarser")
+	}
+	if len(t.byHour) > 0 && t.frequency < FREQ_DAY {
+		return errors.New("gochronos: byhour requires a frequency of FREQ_DAY or coarser")
+	}
+	if len(t.byDay) > 0 && t.frequency < FREQ_WEEK {
+		return errors.New("gochronos: byday requires a frequency of FREQ_WEEK or coarser")
+	}
+	if len(t.byMonthDay) > 0 && t.frequency < FREQ_MONTH {
+		return errors.New("gochronos: bymonthday requires a frequency of FREQ_MONTH or coarser")
+	}
+
+	for _, m := range t.byMinute {
+		if m < 0 || m > 59 {
+			return fmt.Errorf("gochronos: byminute value %d is out of range", m)
+		}
+	}
+	for _, h := range t.byHour {
+		if h < 0 || h > 23 {
+			return fmt.Errorf("gochronos: byhour value %d is out of range", h)
+		}
+	}
+	for _, d := range t.byMonthDay {
+		if d == 0 || d < -31 || d > 31 {
+			return fmt.Errorf("gochronos: bymonthday value %d is out of range", d)
+		}
+	}
+
+	return nil
+}
+
+// Given the current time, evaluate what the next execution time is according to the time spec.
+// Logic is as follows:
+// - if timespec is one-off:
+//   - if the time is in the past, return the zero value for Time. Past scheduled events are not executed.
+//   - otherwise return the time
+//
+// - if timespec is recurring:
+//   - if termination condition is met, return the zero value for Time.
+//   - compute forward from the start date, finding the closest date in the future that meets the spec, and return that.
+func (t *TimeSpec) GetNextExec() time.Time {
+	return t.NextAfter(time.Now())
+}
+
+// Evaluate the next execution time after now, or the zero time if there are none. Fixed periods are
+// aligned to the start time, truncated to the second. by-* rules are evaluated against the calendar
+// in now's location.
+func (t *TimeSpec) NextAfter(now time.Time) time.Time {
+	if t.recurring && len(t.seasons) > 0 {
+		// skip to the start of the next season until an occurrence is in one
+		u := *t
+		u.seasons = nil
+		next := u.NextAfter(now)
+		for i := 0; !next.IsZero() && i < maxSkips; i++ {
+			if t.inSeason(next) {
+				return next
+			}
+			next = u.NextAfter(t.nextSeasonStart(next).Add(-time.Nanosecond))
+		}
+		return time.Time{}
+	}
+	if t.recurring && t.isoWeeks {
+		// skip to the following week until an occurrence is in a week of the right parity
+		u := *t
+		u.isoWeeks = false
+		next := u.NextAfter(now)
+		for i := 0; !next.IsZero() && i < maxSkips; i++ {
+			if t.inISOWeek(next) {
+				return next
+			}
+			next = u.NextAfter(isoWeekStart(next).AddDate(0, 0, 7).Add(-time.Nanosecond))
+		}
+		return time.Time{}
+	}
+	if t.recurring {
+		// if termination condition is met, return zero time
+		if !t.endTime.IsZero() && t.endTime.Before(now) {
+			return time.Time{}
+		}
+
+		// if start time is in the future, return that. Periods aligned to the day instead start
+		// at the first boundary from the start time, and by-* rules at the first matching
+		// occurrence at or after it.
+		if t.startTime.After(now) {
+			if !t.alignToDay && !t.hasRules() {
+				return t.startTime
+			}
+			now = t.startTime.Add(-time.Nanosecond)
+		}
+
+		var next time.Time
+		if t.fixedDelay {
+			// the action is being reevaluated as it completes, so the delay runs from now
+			next = now.Add(t.delay)
+		} else if t.business != nil {
+			next = t.business.nextAfter(now)
+		} else if t.weekly != nil {
+			next = t.weekly.nextAfter(now)
+		} else if t.backoff {
+			next = t.nextBackoff(t.startTime, now)
+		} else if t.hasRules() || t.frequency >= FREQ_MONTH {
+			// months and years don't have a fixed length, so are matched against the calendar
+			next = t.nextMatching(now)
+		} else if period := t.period(); period > 0 {
+			// it's a fixed period, which excludes months and years
+			base := t.startTime.Truncate(time.Second)
+			if t.alignToDay {
+				base = midnight(t.startTime)
+			}
+			n := now.Sub(base)/period + 1
+			next = base.Add(n * period)
+		}
+
+		if !t.endTime.IsZero() && next.After(t.endTime) {
+			return time.Time{}
+		}
+		return next
+	} else if t.times != nil {
+		i := sort.Search(len(t.times), func(i int) bool {
+			return t.times[i].After(now)
+		})
+		if i == len(t.times) {
+			return time.Time{}
+		}
+		return t.times[i]
+	} else {
+		if !t.when.After(now) {
+			return time.Time{}
+		}
+		return t.when
+	}
+}
+
+// Evaluate the next execution time after now, as NextAfter does, along with an explanation if there
+// is none, e.g. "one-off in the past" or "end time passed". The explanation is empty if there is a
+// next execution. This is useful for finding out why an action never runs.
+func (t *TimeSpec) Explain(now time.Time) (time.Time, string) {
+	if err := t.Validate(); err == ErrNilSpec {
+		return time.Time{}, "empty time spec"
+	} else if err != nil {
+		return time.Time{}, "invalid: " + strings.TrimPrefix(err.Error(), "gochronos: ")
+	}
+
+	next := t.NextAfter(now)
+	switch {
+	case !next.IsZero():
+		return next, ""
+	case t.times != nil:
+		return next, "all times in the past"
+	case !t.recurring:
+		return next, "one-off in the past"
+	case !t.endTime.IsZero() && !t.withoutEnd().NextAfter(now).IsZero():
+		return next, "end time passed"
+	case t.hasRules():
+		return next, "no satisfiable by-day/hour combination"
+	}
+	return next, "no further occurrences"
+}
+
+// A copy of the time specification without its end time.
+func (t *TimeSpec) withoutEnd() *TimeSpec {
+	open := *t
+	open.endTime = time.Time{}
+	return &open
+}
+
+// Find the next execution of a backoff sequence anchored at from, after now.
+func (t *TimeSpec) nextBackoff(from, now time.Time) time.Time {
+	next := from
+	d := t.backoffBase
+	for !next.After(now) {
+		if d >= t.backoffMax {
+			// the interval has stopped growing, so skip straight to the next step
+			n := now.Sub(next)/t.backoffMax + 1
+			return next.Add(n * t.backoffMax)
+		}
+		next = next.Add(d)
+		d *= 2
+		if d > t.backoffMax {
+			d = t.backoffMax
+		}
+	}
+	return next
+}
+
+// The times the time specification occurs from start up to end. maxnum isn't taken into account,
+// as it depends on how many times an action has already executed.
+func (t *TimeSpec) Between(start, end time.Time) []time.Time {
+	var times []time.Time
+	t.ForEachOccurrence(start, end, func(next time.Time) bool {
+		times = append(times, next)
+		return true
+	})
+	return times
+}
+
+// Call fn with each time the time specification occurs from start up to end, in order, stopping
+// early if fn returns false. Unlike Between, the occurrences aren't collected, so this is suitable
+// for huge windows.
+func (t *TimeSpec) ForEachOccurrence(start, end time.Time, fn func(time.Time) bool) {
+	for next := t.NextAfter(start.Add(-time.Nanosecond)); !next.IsZero() && next.Before(end); next = t.NextAfter(next) {
+		if !fn(next) {
+			return
+		}
+	}
+}
+
+// The most recent occurrence of the time specification at or before now, according to the
+// specification alone rather than any run history. Returns false if there is none, including for
+// fixed-delay specifications, whose occurrences depend on when executions complete. The by-*
+// rules are applied as they are by NextAfter.
+func (t *TimeSpec) PreviousBefore(now time.Time) (time.Time, bool) {
+	if t.recurring && len(t.seasons) > 0 {
+		u := *t
+		u.seasons = nil
+		prev, ok := u.PreviousBefore(now)
+		for i := 0; ok && i < maxSkips; i++ {
+			if t.inSeason(prev) {
+				return prev, true
+			}
+			prev, ok = u.PreviousBefore(t.previousSeasonEnd(prev).Add(-time.Nanosecond))
+		}
+		return time.Time{}, false
+	}
+	if t.recurring && t.isoWeeks {
+		u := *t
+		u.isoWeeks = false
+		prev, ok := u.PreviousBefore(now)
+		for i := 0; ok && i < maxSkips; i++ {
+			if t.inISOWeek(prev) {
+				return prev, true
+			}
+			prev, ok = u.PreviousBefore(isoWeekStart(prev).Add(-time.Nanosecond))
+		}
+		return time.Time{}, false
+	}
+	if !t.recurring {
+		if t.times != nil {
+			i := sort.Search(len(t.times), func(i int) bool {
+				return t.times[i].After(now)
+			})
+			if i == 0 {
+				return time.Time{}, false
+			}
+			return t.times[i-1], true
+		}
+		if t.when.IsZero() || t.when.After(now) {
+			return time.Time{}, false
+		}
+		return t.when, true
+	}
+
+	if !t.endTime.IsZero() && t.endTime.Before(now) {
+		now = t.endTime
+	}
+	if t.fixedDelay || t.startTime.After(now) {
+		return time.Time{}, false
+	}
+
+	var prev time.Time
+	switch {
+	case t.business != nil:
+		prev = t.business.previousBefore(now)
+	case t.weekly != nil:
+		prev = t.weekly.previousBefore(now)
+	case t.backoff:
+		for next := t.startTime; !next.After(now); next = t.nextBackoff(t.startTime, next) {
+			prev = next
+		}
+	case t.hasRules() || t.frequency >= FREQ_MONTH:
+		prev = t.previousMatching(now)
+	default:
+		period := t.period()
+		if period <= 0 {
+			return time.Time{}, false
+		}
+		base := t.startTime.Truncate(time.Second)
+		if t.alignToDay {
+			base = midnight(t.startTime)
+		}
+		prev = base.Add(now.Sub(base) / period * period)
+		if prev.Before(t.startTime) {
+			// the start time is the first occurrence
+			prev = t.startTime
+		}
+	}
+	return prev, !prev.IsZero()
+}
+
+// Find the latest time at or before now that satisfies the by-* rules. This mirrors
+// nextMatching, visiting candidate days backwards.
+func (t *TimeSpec) previousMatching(now time.Time) time.Time {
+	loc := now.Location()
+	start := t.startTime.In(loc)
+	hours := t.candidateHours(start)
+	minutes := t.candidateMinutes(start)
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for i := 0; i < t.dayLimit() && civilDay(day) >= civilDay(start); i++ {
+		if t.dayMatches(day, start) {
+			for h := len(hours) - 1; h >= 0; h-- {
+				for m := len(minutes) - 1; m >= 0; m-- {
+					c := time.Date(day.Year(), day.Month(), day.Day(), hours[h], minutes[m], start.Second(), 0, loc)
+					if !c.After(now) && t.matches(c, start) {
+						return c
+					}
+				}
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return time.Time{}
+}
+
+// Returns true if the two time specifications are semantically equal, i.e. they describe the same
+// occurrences in the same way. Times are compared as instants, and the order of by-* rules
+// doesn't matter.
+func (t *TimeSpec) Equal(o *TimeSpec) bool {
+	if t == nil || o == nil {
+		return t == o
+	}
+	if t.recurring != o.recurring || !t.when.Equal(o.when) || len(t.times) != len(o.times) ||
+		!t.startTime.Equal(o.startTime) || !t.endTime.Equal(o.endTime) ||
+		t.frequency != o.frequency || t.interval != o.interval || t.maxNum != o.maxNum ||
+		t.alignToDay != o.alignToDay || t.fixedDelay != o.fixedDelay || t.delay != o.delay ||
+		t.backoff != o.backoff || t.backoffBase != o.backoffBase || t.backoffMax != o.backoffMax ||
+		t.every != o.every || t.isoWeeks != o.isoWeeks || (t.isoWeeks && t.isoOdd != o.isoOdd) ||
+		!seasonsEqual(t.seasons, o.seasons) {
+		return false
+	}
+	for i := range t.times {
+		if !t.times[i].Equal(o.times[i]) {
+			return false
+		}
+	}
+	if !intSetsEqual(t.byHour, o.byHour) || !intSetsEqual(t.byMinute, o.byMinute) ||
+		!intSetsEqual(t.byMonthDay, o.byMonthDay) ||
+		!intSetsEqual(weekdayInts(t.byDay), weekdayInts(o.byDay)) {
+		return false
+	}
+	if (t.business == nil) != (o.business == nil) || (t.business != nil &&
+		(t.business.loc.String() != o.business.loc.String() || t.business.open != o.business.open ||
+			t.business.close != o.business.close || t.business.every != o.business.every)) {
+		return false
+	}
+	if (t.weekly == nil) != (o.weekly == nil) || (t.weekly != nil && t.weekly.String() != o.weekly.String()) {
+		return false
+	}
+	return true
+}
+
+// Returns true if the lists hold the same values, ignoring order.
+func intSetsEqual(a, b []int) bool {
+	a, b = sortedInts(a), sortedInts(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func weekdayInts(days []time.Weekday) []int {
+	list := make([]int, len(days))
+	for i, d := range days {
+		list[i] = int(d)
+	}
+	return list
+}
+
+// The period of a recurring time specification, i.e. its frequency multiplied by its interval.
+// Returns false for one-off, fixed-delay and backoff specifications, and for months and years, which don't
+// have a fixed length. by-* rules select times within each period, so don't affect it.
+func (t *TimeSpec) Period() (time.Duration, bool) {
+	if !t.recurring {
+		return 0, false
+	}
+	period := t.period()
+	return period, period > 0
+}
+
+// The fixed period of the time spec, or 0 for frequencies that don't have a fixed length.
+func (t *TimeSpec) period() time.Duration {
+	if t.fixedDelay || t.backoff {
+		return 0
+	}
+	if t.every > 0 {
+		return t.every
+	}
+	var period time.Duration
+	switch t.frequency {
+	case FREQ_SECOND:
+		period = time.Second
+	case FREQ_MINUTE:
+		period = time.Minute
+	case FREQ_HOUR:
+		period = time.Hour
+	case FREQ_DAY:
+		period = 24 * time.Hour
+	case FREQ_WEEK:
+		period = 7 * 24 * time.Hour
+	}
+	return period * time.Duration(t.interval)
+}
+
+// The number of occurrences sampled to find the shortest gap of specs without a fixed period.
+const gapSamples = 64
+
+// The shortest time between consecutive occurrences of the time spec after now, or 0 if it occurs
+// at most once. For a fixed delay, this is the delay, and for a backoff, its base. Specs without a
+// fixed period, such as those with by-* rules, are sampled.
+func (t *TimeSpec) shortestGap(now time.Time) time.Duration {
+	switch {
+	case !t.recurring:
+		var gap time.Duration
+		for i := 1; i < len(t.times); i++ {
+			if d := t.times[i].Sub(t.times[i-1]); gap == 0 || d < gap {
+				gap = d
+			}
+		}
+		return gap
+	case t.fixedDelay:
+		return t.delay
+	case t.backoff:
+		return t.backoffBase
+	case t.business != nil:
+		return t.business.every
+	case t.weekly == nil && !t.hasRules() && t.frequency < FREQ_MONTH && len(t.seasons) == 0 && !t.isoWeeks:
+		return t.period()
+	}
+
+	var gap time.Duration
+	prev := t.NextAfter(now)
+	for i := 0; i < gapSamples && !prev.IsZero(); i++ {
+		next := t.NextAfter(prev)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(prev); gap == 0 || d < gap {
+			gap = d
+		}
+		prev = next
+	}
+	return gap
+}
+
+// Returns true if any by-* rules are set.
+func (t *TimeSpec) hasRules() bool {
+	return len(t.byDay) > 0 || len(t.byHour) > 0 || len(t.byMinute) > 0 || len(t.byMonthDay) > 0
+}
+
+// Find the next time after now that satisfies the by-* rules. Rather than stepping through time,
+// candidate days are visited in turn, skipping those that can't match, and for each day only the
+// hours and minutes allowed by the rules are considered, in order. Finer fields are taken from the
+// start time.
+func (t *TimeSpec) nextMatching(now time.Time) time.Time {
+	loc := now.Location()
+	start := t.startTime.In(loc)
+	hours := t.candidateHours(start)
+	minutes := t.candidateMinutes(start)
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	for i := 0; i < t.dayLimit(); i++ {
+		if t.dayMatches(day, start) {
+			for _, h := range hours {
+				for _, m := range minutes {
+					c := time.Date(day.Year(), day.Month(), day.Day(), h, m, start.Second(), 0, loc)
+					if c.After(now) && t.matches(c, start) {
+						return c
+					}
+				}
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// The hours of the day that can match, in order.
+func (t *TimeSpec) candidateHours(start time.Time) []int {
+	switch {
+	case len(t.byHour) > 0:
+		return sortedInts(t.byHour)
+	case t.frequency >= FREQ_DAY:
+		return []int{start.Hour()}
+	}
+	all := make([]int, 24)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+// The minutes of the hour that can match, in order.
+func (t *TimeSpec) candidateMinutes(start time.Time) []int {
+	if len(t.byMinute) > 0 {
+		return sortedInts(t.byMinute)
+	}
+	return []int{start.Minute()}
+}
+
+// Returns true if anything on the given day could satisfy the day-level rules and the interval.
+func (t *TimeSpec) dayMatches(day, start time.Time) bool {
+	if civilDay(day) < civilDay(start) || !t.dayAllowed(day, start) {
+		return false
+	}
+	if t.frequency >= FREQ_DAY {
+		return periodsBetween(t.frequency, start, day)%t.interval == 0
+	}
+	return true
+}
+
+// The number of days to search for a match, which covers a full cycle of the interval.
+func (t *TimeSpec) dayLimit() int {
+	switch t.frequency {
+	case FREQ_HOUR:
+		return t.interval/24 + 2
+	case FREQ_DAY:
+		return t.interval + 1
+	case FREQ_WEEK:
+		return 7 * (t.interval + 1)
+	case FREQ_MONTH:
+		// months that don't have the day are skipped, so cover a year of the interval
+		return 366*t.interval + 31
+	}
+	// 29 February can be eight years from the last
+	return 366 * (8*t.interval + 1)
+}
+
+func sortedInts(list []int) []int {
+	sorted := append([]int(nil), list...)
+	sort.Ints(sorted)
+	return sorted
+}
+
+// Returns true if c satisfies the by-* rules and the interval. Units that are finer than the
+// frequency and not constrained by a rule must match the start time.
+func (t *TimeSpec) matches(c, start time.Time) bool {
+	if c.Before(start) {
+		return false
+	}
+
+	if len(t.byMinute) > 0 {
+		if !containsInt(t.byMinute, c.Minute()) {
+			return false
+		}
+	} else if t.frequency >= FREQ_HOUR && c.Minute() != start.Minute() {
+		return false
+	}
+
+	if len(t.byHour) > 0 {
+		if !containsInt(t.byHour, c.Hour()) {
+			return false
+		}
+	} else if t.frequency >= FREQ_DAY && c.Hour() != start.Hour() {
+		return false
+	}
+
+	if !t.dayAllowed(c, start) {
+		return false
+	}
+
+	return periodsBetween(t.frequency, start, c)%t.interval == 0
+}
+
+// Returns true if the day of c satisfies the day-level rules. Without byday or bymonthday, weekly
+// specs occur on the weekday of the start time, monthly ones on its day of the month, and yearly
+// ones on its day of the year, so months that don't have that day are skipped.
+func (t *TimeSpec) dayAllowed(c, start time.Time) bool {
+	if len(t.byDay) > 0 {
+		if !containsDay(t.byDay, c.Weekday()) {
+			return false
+		}
+	} else if t.frequency == FREQ_WEEK && c.Weekday() != start.Weekday() {
+		return false
+	}
+
+	if len(t.byMonthDay) > 0 {
+		return containsMonthDay(t.byMonthDay, c)
+	}
+	if len(t.byDay) > 0 {
+		return true
+	}
+	if t.frequency >= FREQ_MONTH && c.Day() != start.Day() {
+		return false
+	}
+	return t.frequency != FREQ_YEAR || c.Month() == start.Month()
+}
+
+// Returns true if c's day of the month is in the list, where negative days count back from the
+// last day of the month.
+func containsMonthDay(list []int, c time.Time) bool {
+	last := time.Date(c.Year(), c.Month()+1, 0, 0, 0, 0, 0, c.Location()).Day()
+	for _, d := range list {
+		if d == c.Day() || d < 0 && last+1+d == c.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// The number of calendar periods of the given frequency between the periods containing a and b.
+func periodsBetween(frequency int, a, b time.Time) int {
+	switch frequency {
+	case FREQ_HOUR:
+		return (civilDay(b)-civilDay(a))*24 + b.Hour() - a.Hour()
+	case FREQ_DAY:
+		return civilDay(b) - civilDay(a)
+	case FREQ_WEEK:
+		return (weekStart(b) - weekStart(a)) / 7
+	case FREQ_MONTH:
+		return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+	case FREQ_YEAR:
+		return b.Year() - a.Year()
+	}
+	return 0
+}
+
+// The day number of t's calendar date, counted from the unix epoch.
+func civilDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// The day number of the Monday that starts t's week.
+func weekStart(t time.Time) int {
+	return civilDay(t) - (int(t.Weekday())+6)%7
+}
+
+func containsInt(list []int, v int) bool {
+	for _, i := range list {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDay(list []time.Weekday, d time.Weekday) bool {
+	for _, i := range list {
+		if i == d {
+			return true
+		}
+	}
+	return false
+}
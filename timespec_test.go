@@ -0,0 +1,564 @@
+package gochronos
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateRules(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config map[string]interface{}
+		valid  bool
+	}{
+		{"byminute with FREQ_HOUR", map[string]interface{}{"frequency": FREQ_HOUR, "byminute": []int{0, 30}}, true},
+		{"byminute with FREQ_MINUTE", map[string]interface{}{"frequency": FREQ_MINUTE, "byminute": 15}, false},
+		{"byhour with FREQ_DAY", map[string]interface{}{"frequency": FREQ_DAY, "byhour": []int{9, 17}}, true},
+		{"byhour with FREQ_HOUR", map[string]interface{}{"frequency": FREQ_HOUR, "byhour": 9}, false},
+		{"byhour with FREQ_SECOND", map[string]interface{}{"frequency": FREQ_SECOND, "byhour": 9}, false},
+		{"byday with FREQ_WEEK", map[string]interface{}{"frequency": FREQ_WEEK, "byday": []string{"mo", "fr"}}, true},
+		{"byday with FREQ_DAY", map[string]interface{}{"frequency": FREQ_DAY, "byday": "mo"}, false},
+		{"byhour out of range", map[string]interface{}{"frequency": FREQ_DAY, "byhour": 24}, false},
+		{"byminute out of range", map[string]interface{}{"frequency": FREQ_DAY, "byminute": -1}, false},
+	}
+
+	for _, test := range tests {
+		test.config["starttime"] = start
+		err := NewRecurring(test.config).Validate()
+		if test.valid && err != nil {
+			t.Errorf("%s: expected spec to be valid, got error %s", test.name, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: expected spec to be invalid", test.name)
+		}
+	}
+}
+
+func TestNextExecByRules(t *testing.T) {
+	// Monday
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"byday":     []string{"mo", "we"},
+		"byhour":    []int{9, 17},
+	})
+
+	expected := []time.Time{
+		time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 3, 17, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC),
+	}
+
+	now := start
+	for _, e := range expected {
+		now = ts.NextAfter(now)
+		if !now.Equal(e) {
+			t.Fatalf("Expected next execution at %s, got %s", e, now)
+		}
+	}
+}
+
+func TestNextExecMultipleRules(t *testing.T) {
+	// Monday
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		now      time.Time
+		expected []time.Time
+	}{
+		{
+			"weekdays at 9:00, 9:30, 17:00 and 17:30",
+			map[string]interface{}{
+				"frequency": FREQ_WEEK,
+				"byday":     []string{"mo", "tu", "we", "th", "fr"},
+				"byhour":    []int{17, 9},
+				"byminute":  []int{30, 0},
+			},
+			time.Date(2024, 1, 5, 17, 15, 0, 0, time.UTC),
+			[]time.Time{
+				time.Date(2024, 1, 5, 17, 30, 0, 0, time.UTC),
+				time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 8, 9, 30, 0, 0, time.UTC),
+				time.Date(2024, 1, 8, 17, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			"fortnightly on tuesdays at 8:45",
+			map[string]interface{}{
+				"frequency": FREQ_WEEK,
+				"interval":  2,
+				"byday":     "tu",
+				"byhour":    8,
+				"byminute":  45,
+			},
+			start,
+			[]time.Time{
+				time.Date(2024, 1, 2, 8, 45, 0, 0, time.UTC),
+				time.Date(2024, 1, 16, 8, 45, 0, 0, time.UTC),
+				time.Date(2024, 1, 30, 8, 45, 0, 0, time.UTC),
+			},
+		},
+		{
+			"every 5 hours at 10 and 40 past",
+			map[string]interface{}{
+				"frequency": FREQ_HOUR,
+				"interval":  5,
+				"byminute":  []int{10, 40},
+			},
+			time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC),
+			[]time.Time{
+				time.Date(2024, 1, 1, 5, 10, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 5, 40, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 10, 10, 0, 0, time.UTC),
+			},
+		},
+		{
+			"every 52 weeks on fridays",
+			map[string]interface{}{
+				"frequency": FREQ_WEEK,
+				"interval":  52,
+				"byday":     "fr",
+				"byhour":    12,
+			},
+			time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC),
+			[]time.Time{
+				time.Date(2025, 1, 3, 12, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test.config["starttime"] = start
+		ts := NewRecurring(test.config)
+		if err := ts.Validate(); err != nil {
+			t.Fatalf("%s: unexpected error %s", test.name, err)
+		}
+		now := test.now
+		for _, e := range test.expected {
+			now = ts.NextAfter(now)
+			if !now.Equal(e) {
+				t.Errorf("%s: expected next execution at %s, got %s", test.name, e, now)
+				break
+			}
+		}
+	}
+}
+
+// The next execution of a spec that is almost a year away shouldn't take many candidates to find.
+func BenchmarkNextMatching(b *testing.B) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"interval":  52,
+		"byday":     []string{"mo", "fr"},
+		"byhour":    []int{9, 17},
+		"byminute":  []int{0, 30},
+	})
+	now := time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ts.NextAfter(now)
+	}
+}
+
+func TestNextExecFixedPeriod(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 10, 0, time.UTC)
+
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+		"interval":  15,
+	})
+
+	now := time.Date(2024, 1, 1, 1, 7, 0, 0, time.UTC)
+	expected := time.Date(2024, 1, 1, 1, 15, 10, 0, time.UTC)
+	if next := ts.NextAfter(now); !next.Equal(expected) {
+		t.Errorf("Expected next execution at %s, got %s", expected, next)
+	}
+}
+
+func TestPeriodMinutes(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+	}).WithPeriodMinutes(2160)
+
+	if err := ts.Validate(); err != nil {
+		t.Fatalf("Expected period in minutes to be valid, got %s", err)
+	}
+
+	// occurrences are exactly 1.5 days apart
+	next := ts.NextAfter(start)
+	for i := 1; i <= 4; i++ {
+		expected := start.Add(time.Duration(i) * 36 * time.Hour)
+		if !next.Equal(expected) {
+			t.Errorf("Expected occurrence %d at %s, got %s", i, expected, next)
+		}
+		next = ts.NextAfter(next)
+	}
+
+	// aligned to the day, occurrences fall on boundaries from midnight of the start day
+	aligned := NewRecurring(map[string]interface{}{
+		"starttime":  start,
+		"frequency":  FREQ_DAY,
+		"aligntoday": true,
+	}).WithPeriodMinutes(2160)
+
+	before := start.Add(-time.Hour)
+	for _, expected := range []time.Time{
+		time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 4, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 5, 12, 0, 0, 0, time.UTC),
+	} {
+		next := aligned.NextAfter(before)
+		if !next.Equal(expected) {
+			t.Errorf("Expected aligned occurrence at %s, got %s", expected, next)
+		}
+		before = next
+	}
+}
+
+func TestTimeSpecString(t *testing.T) {
+	when := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	if s := NewOneOff(when).String(); s != "once at 2024-01-01T09:00:00Z" {
+		t.Errorf("Unexpected one-off description %q", s)
+	}
+
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": when,
+		"frequency": FREQ_WEEK,
+		"interval":  2,
+		"byday":     []string{"mo", "fr"},
+		"byhour":    9,
+	})
+	if s := ts.String(); s != "every 2 weeks from 2024-01-01T09:00:00Z on mo,fr at hours 9" {
+		t.Errorf("Unexpected recurring description %q", s)
+	}
+}
+
+func TestPeriod(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	fortnightly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"interval":  2,
+	})
+	if p, ok := fortnightly.Period(); !ok || p != 336*time.Hour {
+		t.Errorf("Expected 2-week period to be 336h, got %s (%v)", p, ok)
+	}
+
+	monthly := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MONTH,
+	})
+	if _, ok := monthly.Period(); ok {
+		t.Errorf("Expected monthly spec to have no fixed period")
+	}
+
+	if _, ok := NewOneOff(start).Period(); ok {
+		t.Errorf("Expected one-off spec to have no period")
+	}
+}
+
+func TestNewTimes(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	times := []time.Time{
+		start.Add(5 * time.Hour),
+		start.Add(90 * time.Minute),
+		start.Add(26 * time.Hour),
+	}
+	var fired []time.Time
+	sa := v.Add(NewTimes(times...), func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+
+	v.AdvanceTo(start.AddDate(0, 0, 7))
+
+	expected := []time.Time{times[1], times[0], times[2]}
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected %d executions, got %v", len(expected), fired)
+	}
+	for i := range expected {
+		if !fired[i].Equal(expected[i]) {
+			t.Errorf("Expected execution %d at %s, got %s", i, expected[i], fired[i])
+		}
+	}
+	if sa.State() != STATE_DONE || sa.RunCount() != 3 {
+		t.Errorf("Expected multi-shot action to be done after 3 runs, got %s after %d", sa.State(), sa.RunCount())
+	}
+}
+
+func TestNewBackoff(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := NewBackoff(start, time.Second, 10*time.Second)
+	if err := ts.Validate(); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// the interval doubles until it hits the cap, then stays there
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second}
+	prev := ts.NextAfter(start.Add(-time.Second))
+	if !prev.Equal(start) {
+		t.Fatalf("Expected first execution at the start, got %s", prev)
+	}
+	for i, e := range expected {
+		next := ts.NextAfter(prev)
+		if gap := next.Sub(prev); gap != e {
+			t.Errorf("Expected interval %d to be %s, got %s", i, e, gap)
+		}
+		prev = next
+	}
+
+	if err := NewBackoff(start, time.Minute, time.Second).Validate(); err == nil {
+		t.Errorf("Expected a max smaller than the base to be invalid")
+	}
+}
+
+func TestBetween(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+	})
+
+	times := ts.Between(start, start.AddDate(0, 0, 3))
+	if len(times) != 3 || !times[0].Equal(start) || !times[2].Equal(start.AddDate(0, 0, 2)) {
+		t.Errorf("Expected 3 daily occurrences from the start, got %v", times)
+	}
+}
+
+func TestForEachOccurrence(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+	})
+	end := start.AddDate(1, 0, 0)
+
+	n := 0
+	var last time.Time
+	allocs := testing.AllocsPerRun(10, func() {
+		n = 0
+		ts.ForEachOccurrence(start, end, func(at time.Time) bool {
+			last = at
+			n++
+			return n < 1000
+		})
+	})
+
+	if n != 1000 || !last.Equal(start.Add(999*time.Second)) {
+		t.Errorf("Expected to stop after 1000 occurrences at %s, got %d ending %s", start.Add(999*time.Second), n, last)
+	}
+	if allocs > 2 {
+		t.Errorf("Expected enumeration not to allocate per occurrence, got %v allocations", allocs)
+	}
+}
+
+func TestPreviousBefore(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	everyFiveSeconds := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_SECOND,
+		"interval":  5,
+	})
+	twiceDaily := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"byhour":    []int{9, 18},
+		"byminute":  30,
+	})
+
+	tests := []struct {
+		name     string
+		ts       *TimeSpec
+		now      time.Time
+		expected time.Time
+		ok       bool
+	}{
+		{"between seconds", everyFiveSeconds, start.Add(17*time.Second + 300*time.Millisecond), start.Add(15 * time.Second), true},
+		{"on a second", everyFiveSeconds, start.Add(20 * time.Second), start.Add(20 * time.Second), true},
+		{"before the start", everyFiveSeconds, start.Add(-time.Second), time.Time{}, false},
+		{"later the same day", twiceDaily, time.Date(2024, 1, 3, 20, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 18, 30, 0, 0, time.UTC), true},
+		{"early in the day", twiceDaily, time.Date(2024, 1, 3, 8, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 18, 30, 0, 0, time.UTC), true},
+		{"between rules", twiceDaily, time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC), time.Date(2024, 1, 3, 9, 30, 0, 0, time.UTC), true},
+		{"before the first match", twiceDaily, time.Date(2024, 1, 1, 9, 15, 0, 0, time.UTC), time.Time{}, false},
+		{"one-off", NewOneOff(start), start.Add(time.Hour), start, true},
+	}
+
+	for _, test := range tests {
+		prev, ok := test.ts.PreviousBefore(test.now)
+		if ok != test.ok || !prev.Equal(test.expected) {
+			t.Errorf("%s: expected %s (%v), got %s (%v)", test.name, test.expected, test.ok, prev, ok)
+		}
+	}
+}
+
+func TestTimeSpecEqual(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	a := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"byday":     []string{"mo", "fr"},
+		"byhour":    []int{9, 17},
+	})
+	b := NewRecurring(map[string]interface{}{
+		"starttime": start.In(time.FixedZone("X", 3600)),
+		"frequency": FREQ_WEEK,
+		"byday":     []string{"fr", "mo"},
+		"byhour":    []int{17, 9},
+	})
+	c := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"byday":     []string{"mo"},
+		"byhour":    []int{9, 17},
+	})
+
+	if !a.Equal(b) {
+		t.Errorf("Expected specs with the same rules in a different order to be equal")
+	}
+	if a.Equal(c) {
+		t.Errorf("Expected specs with different rules not to be equal")
+	}
+	if a.Equal(NewOneOff(start)) || !NewOneOff(start).Equal(NewOneOff(start)) {
+		t.Errorf("Expected one-offs to be equal only to one-offs at the same time")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	now := start.AddDate(0, 1, 0)
+	v := NewVirtualScheduler(start)
+
+	tests := []struct {
+		name   string
+		ts     *TimeSpec
+		reason string
+	}{
+		{"empty", &TimeSpec{}, "empty time spec"},
+		{"invalid", NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_HOUR,
+			"byhour":    9,
+		}), "invalid: byhour requires a frequency of FREQ_DAY or coarser"},
+		{"past one-off", NewOneOff(start), "one-off in the past"},
+		{"past times", NewTimes(start, start.Add(time.Hour)), "all times in the past"},
+		{"ended", NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_DAY,
+			"endtime":   start.AddDate(0, 0, 7),
+		}), "end time passed"},
+		{"running", NewRecurring(map[string]interface{}{
+			"starttime": start,
+			"frequency": FREQ_DAY,
+		}), ""},
+	}
+	for _, test := range tests {
+		next, reason := test.ts.Explain(now)
+		if reason != test.reason || next.IsZero() != (test.reason != "") {
+			t.Errorf("%s: expected reason %q, got %q with next %s", test.name, test.reason, reason, next)
+		}
+	}
+
+	// the action knows how many times it has executed
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_HOUR,
+		"maxnum":    2,
+	}), func(args ...interface{}) {})
+	v.Advance(3 * time.Hour)
+	if _, reason := sa.Explain(v.Now()); reason != "maxnum reached" {
+		t.Errorf("Expected reason %q, got %q", "maxnum reached", reason)
+	}
+}
+
+func TestISOWeekParity(t *testing.T) {
+	start := time.Date(2026, 12, 7, 9, 0, 0, 0, time.UTC)
+	mondays := NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_WEEK,
+		"byday":     "mo",
+		"byhour":    9,
+		"byminute":  0,
+	})
+	at := func(month time.Month, day int) time.Time {
+		year := 2026
+		if month == time.January {
+			year = 2027
+		}
+		return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+	}
+
+	// 2026 has 53 ISO weeks, so weeks 53 and 1 are both odd
+	odd := mondays.WithISOWeekParity(true)
+	expected := []time.Time{at(time.December, 14), at(time.December, 28), at(time.January, 4), at(time.January, 18)}
+	if times := odd.Between(start, at(time.January, 25)); !reflect.DeepEqual(times, expected) {
+		t.Errorf("Expected odd ISO weeks at %v, got %v", expected, times)
+	}
+	even := mondays.WithISOWeekParity(false)
+	expected = []time.Time{at(time.December, 7), at(time.December, 21), at(time.January, 11), at(time.January, 25)}
+	if times := even.Between(start, at(time.January, 26)); !reflect.DeepEqual(times, expected) {
+		t.Errorf("Expected even ISO weeks at %v, got %v", expected, times)
+	}
+
+	if prev, ok := odd.PreviousBefore(at(time.January, 12)); !ok || !prev.Equal(at(time.January, 4)) {
+		t.Errorf("Expected the previous odd ISO week to be %s, got %s", at(time.January, 4), prev)
+	}
+	if odd.Equal(even) || odd.Equal(mondays) {
+		t.Errorf("Expected specs with different ISO week parities not to be equal")
+	}
+
+	data, err := odd.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling: %s", err)
+	}
+	var restored TimeSpec
+	if err := restored.UnmarshalJSON(data); err != nil || !restored.Equal(odd) {
+		t.Errorf("Expected JSON to restore %s, got %s (%v)", odd, &restored, err)
+	}
+}
+
+func TestFutureStartWithRules(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		config   map[string]interface{}
+		expected time.Time
+	}{
+		{map[string]interface{}{"frequency": FREQ_DAY, "byhour": []int{9, 17}, "byminute": 0}, time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC)},
+		{map[string]interface{}{"frequency": FREQ_DAY, "interval": 2, "byhour": 9, "byminute": 0}, time.Date(2024, 1, 3, 9, 0, 0, 0, time.UTC)},
+		{map[string]interface{}{"frequency": FREQ_DAY, "byhour": 10, "byminute": 30}, start},
+	}
+	for _, test := range tests {
+		test.config["starttime"] = start
+		ts := NewRecurring(test.config)
+		if next := ts.NextAfter(now); !next.Equal(test.expected) {
+			t.Errorf("Expected %s to execute first at %s, got %s", ts, test.expected, next)
+		}
+	}
+
+	v := NewVirtualScheduler(now)
+	var fired []time.Time
+	v.Add(NewRecurring(tests[0].config), func(args ...interface{}) { fired = append(fired, v.Now()) })
+	v.AdvanceTo(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC))
+	expected := []time.Time{time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Errorf("Expected executions at %v, got %v", expected, fired)
+	}
+}
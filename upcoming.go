@@ -0,0 +1,122 @@
+package gochronos
+
+import (
+	"sync"
+	"time"
+)
+
+// The next occurrences of each action in a schedule, kept so they can be served repeatedly, e.g.
+// to an admin page, without being recomputed each time.
+type upcomingCache struct {
+	lock    sync.Mutex
+	n       int
+	ttl     time.Duration
+	entries map[*ScheduledAction]upcomingEntry
+}
+
+// The next occurrences of an action, computed at a time, from a time spec in a state. The entry is
+// stale once the time spec or state changes.
+type upcomingEntry struct {
+	times    []time.Time
+	computed time.Time
+	spec     *TimeSpec
+	state    State
+}
+
+// Cache the next n occurrences of each action in the default schedule.
+func UpcomingCache(n int, ttl time.Duration) {
+	defaultScheduler.UpcomingCache(n, ttl)
+}
+
+// The cached next occurrences of an action in the default schedule.
+func UpcomingFor(sa *ScheduledAction) []time.Time {
+	return defaultScheduler.UpcomingFor(sa)
+}
+
+// Compute the next n occurrences of each action in the schedule and cache them, so that
+// UpcomingFor can serve them without recomputing, e.g. for a page listing the next runs of
+// hundreds of jobs. Occurrences are computed as by OccurrencesBetween. An action's entry is
+// refreshed when it is ttl old, once its first occurrence has passed, and when its schedule
+// changes, e.g. with SetTimeSpec, Snooze or Pause. Calling it again replaces the cache.
+func (s *Scheduler) UpcomingCache(n int, ttl time.Duration) {
+	c := &upcomingCache{n: n, ttl: ttl, entries: make(map[*ScheduledAction]upcomingEntry)}
+	now := s.now()
+	for _, sa := range s.ordered() {
+		c.entries[sa] = c.compute(sa, now)
+	}
+
+	s.lock.Lock()
+	s.upcoming = c
+	s.lock.Unlock()
+}
+
+// The next occurrences of an action in the schedule, as cached by UpcomingCache, refreshing them
+// if the entry is stale. Returns nil if the action isn't in the schedule, or if UpcomingCache
+// hasn't been called. The result must not be modified.
+func (s *Scheduler) UpcomingFor(sa *ScheduledAction) []time.Time {
+	s.lock.Lock()
+	c := s.upcoming
+	live := s.actions[sa]
+	s.lock.Unlock()
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !live {
+		delete(c.entries, sa)
+		return nil
+	}
+	now := s.now()
+	if e, ok := c.entries[sa]; ok && c.fresh(e, sa, now) {
+		return e.times
+	}
+	e := c.compute(sa, now)
+	c.entries[sa] = e
+	return e.times
+}
+
+// Drop the cached occurrences of an action whose schedule has changed, if there are any.
+func (s *Scheduler) invalidateUpcoming(sa *ScheduledAction) {
+	s.lock.Lock()
+	c := s.upcoming
+	s.lock.Unlock()
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	delete(c.entries, sa)
+	c.lock.Unlock()
+}
+
+// Compute the next occurrences of an action after now.
+func (c *upcomingCache) compute(sa *ScheduledAction, now time.Time) upcomingEntry {
+	return upcomingEntry{times: sa.upcoming(now, c.n), computed: now, spec: sa.spec(), state: sa.State()}
+}
+
+// Returns true if the entry for an action can still be served at now.
+func (c *upcomingCache) fresh(e upcomingEntry, sa *ScheduledAction, now time.Time) bool {
+	if now.Sub(e.computed) >= c.ttl || e.spec != sa.spec() || e.state != sa.State() {
+		return false
+	}
+	return len(e.times) == 0 || e.times[0].After(now)
+}
+
+// The next n times the action will execute after now, computed as by between.
+func (sa *ScheduledAction) upcoming(now time.Time, n int) []time.Time {
+	if state := sa.State(); state == STATE_DISABLED || state == STATE_PAUSED {
+		return nil
+	}
+	remaining := -1
+	if ts := sa.spec(); ts.maxNum > 0 {
+		remaining = ts.maxNum - sa.RunCount()
+	}
+
+	var times []time.Time
+	for t := sa.findNext(now, false); !t.IsZero() && len(times) < n && remaining != 0; t = sa.findNext(t, false) {
+		times = append(times, t)
+		remaining--
+	}
+	return times
+}
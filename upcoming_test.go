@@ -0,0 +1,59 @@
+package gochronos
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpcomingCache(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	f := func(args ...interface{}) {}
+	hourly := v.Add(NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_HOUR}), f)
+	daily := v.Add(NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_DAY}), f)
+
+	if times := v.UpcomingFor(hourly); times != nil {
+		t.Errorf("Expected no upcoming times before caching, got %v", times)
+	}
+	v.UpcomingCache(3, time.Hour)
+
+	for _, sa := range []*ScheduledAction{hourly, daily} {
+		fresh := sa.upcoming(v.Now(), 3)
+		if times := v.UpcomingFor(sa); len(times) != 3 || !reflect.DeepEqual(times, fresh) {
+			t.Errorf("Expected cached times %v, got %v", fresh, times)
+		}
+	}
+	cached := v.UpcomingFor(hourly)
+	if times := v.UpcomingFor(hourly); &times[0] != &cached[0] {
+		t.Errorf("Expected the cached times to be served again")
+	}
+
+	// changing the schedule invalidates only that action's entry
+	dailyTimes := v.UpcomingFor(daily)
+	hourly.SetTimeSpec(NewRecurring(map[string]interface{}{"starttime": start, "frequency": FREQ_MINUTE, "interval": 30}))
+	expected := []time.Time{start.Add(30 * time.Minute), start.Add(time.Hour), start.Add(90 * time.Minute)}
+	if times := v.UpcomingFor(hourly); !reflect.DeepEqual(times, expected) {
+		t.Errorf("Expected %v after changing the time spec, got %v", expected, times)
+	}
+	if times := v.UpcomingFor(daily); &times[0] != &dailyTimes[0] {
+		t.Errorf("Expected the other action's entry to still be cached")
+	}
+
+	// once the first occurrence has passed, the entry is refreshed
+	v.Advance(45 * time.Minute)
+	if times := v.UpcomingFor(hourly); !reflect.DeepEqual(times, append(expected[1:], start.Add(2*time.Hour))) {
+		t.Errorf("Expected the times to move on after an execution, got %v", times)
+	}
+
+	// entries are refreshed once they are older than the ttl
+	v.Advance(time.Hour)
+	if times := v.UpcomingFor(daily); &times[0] == &dailyTimes[0] || !reflect.DeepEqual(times, dailyTimes) {
+		t.Errorf("Expected the entry to be recomputed after the ttl, got %v", times)
+	}
+
+	v.Remove(daily)
+	if times := v.UpcomingFor(daily); times != nil {
+		t.Errorf("Expected no upcoming times for a removed action, got %v", times)
+	}
+}
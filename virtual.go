@@ -0,0 +1,149 @@
+package gochronos
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A clock that only moves when it is set.
+type virtualClock struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+func (c *virtualClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t
+}
+
+func (c *virtualClock) set(t time.Time) {
+	c.lock.Lock()
+	c.t = t
+	c.lock.Unlock()
+}
+
+// A VirtualScheduler is a scheduler that runs in virtual time, for testing. It doesn't use timers
+// or goroutines; instead, time is moved forward with AdvanceTo, which executes the actions that
+// fall due in order. Time specifications are evaluated exactly as they are by a real scheduler.
+type VirtualScheduler struct {
+	*Scheduler
+	clock *virtualClock
+}
+
+// Create a new virtual scheduler whose time starts at start.
+func NewVirtualScheduler(start time.Time, opts ...SchedulerOption) *VirtualScheduler {
+	clock := &virtualClock{t: start}
+	opts = append(opts, WithClock(clock))
+	s := New(opts...)
+	s.driven = true
+	s.ticks = clock
+	return &VirtualScheduler{Scheduler: s, clock: clock}
+}
+
+// The current virtual time.
+func (v *VirtualScheduler) Now() time.Time {
+	return v.clock.Now()
+}
+
+// Move virtual time forward to t, executing every action that falls due at or before t. Actions
+// are executed in the order they are due, with the virtual time set to each execution time, and
+// their schedules are updated as they would be in real time. Actions that fall due at the same
+// time are executed in the order they were added.
+func (v *VirtualScheduler) AdvanceTo(t time.Time) {
+	for {
+		sa, next := v.due(t)
+		if sa == nil {
+			break
+		}
+		v.clock.set(next)
+		sa.fire(next)
+		if sa.State() != STATE_DONE {
+			sa.reschedule(sa.nextAfter(next))
+		}
+	}
+	if t.After(v.clock.Now()) {
+		v.clock.set(t)
+	}
+}
+
+// Move virtual time forward by d.
+func (v *VirtualScheduler) Advance(d time.Duration) {
+	v.AdvanceTo(v.Now().Add(d))
+}
+
+// Drive the scheduler from an external tick source, such as a game loop or a simulation, instead
+// of timers. The scheduler's time is the time of the latest tick, and actions only execute
+// when Tick is called.
+func WithExternalTicks() SchedulerOption {
+	return func(s *Scheduler) {
+		s.ticks = &virtualClock{t: time.Now()}
+		s.clock = s.ticks
+		s.driven = true
+	}
+}
+
+// Deliver a tick, executing every action that is due at or before now. Each due action executes
+// once, in the order they are due, even if it missed several occurrences since the last tick,
+// and is then rescheduled after now. Ticks should be delivered in increasing time order; until
+// the first tick, the scheduler's time is the time it was created. Tick has
+// no effect on a scheduler that uses timers.
+func (s *Scheduler) Tick(now time.Time) {
+	if s.ticks == nil {
+		return
+	}
+	s.ticks.set(now)
+	for _, d := range s.allDue(now) {
+		d.sa.fire(d.next)
+		if d.sa.State() != STATE_DONE {
+			d.sa.reschedule(d.sa.nextAfter(now))
+		}
+	}
+}
+
+// An action that is due, and the time it is due.
+type dueAction struct {
+	sa   *ScheduledAction
+	next time.Time
+}
+
+// Find all the actions that are due at or before t, in the order they are due.
+func (s *Scheduler) allDue(t time.Time) []dueAction {
+	s.lock.Lock()
+	var due []dueAction
+	for sa := range s.actions {
+		if next, ok := sa.NextExecution(); ok && !next.After(t) {
+			due = append(due, dueAction{sa, next})
+		}
+	}
+	s.lock.Unlock()
+
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].next.Equal(due[j].next) {
+			return due[i].next.Before(due[j].next)
+		}
+		return due[i].sa.seq < due[j].sa.seq
+	})
+	return due
+}
+
+// Find the action that is due first, at or before t.
+func (s *Scheduler) due(t time.Time) (*ScheduledAction, time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var first *ScheduledAction
+	var firstNext time.Time
+	for sa := range s.actions {
+		next, ok := sa.NextExecution()
+		if !ok || next.After(t) {
+			continue
+		}
+		if first == nil || next.Before(firstNext) || (next.Equal(firstNext) && sa.seq < first.seq) {
+			first = sa
+			firstNext = next
+		}
+	}
+	return first, firstNext
+}
@@ -0,0 +1,131 @@
+package gochronos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualScheduler(t *testing.T) {
+	// Monday
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	type fire struct {
+		name string
+		at   time.Time
+	}
+	var fired []fire
+	f := func(args ...interface{}) {
+		fired = append(fired, fire{args[0].(string), v.Now()})
+	}
+
+	// daily at 9:00 and 17:00
+	v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_DAY,
+		"byhour":    []int{9, 17},
+	}), f, "daily")
+
+	// once on Wednesday at noon
+	v.Add(NewOneOff(time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)), f, "once")
+
+	v.AdvanceTo(start.AddDate(0, 0, 7))
+
+	var expected []fire
+	for d := 1; d <= 7; d++ {
+		expected = append(expected, fire{"daily", time.Date(2024, 1, d, 9, 0, 0, 0, time.UTC)})
+		if d == 3 {
+			expected = append(expected, fire{"once", time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)})
+		}
+		expected = append(expected, fire{"daily", time.Date(2024, 1, d, 17, 0, 0, 0, time.UTC)})
+	}
+
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected %d executions, got %d: %v", len(expected), len(fired), fired)
+	}
+	for i := range expected {
+		if fired[i].name != expected[i].name || !fired[i].at.Equal(expected[i].at) {
+			t.Errorf("Execution %d: expected %s at %s, got %s at %s", i, expected[i].name, expected[i].at, fired[i].name, fired[i].at)
+		}
+	}
+
+	if v.Count() != 1 {
+		t.Errorf("Expected only the recurring action to remain, schedule contains %d item(s)", v.Count())
+	}
+	if !v.Now().Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("Expected virtual time to be advanced to the target, is %s", v.Now())
+	}
+}
+
+func TestVirtualRemove(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+
+	count := 0
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) {
+		count++
+	})
+
+	v.Advance(5 * time.Minute)
+	v.Remove(sa)
+	v.Advance(5 * time.Minute)
+
+	if count != 5 {
+		t.Errorf("Expected 5 executions before removal, got %d", count)
+	}
+	if v.Count() != 0 || sa.State() != STATE_DONE {
+		t.Errorf("Expected removed action to be done and not in the schedule")
+	}
+}
+
+func TestIsVirtual(t *testing.T) {
+	if !NewVirtualScheduler(time.Now()).IsVirtual() {
+		t.Errorf("Expected virtual scheduler to report being virtual")
+	}
+	if New().IsVirtual() {
+		t.Errorf("Expected real scheduler not to report being virtual")
+	}
+}
+
+func TestExternalTicks(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New(WithExternalTicks())
+	s.Tick(start)
+
+	var fired []time.Time
+	s.Add(NewRecurring(map[string]interface{}{
+		"starttime": start.Add(time.Minute),
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) {
+		fired = append(fired, s.now())
+	})
+
+	ticks := []time.Time{
+		start.Add(30 * time.Second),
+		start.Add(time.Minute),
+		start.Add(90 * time.Second),
+		// several missed occurrences only execute once
+		start.Add(5 * time.Minute),
+		start.Add(5*time.Minute + 30*time.Second),
+		start.Add(6 * time.Minute),
+	}
+	for _, tick := range ticks {
+		s.Tick(tick)
+	}
+
+	expected := []time.Time{start.Add(time.Minute), start.Add(5 * time.Minute), start.Add(6 * time.Minute)}
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected executions at %v, got %v", expected, fired)
+	}
+	for i := range expected {
+		if !fired[i].Equal(expected[i]) {
+			t.Errorf("Expected execution %d at %v, got %v", i, expected[i], fired[i])
+		}
+	}
+	if !s.IsVirtual() {
+		t.Errorf("Expected a tick-driven scheduler to be virtual")
+	}
+}
@@ -0,0 +1,159 @@
+package gochronos
+
+import (
+	"context"
+	"errors"
+)
+
+// Returned when waiting for an action that is done, so it will not execute again.
+var ErrActionDone = errors.New("gochronos: action is done")
+
+// Returns a channel that is closed when the action is done, because it was removed or has no more
+// executions.
+func (sa *ScheduledAction) Done() <-chan struct{} {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	return sa.finishedChan()
+}
+
+// Block until the action next executes and completes. Returns ctx's error if ctx is cancelled
+// first, or ErrActionDone if the action is done before executing again.
+func (sa *ScheduledAction) WaitForNextFire(ctx context.Context) error {
+	sa.lock.Lock()
+	fired := sa.firedChan()
+	finished := sa.finishedChan()
+	sa.lock.Unlock()
+
+	select {
+	case <-fired:
+		return nil
+	case <-finished:
+		return ErrActionDone
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Retire the action without interrupting it: stop scheduling it, block until any execution in
+// flight completes, then remove it from its schedule. Unlike Remove, a run that has started is
+// allowed to finish, and unlike Pause, the action doesn't come back. If ctx is cancelled before
+// the run in flight completes, the action is removed straight away, as by Remove, and ctx's error
+// is returned.
+func (sa *ScheduledAction) Drain(ctx context.Context) error {
+	sa.lock.Lock()
+	sa.draining = true
+	var idle chan struct{}
+	if sa.inFlight > 0 {
+		idle = sa.idleChan()
+	}
+	sa.lock.Unlock()
+
+	var err error
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if sa.scheduler != nil {
+		sa.scheduler.Remove(sa)
+	}
+	return err
+}
+
+// Count a run as in flight, returning false if the action is draining, so it mustn't start.
+func (sa *ScheduledAction) beginRun() bool {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	if sa.draining {
+		return false
+	}
+	sa.inFlight++
+	return true
+}
+
+// Count a run in flight as complete, waking Drain if there are none left.
+func (sa *ScheduledAction) endRun() {
+	sa.lock.Lock()
+	sa.inFlight--
+	if sa.inFlight == 0 && sa.idle != nil {
+		close(sa.idle)
+		sa.idle = nil
+	}
+	sa.lock.Unlock()
+}
+
+// The channel closed when no runs are in flight. sa.lock must be held.
+func (sa *ScheduledAction) idleChan() chan struct{} {
+	if sa.idle == nil {
+		sa.idle = make(chan struct{})
+	}
+	return sa.idle
+}
+
+// Block until the default schedule drains.
+func Wait() {
+	defaultScheduler.Wait()
+}
+
+// Block until the default schedule drains, or ctx is cancelled.
+func WaitCtx(ctx context.Context) error {
+	return defaultScheduler.WaitCtx(ctx)
+}
+
+// Block until the schedule drains, i.e. every action has completed or been removed. This never
+// returns while a recurring action without an end remains in the schedule.
+func (s *Scheduler) Wait() {
+	s.WaitCtx(context.Background())
+}
+
+// Block until the schedule drains, as Wait does. Returns nil once it has drained, or ctx's error if
+// ctx is cancelled first. Time doesn't move while waiting on a virtual scheduler, so it only drains
+// if it is advanced concurrently.
+func (s *Scheduler) WaitCtx(ctx context.Context) error {
+	for {
+		s.lock.Lock()
+		var sa *ScheduledAction
+		for a := range s.actions {
+			sa = a
+			break
+		}
+		s.lock.Unlock()
+		if sa == nil {
+			return nil
+		}
+
+		select {
+		case <-sa.Done():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Wake anything waiting for the action to execute.
+func (sa *ScheduledAction) broadcastFired() {
+	sa.lock.Lock()
+	if sa.fired != nil {
+		close(sa.fired)
+		sa.fired = nil
+	}
+	sa.lock.Unlock()
+}
+
+// The channel closed after the next run. sa.lock must be held.
+func (sa *ScheduledAction) firedChan() chan struct{} {
+	if sa.fired == nil {
+		sa.fired = make(chan struct{})
+	}
+	return sa.fired
+}
+
+// The channel closed when the action is done. sa.lock must be held.
+func (sa *ScheduledAction) finishedChan() chan struct{} {
+	if sa.finished == nil {
+		sa.finished = make(chan struct{})
+	}
+	return sa.finished
+}
@@ -0,0 +1,176 @@
+package gochronos
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForNextFire(t *testing.T) {
+	s := New()
+	ts := NewRecurring(map[string]interface{}{
+		"starttime": time.Now(),
+		"frequency": FREQ_SECOND,
+	})
+	var fireTimes []time.Time
+	sa := s.Add(ts, func(args ...interface{}) {
+		fireTimes = append(fireTimes, time.Now())
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := sa.WaitForNextFire(ctx); err != nil {
+		t.Fatalf("Expected to wait for the next fire, got %s", err)
+	}
+	returned := time.Now()
+
+	if n := sa.RunCount(); n != 1 {
+		t.Fatalf("Expected one run after waiting, got %d", n)
+	}
+	if lag := returned.Sub(fireTimes[0]); lag > 50*time.Millisecond {
+		t.Errorf("Expected wait to return promptly after the fire, took %s", lag)
+	}
+
+	// a cancelled context returns its error
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if err := sa.WaitForNextFire(short); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+
+	// once removed, waiting for the action reports that it's done
+	s.Remove(sa)
+	<-sa.Done()
+	if err := sa.WaitForNextFire(ctx); err != ErrActionDone {
+		t.Errorf("Expected ErrActionDone after removing the action, got %v", err)
+	}
+}
+
+func TestWaitCtx(t *testing.T) {
+	s := New()
+	var lock sync.Mutex
+	count := 0
+	f := func(args ...interface{}) {
+		lock.Lock()
+		count++
+		lock.Unlock()
+	}
+	s.Add(NewOneOff(time.Now().Add(100*time.Millisecond)), f)
+	s.Add(NewOneOff(time.Now().Add(200*time.Millisecond)), f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.WaitCtx(ctx); err != nil {
+		t.Fatalf("Expected schedule to drain, got %s", err)
+	}
+	lock.Lock()
+	if count != 2 {
+		t.Errorf("Expected both one-offs to execute before the schedule drained, got %d", count)
+	}
+	lock.Unlock()
+
+	// the schedule doesn't drain in time
+	sa := s.Add(NewOneOff(time.Now().Add(time.Hour)), f)
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if err := s.WaitCtx(short); err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline error, got %v", err)
+	}
+	s.Remove(sa)
+}
+
+func TestDrain(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start.Add(time.Second),
+		"frequency": FREQ_SECOND,
+	}), func(args ...interface{}) {
+		close(started)
+		<-release
+	})
+
+	// the first run blocks until it is released, so it is in flight while draining
+	advanced := make(chan struct{})
+	go func() {
+		v.Advance(time.Second)
+		close(advanced)
+	}()
+	<-started
+	drained := make(chan error)
+	go func() {
+		drained <- sa.Drain(context.Background())
+	}()
+	select {
+	case <-drained:
+		t.Fatalf("Expected draining to wait for the run in flight")
+	default:
+	}
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Expected to drain the action, got %s", err)
+	}
+	<-advanced
+
+	if v.Count() != 0 {
+		t.Errorf("Expected the action to be removed after draining, schedule has %d", v.Count())
+	}
+	v.Advance(10 * time.Second)
+	if n := sa.RunCount(); n != 1 {
+		t.Errorf("Expected no further runs after draining, got %d runs", n)
+	}
+	select {
+	case <-sa.Done():
+	default:
+		t.Errorf("Expected the drained action to be done")
+	}
+}
+
+func TestDrainRunTimeout(t *testing.T) {
+	s := New()
+	started := make(chan struct{}, 10)
+	var lock sync.Mutex
+	completed := 0
+	sa := s.Add(NewOneOff(time.Now().Add(50*time.Millisecond)), func(args ...interface{}) {
+		started <- struct{}{}
+		time.Sleep(300 * time.Millisecond)
+		lock.Lock()
+		completed++
+		lock.Unlock()
+	}, WithRunTimeout(50*time.Millisecond))
+
+	<-started
+	if err := sa.Drain(context.Background()); err != nil {
+		t.Fatalf("Expected to drain the action, got %s", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if completed != 1 {
+		t.Errorf("Expected draining to wait for a run that timed out to complete, completed %d", completed)
+	}
+}
+
+func TestDrainAddAgain(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	sa := v.Add(NewRecurring(map[string]interface{}{
+		"starttime": start,
+		"frequency": FREQ_MINUTE,
+	}), func(args ...interface{}) {})
+
+	v.Advance(90 * time.Second)
+	if err := sa.Drain(context.Background()); err != nil {
+		t.Fatalf("Expected to drain the action, got %s", err)
+	}
+	runs := sa.RunCount()
+	if err := v.AddToSchedule(sa); err != nil {
+		t.Fatalf("Expected to add the drained action again, got %s", err)
+	}
+	v.Advance(2 * time.Minute)
+	if n := sa.RunCount(); n <= runs {
+		t.Errorf("Expected a drained action added again to run, got %d runs", n)
+	}
+}
@@ -0,0 +1,120 @@
+package gochronos
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Times of day on each day of the week, executed every week.
+type weeklySchedule struct {
+	// offsets from midnight, sorted, indexed by time.Weekday.
+	days [7][]time.Duration
+}
+
+// Create a new time specification that executes at different times on different days of the week,
+// e.g. {"mo": {"09:00"}, "we": {"14:00"}, "fr": {"17:00"}}. Keys are day codes as understood by
+// the "byday" property, and times are given as "HH:MM". Times are evaluated against the calendar
+// in the location of the time they are evaluated from, as by-* rules are. Panics if a day code or
+// time is malformed.
+func NewWeeklySchedule(times map[string][]string) *TimeSpec {
+	w, err := weeklyFrom(times)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &TimeSpec{
+		recurring: true,
+		weekly:    w,
+		interval:  1,
+		maxNum:    -1,
+	}
+}
+
+// Parse a weekly schedule from day codes and "HH:MM" times.
+func weeklyFrom(times map[string][]string) (*weeklySchedule, error) {
+	w := &weeklySchedule{}
+	for code, clocks := range times {
+		days, err := dayList([]string{code})
+		if err != nil {
+			return nil, err
+		}
+		for _, hhmm := range clocks {
+			offset, err := parseClock(hhmm)
+			if err != nil {
+				return nil, err
+			}
+			w.days[days[0]] = append(w.days[days[0]], offset)
+		}
+	}
+	for i := range w.days {
+		sort.Slice(w.days[i], func(a, b int) bool { return w.days[i][a] < w.days[i][b] })
+	}
+	return w, nil
+}
+
+// The schedule as day codes and "HH:MM" times.
+func (w *weeklySchedule) clocks() map[string][]string {
+	times := make(map[string][]string)
+	for d, offsets := range w.days {
+		for _, offset := range offsets {
+			times[dayCodes[d]] = append(times[dayCodes[d]], clock(offset))
+		}
+	}
+	return times
+}
+
+// Describe the schedule, e.g. "mo 09:00, we 14:00".
+func (w *weeklySchedule) String() string {
+	var parts []string
+	for d, offsets := range w.days {
+		for _, offset := range offsets {
+			parts = append(parts, dayCodes[d]+" "+clock(offset))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Check that the schedule has at least one time.
+func (w *weeklySchedule) validate() error {
+	for _, offsets := range w.days {
+		if len(offsets) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("gochronos: weekly schedule must have at least one time")
+}
+
+// The next execution after now.
+func (w *weeklySchedule) nextAfter(now time.Time) time.Time {
+	day := midnight(now)
+	for i := 0; i < 8; i++ {
+		for _, offset := range w.days[day.Weekday()] {
+			if at := w.at(day, offset); at.After(now) {
+				return at
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// The latest execution at or before now.
+func (w *weeklySchedule) previousBefore(now time.Time) time.Time {
+	day := midnight(now)
+	for i := 0; i < 8; i++ {
+		offsets := w.days[day.Weekday()]
+		for j := len(offsets) - 1; j >= 0; j-- {
+			if at := w.at(day, offsets[j]); !at.After(now) {
+				return at
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return time.Time{}
+}
+
+// The time on day at the given offset from midnight, by the clock.
+func (w *weeklySchedule) at(day time.Time, offset time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, day.Location())
+}
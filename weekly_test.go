@@ -0,0 +1,62 @@
+package gochronos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWeeklySchedule(t *testing.T) {
+	ts := NewWeeklySchedule(map[string][]string{
+		"mo": {"09:00"},
+		"we": {"14:00"},
+		"fr": {"17:00", "08:30"},
+	})
+	if err := ts.Validate(); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	// Wednesday, after that day's time
+	start := time.Date(2024, 1, 3, 15, 0, 0, 0, time.UTC)
+	v := NewVirtualScheduler(start)
+	var fired []time.Time
+	v.Add(ts, func(args ...interface{}) {
+		fired = append(fired, v.Now())
+	})
+	v.AdvanceTo(start.AddDate(0, 0, 7))
+
+	expected := []time.Time{
+		time.Date(2024, 1, 5, 8, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 5, 17, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 10, 14, 0, 0, 0, time.UTC),
+	}
+	if len(fired) != len(expected) {
+		t.Fatalf("Expected executions at %v, got %v", expected, fired)
+	}
+	for i := range expected {
+		if !fired[i].Equal(expected[i]) {
+			t.Errorf("Expected execution %d at %s, got %s", i, expected[i], fired[i])
+		}
+	}
+
+	if prev, ok := ts.PreviousBefore(start); !ok || !prev.Equal(time.Date(2024, 1, 3, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected previous occurrence on Wednesday at 14:00, got %s", prev)
+	}
+
+	// the schedule survives saving
+	data, err := ts.MarshalJSON()
+	if err != nil {
+		t.Fatalf("Unexpected error marshalling: %s", err)
+	}
+	var restored TimeSpec
+	if err := restored.UnmarshalJSON(data); err != nil {
+		t.Fatalf("Unexpected error unmarshalling: %s", err)
+	}
+	if a, b := ts.NextAfter(start), restored.NextAfter(start); !a.Equal(b) {
+		t.Errorf("Expected restored spec to produce %s, got %s", a, b)
+	}
+
+	if err := NewWeeklySchedule(map[string][]string{}).Validate(); err == nil {
+		t.Errorf("Expected error validating an empty weekly schedule")
+	}
+}